@@ -101,6 +101,33 @@ func (bt *BoltTranslator) Get(frame string, id uint64) (val interface{}) {
 	return val
 }
 
+// LookupValue returns the value mapped to id in frame. ok is false if id
+// hasn't been mapped.
+func (bt *BoltTranslator) LookupValue(frame string, id uint64) (val interface{}, ok bool, err error) {
+	bt.fmu.RLock()
+	_, ok = bt.frames[frame]
+	bt.fmu.RUnlock()
+	if !ok {
+		return nil, false, errors.Errorf("unknown frame '%v'", frame)
+	}
+	var data []byte
+	err = bt.Db.View(func(tx *bolt.Tx) error {
+		idBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(idBytes, id)
+		if v := tx.Bucket(idBucket).Bucket([]byte(frame)).Get(idBytes); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "reading id bucket")
+	}
+	if data == nil {
+		return nil, false, nil
+	}
+	return data, true, nil
+}
+
 // GetID maps val (which must be a byte slice) to a monotonic id.
 func (bt *BoltTranslator) GetID(frame string, val interface{}) (id uint64, err error) {
 	// ensure frame existence
@@ -161,6 +188,48 @@ func (bt *BoltTranslator) GetID(frame string, val interface{}) (id uint64, err e
 	return id, nil
 }
 
+// LookupID returns the id of val (a []byte or string) without allocating one.
+func (bt *BoltTranslator) LookupID(frame string, val interface{}) (id uint64, ok bool, err error) {
+	var bsval []byte
+	switch valt := val.(type) {
+	case []byte:
+		bsval = valt
+	case string:
+		bsval = []byte(valt)
+	default:
+		return 0, false, errors.Errorf("val %v of type %T for frame %v not supported by BoltTranslator - must be a []byte. ", val, val, frame)
+	}
+	err = bt.Db.View(func(tx *bolt.Tx) error {
+		fvb := tx.Bucket(valBucket).Bucket([]byte(frame))
+		if fvb == nil {
+			return nil
+		}
+		if ret := fvb.Get(bsval); len(ret) == 8 {
+			id, ok = binary.BigEndian.Uint64(ret), true
+		}
+		return nil
+	})
+	return id, ok, err
+}
+
+// RangeKeys calls fn with each value mapped in frame, in byte order, until fn
+// returns false.
+func (bt *BoltTranslator) RangeKeys(frame string, fn func(key string) bool) error {
+	return bt.Db.View(func(tx *bolt.Tx) error {
+		fvb := tx.Bucket(valBucket).Bucket([]byte(frame))
+		if fvb == nil {
+			return nil
+		}
+		c := fvb.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if !fn(string(k)) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
 func (bt *BoltTranslator) BulkAdd(frame string, values [][]byte) error {
 	var batchSize uint64 = 10000
 	var batch uint64 = 0
@@ -0,0 +1,247 @@
+package pdk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint records the progress of a long running import in a JSON file, so
+// that an interrupted import can be resumed without duplicating or
+// overwriting columns.
+//
+// Inputs (e.g. URLs, or chunks of a file) are identified by name. Before the
+// records of an input are mapped, Reserve allocates a block of consecutive
+// column IDs for them, and once they have all been handed to the Indexer,
+// SetDone marks the input complete. Save flushes the Indexer and writes the
+// file. When an import is resumed, complete inputs are skipped, and inputs
+// which were in progress are given the same column IDs as before, so
+// importing them again is idempotent.
+type Checkpoint struct {
+	path string
+
+	mu    sync.Mutex
+	state checkpointState
+}
+
+type checkpointState struct {
+	// NextID is the lowest column ID which has not been reserved.
+	NextID uint64 `json:"next-id"`
+	// Done is the set of inputs which have been imported completely.
+	Done map[string]bool `json:"done"`
+	// Reserved holds the first column ID of each input in progress.
+	Reserved map[string]uint64 `json:"reserved"`
+	// Params are the settings which the names of inputs depend on (see
+	// Require).
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// NewCheckpoint creates a Checkpoint which is saved to path. If resume is set,
+// the state is loaded from path (if it exists), otherwise the import starts
+// from scratch and path is overwritten by the first Save. An empty path gives
+// a Checkpoint which is never written.
+func NewCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path: path,
+		state: checkpointState{
+			Done:     make(map[string]bool),
+			Reserved: make(map[string]uint64),
+			Params:   make(map[string]string),
+		},
+	}
+	if !resume || path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("no checkpoint at %v, starting from the beginning", path)
+		return c, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading checkpoint")
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return nil, errors.Wrapf(err, "decoding checkpoint %v", path)
+	}
+	if c.state.Done == nil {
+		c.state.Done = make(map[string]bool)
+	}
+	if c.state.Reserved == nil {
+		c.state.Reserved = make(map[string]uint64)
+	}
+	if c.state.Params == nil {
+		c.state.Params = make(map[string]string)
+	}
+	log.Printf("resuming from %v: %d inputs done, %d in progress, next column %d", path, len(c.state.Done), len(c.state.Reserved), c.state.NextID)
+	return c, nil
+}
+
+// Reserve returns the first of n consecutive column IDs for input. If input
+// already has a reservation, because it was in progress when the checkpoint
+// was saved, the same IDs are returned again.
+func (c *Checkpoint) Reserve(input string, n uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if first, ok := c.state.Reserved[input]; ok {
+		return first
+	}
+	first := c.state.NextID
+	c.state.Reserved[input] = first
+	c.state.NextID += n
+	return first
+}
+
+// Require records that the names of inputs depend on the setting key having
+// value val, e.g. on how a file was split into chunks. It returns an error if
+// the checkpoint being resumed was saved with a different value, as its inputs
+// wouldn't match those of this import.
+func (c *Checkpoint) Require(key, val string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.state.Params[key]; ok && prev != val {
+		return errors.Errorf("checkpoint was saved with %v %v, not %v; resume with the same settings or start again", key, prev, val)
+	}
+	c.state.Params[key] = val
+	return nil
+}
+
+// SetDone marks input as imported completely. Its bits must already have been
+// added to the Indexer which is flushed by Save.
+func (c *Checkpoint) SetDone(input string) {
+	c.mu.Lock()
+	c.state.Done[input] = true
+	c.mu.Unlock()
+}
+
+// InputProgress counts the records of an input which have not been handed to
+// the Indexer yet, and marks the input done when none are left.
+type InputProgress struct {
+	ckpt    *Checkpoint
+	input   string
+	pending int64
+}
+
+// Start returns an InputProgress for the n records of input. If n is 0, input
+// is marked done immediately.
+func (c *Checkpoint) Start(input string, n int) *InputProgress {
+	if n == 0 {
+		c.SetDone(input)
+	}
+	return &InputProgress{ckpt: c, input: input, pending: int64(n)}
+}
+
+// Add counts n more records of the input, for inputs whose size isn't known
+// when they are started. It must be called while at least one record is
+// pending, e.g. by starting the input with one record for reading it, which
+// is marked Done once it has been read.
+func (p *InputProgress) Add(n int) {
+	atomic.AddInt64(&p.pending, int64(n))
+}
+
+// Done is called when a record has been handed to the Indexer, or skipped.
+func (p *InputProgress) Done() {
+	if atomic.AddInt64(&p.pending, -1) == 0 {
+		p.ckpt.SetDone(p.input)
+	}
+}
+
+// Done reports whether input was imported completely.
+func (c *Checkpoint) Done(input string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Done[input]
+}
+
+// NextID returns the lowest column ID which has not been reserved.
+func (c *Checkpoint) NextID() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.NextID
+}
+
+// Save flushes indexer (if it is not nil), and then writes the checkpoint.
+// Only inputs which were done before the flush are recorded as done, while
+// reservations made up to the point of writing are kept, so that any
+// column which might have reached Pilosa is accounted for.
+func (c *Checkpoint) Save(indexer Indexer) error {
+	c.mu.Lock()
+	done := make(map[string]bool, len(c.state.Done))
+	for input := range c.state.Done {
+		done[input] = true
+	}
+	c.mu.Unlock()
+
+	if indexer != nil {
+		if err := indexer.Flush(); err != nil {
+			return errors.Wrap(err, "flushing before checkpoint")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := checkpointState{
+		NextID:   c.state.NextID,
+		Done:     done,
+		Reserved: make(map[string]uint64, len(c.state.Reserved)),
+		Params:   c.state.Params,
+	}
+	for input, first := range c.state.Reserved {
+		if !done[input] {
+			state.Reserved[input] = first
+		}
+	}
+	if c.path != "" {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return errors.Wrap(err, "encoding checkpoint")
+		}
+		tmp := c.path + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return errors.Wrap(err, "writing checkpoint")
+		}
+		if err := os.Rename(tmp, c.path); err != nil {
+			return errors.Wrap(err, "renaming checkpoint")
+		}
+	}
+	// inputs which are saved as done will be skipped, so their reservations
+	// are no longer needed.
+	for input := range done {
+		delete(c.state.Reserved, input)
+	}
+	return nil
+}
+
+// SaveEvery calls Save every interval until the returned function is called.
+// Errors are logged, and the next Save tries again. An interval of 0 disables
+// periodic saving.
+func (c *Checkpoint) SaveEvery(indexer Indexer, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Save(indexer); err != nil {
+					log.Printf("saving checkpoint %v: %v", c.path, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,132 @@
+package pdk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := tempDirName(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	c, err := NewCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := c.Reserve("a", 10); first != 0 {
+		t.Fatalf("expected first reservation at 0, got %v", first)
+	}
+	if first := c.Reserve("b", 5); first != 10 {
+		t.Fatalf("expected second reservation at 10, got %v", first)
+	}
+	if first := c.Reserve("a", 10); first != 0 {
+		t.Fatalf("expected repeated reservation at 0, got %v", first)
+	}
+	p := c.Start("a", 2)
+	p.Done()
+	if c.Done("a") {
+		t.Fatal("input done before all records")
+	}
+	p.Done()
+	if !c.Done("a") {
+		t.Fatal("input not done after all records")
+	}
+	c.Start("empty", 0)
+	if !c.Done("empty") {
+		t.Fatal("input with no records not done")
+	}
+
+	idx := NewMemIndex(nil)
+	if err := c.Save(idx); err != nil {
+		t.Fatal(err)
+	}
+	// reserved after the save, so lost when resuming
+	c.Reserve("c", 1)
+
+	c, err = NewCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Done("a") || c.Done("b") {
+		t.Fatalf("unexpected done inputs after resume: %v", c.state.Done)
+	}
+	if !reflect.DeepEqual(c.state.Reserved, map[string]uint64{"b": 10}) {
+		t.Fatalf("unexpected reservations after resume: %v", c.state.Reserved)
+	}
+	if first := c.Reserve("b", 5); first != 10 {
+		t.Fatalf("expected resumed reservation at 10, got %v", first)
+	}
+	if first := c.Reserve("c", 1); first != 15 {
+		t.Fatalf("expected new reservation at 15, got %v", first)
+	}
+
+	c, err = NewCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done("a") || c.NextID() != 0 {
+		t.Fatal("expected fresh checkpoint without resume")
+	}
+}
+
+func TestCheckpointRequire(t *testing.T) {
+	dir := tempDirName(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	c, err := NewCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Require("split", "0,100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Require("split", "0,50,100"); err == nil {
+		t.Fatal("expected error resuming with a different split")
+	}
+	if err := c.Require("split", "0,100"); err != nil {
+		t.Fatalf("resuming with the same split: %v", err)
+	}
+	if err := c.Require("other", "x"); err != nil {
+		t.Fatalf("new setting: %v", err)
+	}
+
+	// a fresh import may use any settings
+	c, err = NewCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Require("split", "0,50,100"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInputProgressAdd(t *testing.T) {
+	c, err := NewCheckpoint("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one pending record for reading the input, and two for a chunk
+	p := c.Start("url", 1)
+	p.Add(2)
+	p.Done()
+	p.Done()
+	if c.Done("url") {
+		t.Fatal("input done while being read")
+	}
+	p.Done()
+	if !c.Done("url") {
+		t.Fatal("input not done after reading and all records")
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/pilosa/pdk/usecase/jsonlines"
+	"github.com/spf13/cobra"
+)
+
+var JSONMain *jsonlines.Main
+
+func NewJSONCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command {
+	JSONMain = jsonlines.NewMain()
+	var fields []string
+	jsonCommand := &cobra.Command{
+		Use:   "json [flags] [file...]",
+		Short: "json - import newline delimited JSON",
+		Long: `Import files of newline delimited JSON objects (or standard input), one
+column per object. Each --field maps the values at a path in the objects to
+rows of a frame, e.g. --field user.name=name --field items.sku=sku
+--field age=age:int. String values are given row IDs by a translator kept in
+--mapping-dir; int values are used as row IDs.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			JSONMain.Files = args
+			JSONMain.Fields = JSONMain.Fields[:0]
+			for _, s := range fields {
+				f, err := jsonlines.ParseField(s)
+				if err != nil {
+					return err
+				}
+				JSONMain.Fields = append(JSONMain.Fields, f)
+			}
+			start := time.Now()
+			err := JSONMain.Run()
+			if err != nil {
+				return err
+			}
+			log.Println("Done: ", time.Since(start))
+			return nil
+		},
+	}
+	flags := jsonCommand.Flags()
+	flags.StringSliceVarP(&fields, "field", "f", nil, "Path in each object and the frame to set its values in, as path=frame or path=frame:int. May be repeated.")
+	flags.StringSliceVarP(&JSONMain.Hosts, "pilosa-hosts", "p", []string{"localhost:10101"}, "Pilosa cluster.")
+	flags.StringVarP(&JSONMain.Index, "index", "i", JSONMain.Index, "Pilosa index to write to.")
+	flags.StringVarP(&JSONMain.MappingDir, "mapping-dir", "", JSONMain.MappingDir, "Directory in which to keep the row IDs of string values.")
+	flags.StringVarP(&JSONMain.ProxyBind, "proxy", "", JSONMain.ProxyBind, "Address to serve the translating proxy on after importing with the go-pilosa, ctl or roaring backend. Empty to exit instead. The file and memory backends don't start it.")
+	flags.IntVarP(&JSONMain.BufferSize, "buffer-size", "b", 1000000, "Size of buffer for importers - heavily affects memory usage")
+	flags.StringVarP(&JSONMain.OutputDir, "output-dir", "o", "", "Write pilosa import files to this directory instead of importing into Pilosa.")
+	flags.StringVarP(&JSONMain.Backend, "backend", "", "", "Import backend: go-pilosa, ctl, roaring, file or memory. Defaults to file if --output-dir is set, and go-pilosa otherwise.")
+	flags.StringVarP(&JSONMain.SpoolDir, "spool-dir", "", "pdk-spool", "Directory in which to keep batches that Pilosa fails to import after retrying, until they can be replayed. Empty to drop them.")
+	flags.IntVarP(&JSONMain.MaxBuffered, "max-buffered", "", 0, "Maximum number of bits per frame to hold while grouping them by slice. Defaults to --buffer-size.")
+	addThrottleFlags(flags, &JSONMain.Throttle)
+	addProxyCacheFlags(flags, &JSONMain.ProxyCache)
+
+	return jsonCommand
+}
+
+func init() {
+	subcommandFns["json"] = NewJSONCommand
+}
@@ -45,6 +45,11 @@ func NewNetCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command {
 	flags.StringVarP(&Net.Filter, "filter", "b", "", "BPF style filter for packet capture - i.e. 'dst port 80' would capture only traffic headed for port 80")
 	flags.StringVarP(&Net.Index, "index", "", "net", "Pilosa index to write to")
 	flags.StringVarP(&Net.BindAddr, "bind-addr", "a", "localhost:10102", "Address which mapping proxy will bind to")
+	flags.StringVarP(&Net.OutputDir, "output-dir", "o", "", "Write pilosa import files to this directory instead of importing into Pilosa.")
+	flags.StringVarP(&Net.Backend, "backend", "", "", "Import backend: go-pilosa, ctl, roaring, file or memory. Defaults to file if --output-dir is set, and go-pilosa otherwise.")
+	flags.StringVarP(&Net.SpoolDir, "spool-dir", "", "pdk-spool", "Directory in which to keep batches that Pilosa fails to import after retrying, until they can be replayed. Empty to drop them.")
+	addThrottleFlags(flags, &Net.Throttle)
+	addProxyCacheFlags(flags, &Net.ProxyCache)
 
 	return netCommand
 }
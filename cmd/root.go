@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
+	"github.com/pilosa/pdk"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -53,6 +55,22 @@ Build Time: ` + BuildTime + "\n",
 	return rc
 }
 
+// addThrottleFlags adds flags which limit how fast a subcommand imports into
+// Pilosa. Like all flags, they can also be set from the environment or the
+// config file.
+func addThrottleFlags(flags *pflag.FlagSet, conf *pdk.ThrottleConfig) {
+	flags.Float64VarP(&conf.BitsPerSecond, "max-bits-per-sec", "", 0, "Maximum number of bits and values to import per second. 0 for no limit.")
+	flags.Float64VarP(&conf.RequestsPerSecond, "max-requests-per-sec", "", 0, "Maximum number of import requests to send per second. 0 for no limit.")
+	flags.DurationVarP(&conf.TargetLatency, "target-latency", "", 0, "Slow down imports while Pilosa takes longer than this to respond to them. 0 to disable.")
+}
+
+// addProxyCacheFlags adds flags which configure the query cache of a
+// subcommand's mapping proxy.
+func addProxyCacheFlags(flags *pflag.FlagSet, conf *pdk.QueryCacheConfig) {
+	flags.IntVarP(&conf.MaxBytes, "proxy-cache-size", "", 0, "Maximum size in bytes of the query responses cached by the mapping proxy. 0 disables the cache.")
+	flags.DurationVarP(&conf.TTL, "proxy-cache-ttl", "", 10*time.Second, "How long the mapping proxy serves a cached query response. 0 for no limit.")
+}
+
 // setAllConfig takes a FlagSet to be the definition of all configuration
 // options, as well as their defaults. It then reads from the command line, the
 // environment, and a config file (if specified), and applies the configuration
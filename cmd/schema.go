@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pilosa/pdk"
+	"github.com/spf13/cobra"
+)
+
+// SchemaOptions holds the flags of the schema command.
+type SchemaOptions struct {
+	File  string
+	Hosts []string
+}
+
+var SchemaOpts = &SchemaOptions{}
+
+func NewSchemaCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command {
+	schemaCommand := &cobra.Command{
+		Use:   "schema",
+		Short: "schema - compare or apply a schema file to Pilosa",
+		Long: `Reads an index and its frames from a JSON schema file, and compares it
+against a running Pilosa (diff) or creates whatever is missing (apply).`,
+	}
+	diffCommand := &cobra.Command{
+		Use:   "diff",
+		Short: "show what is missing from Pilosa",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, client, err := SchemaOpts.setup()
+			if err != nil {
+				return err
+			}
+			diff, err := schema.Diff(client)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(stdout, diff)
+			return nil
+		},
+	}
+	applyCommand := &cobra.Command{
+		Use:   "apply",
+		Short: "create whatever is missing from Pilosa",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, client, err := SchemaOpts.setup()
+			if err != nil {
+				return err
+			}
+			diff, err := schema.Apply(client)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(stdout, diff)
+			return nil
+		},
+	}
+	flags := schemaCommand.PersistentFlags()
+	flags.StringVarP(&SchemaOpts.File, "file", "f", "schema.json", "JSON file describing the index and frames.")
+	flags.StringSliceVarP(&SchemaOpts.Hosts, "pilosa-hosts", "p", []string{"localhost:10101"}, "Pilosa cluster.")
+	schemaCommand.AddCommand(diffCommand, applyCommand)
+	return schemaCommand
+}
+
+func (o *SchemaOptions) setup() (pdk.Schema, *pcli.Client, error) {
+	schema, err := pdk.LoadSchema(o.File)
+	if err != nil {
+		return schema, nil, err
+	}
+	client, err := pcli.NewClientFromAddresses(o.Hosts, &pcli.ClientOptions{})
+	if err != nil {
+		return schema, nil, fmt.Errorf("creating pilosa cluster client: %v", err)
+	}
+	return schema, client, nil
+}
+
+func init() {
+	subcommandFns["schema"] = NewSchemaCommand
+}
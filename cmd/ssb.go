@@ -36,9 +36,23 @@ func NewSSBCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command {
 	}
 	flags := ssbCommand.Flags()
 	flags.StringVarP(&SSBMain.Dir, "data-dir", "d", "ssb1", "Directory containing ssb data files.")
+	flags.StringVarP(&SSBMain.StarSchema, "star-schema", "", SSBMain.StarSchema, "File declaring how lineorder.tbl is joined with the dimension tables in --data-dir.")
 	flags.StringSliceVarP(&SSBMain.Hosts, "pilosa-hosts", "p", []string{"localhost:10101"}, "Pilosa cluster.")
+	flags.IntVarP(&SSBMain.ReadConcurrency, "read-concurrency", "", 1, "Number of goroutines reading lineorder.tbl, each from its own part of the file.")
 	flags.IntVarP(&SSBMain.MapConcurrency, "map-concurrency", "m", 1, "Number of goroutines mapping parsed records.")
 	flags.IntVarP(&SSBMain.RecordBuf, "record-buffer", "r", 1000000, "Channel buffer size for parsed records.")
+	flags.StringVarP(&SSBMain.MetricsAddr, "metrics-addr", "", "localhost:6060", "Address to serve import metrics on.")
+	flags.StringVarP(&SSBMain.OutputDir, "output-dir", "o", "", "Write pilosa import files to this directory instead of importing into Pilosa.")
+	flags.StringVarP(&SSBMain.Backend, "backend", "", "", "Import backend: go-pilosa, ctl, roaring, file or memory. Defaults to file if --output-dir is set, and go-pilosa otherwise.")
+	flags.StringVarP(&SSBMain.SpoolDir, "spool-dir", "", "pdk-spool", "Directory in which to keep batches that Pilosa fails to import after retrying, until they can be replayed. Empty to drop them.")
+	flags.StringVarP(&SSBMain.MutexDir, "mutex-dir", "", "", "Directory in which to keep the rows set in the mutex frames of --key-columns, so that later imports clear them. Empty to keep them in memory for this import only.")
+	addThrottleFlags(flags, &SSBMain.Throttle)
+	addProxyCacheFlags(flags, &SSBMain.ProxyCache)
+	flags.IntVarP(&SSBMain.MaxBuffered, "max-buffered", "", 0, "Maximum number of bits or values per frame and field to hold while grouping them by slice. Defaults to the import batch size.")
+	flags.StringVarP(&SSBMain.Checkpoint, "checkpoint", "", "ssb-checkpoint.json", "File in which to save import progress. Empty to disable checkpoints.")
+	flags.DurationVarP(&SSBMain.CheckpointInterval, "checkpoint-interval", "", time.Minute, "How often to flush imports and save a checkpoint.")
+	flags.BoolVarP(&SSBMain.Resume, "resume", "", false, "Continue from the checkpoint of an interrupted import, skipping chunks of lineorder.tbl which were completed.")
+	flags.BoolVarP(&SSBMain.KeyColumns, "key-columns", "", false, "Derive column IDs from lo_orderkey and lo_linenumber, so that re-importing rows updates them instead of adding new columns.")
 
 	return ssbCommand
 }
@@ -31,9 +31,19 @@ func NewTaxiCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command {
 	flags.IntVarP(&TaxiMain.Concurrency, "concurrency", "c", 8, "Number of goroutines fetching and parsing")
 	flags.IntVarP(&TaxiMain.FetchConcurrency, "fetch-concurrency", "e", 8, "Number of goroutines fetching and parsing")
 	flags.IntVarP(&TaxiMain.BufferSize, "buffer-size", "b", 1000000, "Size of buffer for importers - heavily affects memory usage")
+	flags.IntVarP(&TaxiMain.MaxBuffered, "max-buffered", "", 0, "Maximum number of bits per frame to hold while grouping them by slice. Defaults to --buffer-size.")
 	flags.StringVarP(&TaxiMain.PilosaHost, "pilosa", "p", "localhost:10101", "Pilosa host")
 	flags.StringVarP(&TaxiMain.Index, "index", "i", "taxi", "Pilosa db to write to")
+	flags.StringVarP(&TaxiMain.MetricsAddr, "metrics-addr", "", "localhost:6060", "Address to serve import metrics on.")
 	flags.StringVarP(&TaxiMain.URLFile, "url-file", "f", "usecase/taxi/urls-short.txt", "File to get raw data urls from. Urls may be http or local files.")
+	flags.StringVarP(&TaxiMain.OutputDir, "output-dir", "o", "", "Write pilosa import files to this directory instead of importing into Pilosa.")
+	flags.StringVarP(&TaxiMain.Backend, "backend", "", "", "Import backend: go-pilosa, ctl, roaring, file or memory. Defaults to file if --output-dir is set, and go-pilosa otherwise.")
+	flags.StringVarP(&TaxiMain.SpoolDir, "spool-dir", "", "pdk-spool", "Directory in which to keep batches that Pilosa fails to import after retrying, until they can be replayed. Empty to drop them.")
+	flags.StringVarP(&TaxiMain.MutexDir, "mutex-dir", "", "", "Directory in which to keep the rows set in the mutex frames, so that later imports clear them. Empty to keep them in memory for this import only.")
+	addThrottleFlags(flags, &TaxiMain.Throttle)
+	flags.StringVarP(&TaxiMain.Checkpoint, "checkpoint", "", "taxi-checkpoint.json", "File in which to save import progress. Empty to disable checkpoints.")
+	flags.DurationVarP(&TaxiMain.CheckpointInterval, "checkpoint-interval", "", time.Minute, "How often to flush imports and save a checkpoint.")
+	flags.BoolVarP(&TaxiMain.Resume, "resume", "", false, "Continue from the checkpoint of an interrupted import, skipping urls which were completed.")
 
 	return taxiCommand
 }
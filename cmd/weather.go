@@ -33,6 +33,10 @@ func NewWeatherCommand(stdin io.Reader, stdout, stderr io.Writer) *cobra.Command
 	flags.StringVarP(&WeatherMain.PilosaHost, "pilosa", "p", "localhost:10101", "Pilosa host")
 	flags.StringVarP(&WeatherMain.Index, "index", "i", "taxi", "Pilosa db to write to")
 	flags.StringVarP(&WeatherMain.WeatherCache.URLFile, "url-file", "f", "usecase/weather/urls.txt", "File to get raw data urls from. Urls may be http or local files.")
+	flags.StringVarP(&WeatherMain.OutputDir, "output-dir", "o", "", "Write pilosa import files to this directory instead of importing into Pilosa. Taxi data is still read from Pilosa.")
+	flags.StringVarP(&WeatherMain.Backend, "backend", "", "", "Import backend: go-pilosa, ctl, roaring, file or memory. Defaults to file if --output-dir is set, and go-pilosa otherwise.")
+	flags.StringVarP(&WeatherMain.SpoolDir, "spool-dir", "", "pdk-spool", "Directory in which to keep batches that Pilosa fails to import after retrying, until they can be replayed. Empty to drop them.")
+	addThrottleFlags(flags, &WeatherMain.Throttle)
 
 	return weatherCommand
 }
@@ -0,0 +1,101 @@
+package pdk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ColumnKey derives a stable column ID for a record from one or more of its
+// fields (e.g. an order number and line number), instead of numbering records
+// with a Nexter in the order they happen to be mapped. The key is translated
+// to an ID with Translator, so a record which is imported again gets the same
+// column, which makes imports idempotent and lets a record be updated by
+// importing it again.
+type ColumnKey struct {
+	// Frame is the name under which keys are stored in Translator. It does
+	// not need to be a Pilosa frame.
+	Frame      string
+	Fields     []int
+	Translator Translator
+}
+
+// Key returns the key of a record. A single field is used as is, and a
+// composite key is encoded as a CSV line so that it can be split again.
+func (ck ColumnKey) Key(fields []string) (string, error) {
+	if len(ck.Fields) == 0 {
+		return "", errors.New("column key has no fields")
+	}
+	vals := make([]string, len(ck.Fields))
+	for i, fieldnum := range ck.Fields {
+		if fieldnum >= len(fields) {
+			return "", fmt.Errorf("key field index: %v out of range for: %v", fieldnum, fields)
+		}
+		if fields[fieldnum] == "" {
+			return "", fmt.Errorf("key field %v is empty", fieldnum)
+		}
+		vals[i] = fields[fieldnum]
+	}
+	if len(vals) == 1 {
+		return vals[0], nil
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(vals); err != nil {
+		return "", errors.Wrap(err, "encoding key")
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// ColumnID returns the column ID for the key of a record, allocating a new one
+// if the key hasn't been seen before.
+func (ck ColumnKey) ColumnID(fields []string) (uint64, error) {
+	key, err := ck.Key(fields)
+	if err != nil {
+		return 0, err
+	}
+	id, err := ck.Translator.GetID(ck.Frame, []byte(key))
+	if err != nil {
+		return 0, errors.Wrapf(err, "translating key '%v'", key)
+	}
+	return id, nil
+}
+
+// KeyFields returns the key fields of the record stored in column id.
+func (ck ColumnKey) KeyFields(id uint64) ([]string, error) {
+	fields, ok, err := ck.LookupKeyFields(id)
+	if err == nil && !ok {
+		err = errors.Errorf("no key for column %v", id)
+	}
+	return fields, err
+}
+
+// LookupKeyFields is KeyFields, but ok is false rather than an error if column
+// id has no key.
+func (ck ColumnKey) LookupKeyFields(id uint64) (fields []string, ok bool, err error) {
+	val, ok, err := lookupValue(ck.Translator, ck.Frame, id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var key string
+	switch val := val.(type) {
+	case []byte:
+		key = string(val)
+	case string:
+		key = val
+	default:
+		return nil, false, errors.Errorf("key of column %v is a %T, not a string", id, val)
+	}
+	if len(ck.Fields) == 1 {
+		return []string{key}, true, nil
+	}
+	fields, err = csv.NewReader(strings.NewReader(key)).Read()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "decoding key '%v'", key)
+	}
+	return fields, true, nil
+}
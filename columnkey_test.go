@@ -0,0 +1,57 @@
+package pdk
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestColumnKey(t *testing.T) {
+	dir := tempDirName(t)
+	defer os.RemoveAll(dir)
+	lt, err := NewLevelTranslator(dir, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lt.Close()
+	ck := ColumnKey{Frame: "orders", Fields: []int{2, 0}, Translator: lt}
+
+	rec1 := []string{"1", "x", "order,\"one\""}
+	rec2 := []string{"2", "x", "order,\"one\""}
+	id1, err := ck.ColumnID(rec1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := ck.ColumnID(rec2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id2 {
+		t.Fatalf("different keys got the same column %v", id1)
+	}
+	again, err := ck.ColumnID([]string{"1", "y", "order,\"one\""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id1 {
+		t.Fatalf("same key got column %v, then %v", id1, again)
+	}
+	fields, err := ck.KeyFields(id1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, []string{"order,\"one\"", "1"}) {
+		t.Fatalf("unexpected key fields: %q", fields)
+	}
+
+	single := ColumnKey{Frame: "orders", Fields: []int{0}, Translator: lt}
+	if key, err := single.Key([]string{"a,b"}); err != nil || key != "a,b" {
+		t.Fatalf("unexpected single key: %v, %v", key, err)
+	}
+
+	for _, rec := range [][]string{{"1"}, {"", "x", "y"}} {
+		if _, err := ck.ColumnID(rec); err == nil {
+			t.Errorf("expected error for key of %q", rec)
+		}
+	}
+}
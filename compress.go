@@ -0,0 +1,129 @@
+package pdk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// compression identifies the compression format of some data.
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionBzip2
+)
+
+// detectCompression determines the compression format from the first few
+// bytes of the data, falling back to the file extension of name if there
+// aren't enough bytes to tell.
+func detectCompression(head []byte, name string) compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(head, bzip2Magic):
+		return compressionBzip2
+	case len(head) >= len(bzip2Magic):
+		return compressionNone
+	}
+	return compressionFromExt(name)
+}
+
+func compressionFromExt(name string) compression {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return compressionGzip
+	case strings.HasSuffix(name, ".bz2"):
+		return compressionBzip2
+	}
+	return compressionNone
+}
+
+// TrimCompressionExt removes a .gz or .bz2 extension from name.
+func TrimCompressionExt(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".bz2")
+}
+
+// NewDecompressReader wraps r so that gzip or bzip2 compressed data is
+// decompressed transparently, and uncompressed data is passed through. The
+// format is detected from the magic bytes at the start of the data - name
+// (typically a file name or URL) is only consulted if the data is too short
+// to tell. Detection happens on the first Read, so any error is returned from
+// there. Close closes r if it is an io.Closer.
+func NewDecompressReader(r io.Reader, name string) io.ReadCloser {
+	return &decompressReader{
+		src:  r,
+		name: name,
+	}
+}
+
+type decompressReader struct {
+	src  io.Reader
+	name string
+	r    io.Reader
+	err  error
+}
+
+func (d *decompressReader) Read(p []byte) (n int, err error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.detect()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decompressReader) detect() (io.Reader, error) {
+	br := bufio.NewReader(d.src)
+	head, err := br.Peek(len(bzip2Magic))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "reading %v", d.name)
+	}
+	switch detectCompression(head, d.name) {
+	case compressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening gzip stream %v", d.name)
+		}
+		return gr, nil
+	case compressionBzip2:
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+func (d *decompressReader) Close() error {
+	if gr, ok := d.r.(*gzip.Reader); ok {
+		if err := gr.Close(); err != nil {
+			return errors.Wrapf(err, "closing gzip stream %v", d.name)
+		}
+	}
+	if c, ok := d.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// IsCompressed reports whether f holds gzip or bzip2 compressed data. It does
+// not change the file's offset.
+func IsCompressed(f *os.File) (bool, error) {
+	head := make([]byte, len(bzip2Magic))
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return false, errors.Wrap(err, "reading file header")
+	}
+	return detectCompression(head[:n], f.Name()) != compressionNone, nil
+}
@@ -0,0 +1,75 @@
+package pdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	_, err := gw.Write([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressReader(t *testing.T) {
+	// bzip2 of "hello\n"
+	bz := []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xc1, 0xc0, 0x80, 0xe2, 0x00, 0x00, 0x01, 0x41, 0x00, 0x00, 0x10, 0x02, 0x44, 0xa0, 0x00, 0x30, 0xcd, 0x00, 0xc3, 0x46, 0x29, 0x97, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0xc1, 0xc0, 0x80, 0xe2}
+	tests := []struct {
+		name string
+		data []byte
+		exp  string
+		err  bool
+	}{
+		{name: "plain.csv", data: []byte("hello\n"), exp: "hello\n"},
+		{name: "plain.csv", data: []byte{}, exp: ""},
+		{name: "x.csv.gz", data: gzipBytes(t, "hello\n"), exp: "hello\n"},
+		{name: "no-extension", data: gzipBytes(t, "hello\n"), exp: "hello\n"},
+		{name: "x.csv.bz2", data: bz, exp: "hello\n"},
+		{name: "x.gz", data: []byte{0x1f}, err: true},
+	}
+	for i, test := range tests {
+		actual, err := ioutil.ReadAll(NewDecompressReader(bytes.NewReader(test.data), test.name))
+		if test.err {
+			if err == nil {
+				t.Fatalf("test %d: expected error, got '%s'", i, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if string(actual) != test.exp {
+			t.Fatalf("test %d: expected '%s', got '%s'", i, test.exp, actual)
+		}
+	}
+}
+
+func TestSplitFileLinesCompressed(t *testing.T) {
+	f := mustWriteAndOpenFile(t, gzipBytes(t, "aaaa\nbbbb\ncccc\ndd\n"))
+	frags, err := SplitFileLines(f, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, frag := range frags {
+		bytes, err := ioutil.ReadAll(frag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, string(bytes))
+	}
+	if !reflect.DeepEqual(actual, []string{"aaaa\nbbbb\ncccc\ndd\n"}) {
+		t.Fatalf("unexpected fragments: %#v", actual)
+	}
+}
@@ -0,0 +1,265 @@
+package pdk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Position identifies a line within a named input, and formats as file:line.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// SourceError describes a malformed record in an input source. Sources return
+// a SourceError for lines which can be skipped - reading may continue
+// afterwards.
+type SourceError struct {
+	Pos Position
+	Err error
+}
+
+func (e *SourceError) Error() string {
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+// CSVVariant describes one version of a CSV schema. A variant is selected for
+// a file if every one of its Required column names is present in the file's
+// header.
+type CSVVariant struct {
+	Name     string
+	Required []string
+}
+
+// CSVHeader maps the column names from the first line of a CSV file to their
+// positions.
+type CSVHeader struct {
+	Variant string
+	Names   []string
+
+	index map[string]int
+}
+
+func newCSVHeader(names []string) *CSVHeader {
+	h := &CSVHeader{
+		Names: make([]string, len(names)),
+		index: make(map[string]int, len(names)),
+	}
+	for i, name := range names {
+		name = normalizeCSVName(name)
+		h.Names[i] = name
+		if _, ok := h.index[name]; !ok {
+			h.index[name] = i
+		}
+	}
+	return h
+}
+
+// normalizeCSVName trims surrounding whitespace (and a UTF-8 byte order mark)
+// and lower cases a column name so that headers written by different tools
+// still match.
+func normalizeCSVName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// Index returns the position of the named column.
+func (h *CSVHeader) Index(name string) (int, bool) {
+	i, ok := h.index[normalizeCSVName(name)]
+	return i, ok
+}
+
+// Indexes returns the positions of the named columns, suitable for use as
+// BitMapper.Fields.
+func (h *CSVHeader) Indexes(names ...string) ([]int, error) {
+	idxs := make([]int, len(names))
+	for i, name := range names {
+		idx, ok := h.Index(name)
+		if !ok {
+			return nil, errors.Errorf("column '%v' not found in header %v", name, h.Names)
+		}
+		idxs[i] = idx
+	}
+	return idxs, nil
+}
+
+// Fields returns a map of column name to position.
+func (h *CSVHeader) Fields() map[string]int {
+	fields := make(map[string]int, len(h.index))
+	for name, i := range h.index {
+		fields[name] = i
+	}
+	return fields
+}
+
+func (h *CSVHeader) has(names []string) bool {
+	for _, name := range names {
+		if _, ok := h.Index(name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// CSVRecord is a single record read from a CSVSource.
+type CSVRecord struct {
+	Fields []string
+	Header *CSVHeader
+	Pos    Position
+}
+
+// Get returns the value of the named column, or the empty string if the
+// column doesn't exist.
+func (r *CSVRecord) Get(name string) string {
+	i, ok := r.Header.Index(name)
+	if !ok || i >= len(r.Fields) {
+		return ""
+	}
+	return r.Fields[i]
+}
+
+// CSVSource reads RFC 4180 CSV data - quoted fields may contain commas and
+// newlines. The first line of the input is treated as a header which names
+// the columns and selects the schema variant.
+//
+// The position of a record, or of a malformed line, is the line on which it
+// starts, counting blank lines and the newlines in quoted fields.
+type CSVSource struct {
+	name     string
+	variants []CSVVariant
+	r        *csv.Reader
+	lines    *lineReader
+	header   *CSVHeader
+	ragged   bool
+}
+
+// NewCSVSource creates a CSVSource reading from r, which may be gzip or bzip2
+// compressed. name is used to report positions, and is typically the file
+// name or URL. If variants are given, the header must match one of them.
+func NewCSVSource(r io.Reader, name string, variants ...CSVVariant) *CSVSource {
+	lines := &lineReader{r: bufio.NewReader(NewDecompressReader(r, name))}
+	return &CSVSource{
+		name:     name,
+		variants: variants,
+		r:        csv.NewReader(lines),
+		lines:    lines,
+	}
+}
+
+// lineReader numbers the lines passing through it. It returns at most one
+// line from each Read, so that a csv.Reader reading from it, which buffers its
+// input, never holds more than the rest of the line it is parsing, and the
+// lines counted are the ones it has consumed.
+type lineReader struct {
+	r       *bufio.Reader
+	pending []byte
+	// line is the number of lines started, midLine is set while the last
+	// one hasn't ended, and start is the first line since mark which isn't
+	// blank, or 0.
+	line    int
+	midLine bool
+	start   int
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	if len(lr.pending) == 0 {
+		data, err := lr.r.ReadSlice('\n')
+		if len(data) == 0 {
+			return 0, err
+		}
+		if !lr.midLine {
+			lr.line++
+			if lr.start == 0 && string(data) != "\n" && string(data) != "\r\n" {
+				lr.start = lr.line
+			}
+		}
+		lr.midLine = data[len(data)-1] != '\n'
+		lr.pending = data
+	}
+	n := copy(p, lr.pending)
+	lr.pending = lr.pending[n:]
+	return n, nil
+}
+
+// mark starts looking for the first line which isn't blank.
+func (lr *lineReader) mark() {
+	lr.start = 0
+}
+
+// SetComma changes the field delimiter from the default ','.
+func (s *CSVSource) SetComma(c rune) {
+	s.r.Comma = c
+}
+
+// AllowRagged accepts records with more or fewer fields than the header, as
+// some exports pad rows with trailing delimiters. CSVRecord.Get returns "" for
+// fields which are missing. It must be called before the header is read.
+func (s *CSVSource) AllowRagged() {
+	s.ragged = true
+	s.r.FieldsPerRecord = -1
+}
+
+// Header reads and returns the header line if it hasn't been read already.
+func (s *CSVSource) Header() (*CSVHeader, error) {
+	if s.header != nil {
+		return s.header, nil
+	}
+	names, err := s.r.Read()
+	if err == io.EOF {
+		return nil, errors.Errorf("%v: empty input, no header", s.name)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "reading header of %v", s.name)
+	}
+	h := newCSVHeader(names)
+	if len(s.variants) > 0 {
+		for _, v := range s.variants {
+			if h.has(v.Required) {
+				h.Variant = v.Name
+				break
+			}
+		}
+		if h.Variant == "" {
+			return nil, errors.Errorf("%v: header %v doesn't match any known schema variant", s.name, h.Names)
+		}
+	}
+	// require all subsequent records to have the same number of fields as
+	// the header.
+	if !s.ragged {
+		s.r.FieldsPerRecord = len(names)
+	}
+	s.header = h
+	return h, nil
+}
+
+// Record returns the next record. It returns io.EOF when the input is
+// exhausted, and a *SourceError for a malformed line - in which case the
+// caller may log it and call Record again.
+func (s *CSVSource) Record() (*CSVRecord, error) {
+	h, err := s.Header()
+	if err != nil {
+		return nil, err
+	}
+	s.lines.mark()
+	fields, err := s.r.Read()
+	pos := Position{File: s.name, Line: s.lines.start}
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if perr, ok := err.(*csv.ParseError); ok {
+		return nil, &SourceError{Pos: pos, Err: perr.Err}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "reading %v", s.name)
+	}
+	return &CSVRecord{
+		Fields: fields,
+		Header: h,
+		Pos:    pos,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package pdk
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVSource(t *testing.T) {
+	data := `Vendor_ID,pickup_datetime,notes
+1,2013-08-01 08:14:37,"one, two"
+2,2013-08-01 09:13:00,"multi
+line"
+3,2013-08-01 10:00:00
+4,2013-08-01 11:00:00,plain
+`
+	src := NewCSVSource(strings.NewReader(data), "trips.csv")
+
+	rec, err := src.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Fields, []string{"1", "2013-08-01 08:14:37", "one, two"}) {
+		t.Fatalf("unexpected fields: %#v", rec.Fields)
+	}
+	if rec.Get("vendor_id") != "1" {
+		t.Fatalf("unexpected vendor_id: %v", rec.Get("vendor_id"))
+	}
+
+	rec, err = src.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Get("notes") != "multi\nline" || rec.Pos.Line != 3 {
+		t.Fatalf("unexpected record: %#v at %v", rec.Fields, rec.Pos)
+	}
+
+	_, err = src.Record()
+	serr, ok := err.(*SourceError)
+	if !ok {
+		t.Fatalf("expected *SourceError for short line, got %v", err)
+	}
+	if serr.Pos.String() != "trips.csv:5" {
+		t.Fatalf("unexpected position for short line: %v", serr.Pos)
+	}
+
+	rec, err = src.Record()
+	if err != nil {
+		t.Fatalf("reading after malformed line: %v", err)
+	}
+	if rec.Get("notes") != "plain" || rec.Pos.Line != 6 {
+		t.Fatalf("unexpected record: %#v at %v", rec.Fields, rec.Pos)
+	}
+
+	_, err = src.Record()
+	if err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestCSVSourceVariants(t *testing.T) {
+	variants := []CSVVariant{
+		{Name: "green", Required: []string{"lpep_pickup_datetime", "trip_type"}},
+		{Name: "yellow", Required: []string{"tpep_pickup_datetime"}},
+	}
+	tests := []struct {
+		data    string
+		variant string
+		fields  []string
+		idxs    []int
+		err     bool
+	}{
+		{
+			data:    "VendorID,lpep_pickup_datetime,trip_type\n",
+			variant: "green",
+			fields:  []string{"trip_type", "vendorid"},
+			idxs:    []int{2, 0},
+		},
+		{
+			data:    " VendorID , tpep_pickup_datetime\n",
+			variant: "yellow",
+			fields:  []string{"tpep_pickup_datetime"},
+			idxs:    []int{1},
+		},
+		{
+			data: "VendorID,lpep_pickup_datetime\n",
+			err:  true,
+		},
+	}
+
+	for i, test := range tests {
+		src := NewCSVSource(strings.NewReader(test.data), "test", variants...)
+		h, err := src.Header()
+		if test.err {
+			if err == nil {
+				t.Fatalf("test %d: expected error, but got variant %v", i, h.Variant)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if h.Variant != test.variant {
+			t.Fatalf("test %d: expected variant %v, got %v", i, test.variant, h.Variant)
+		}
+		idxs, err := h.Indexes(test.fields...)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(idxs, test.idxs) {
+			t.Fatalf("test %d: expected indexes %v, got %v", i, test.idxs, idxs)
+		}
+	}
+}
+
+func TestCSVSourceRagged(t *testing.T) {
+	data := "a,b,c\n1,2,3,,\n\"x\ny\",5\n"
+	src := NewCSVSource(strings.NewReader(data), "ragged.csv")
+	src.AllowRagged()
+	tests := []struct {
+		fields []string
+		line   int
+	}{
+		{[]string{"1", "2", "3", "", ""}, 2},
+		{[]string{"x\ny", "5"}, 3},
+	}
+	for i, test := range tests {
+		rec, err := src.Record()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rec.Fields, test.fields) || rec.Pos.Line != test.line {
+			t.Errorf("record %d: expected %q at line %d, got %q at %v", i, test.fields, test.line, rec.Fields, rec.Pos)
+		}
+	}
+	if rec, err := src.Record(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v, %v", rec, err)
+	}
+}
+
+func TestCSVSourceLines(t *testing.T) {
+	data := "a,b\n\n1,2\n\"x\n\ny\",3\n\r\n\n4\n5,\"6\n7\"\n" + strings.Repeat("z", 5000) + ",8\n"
+	src := NewCSVSource(strings.NewReader(data), "lines.csv")
+	tests := []struct {
+		line int
+		err  bool
+	}{
+		{3, false},
+		{4, false}, // three lines, one of them blank in the quoted field
+		{9, true},  // after two blank lines
+		{10, false},
+		{12, false}, // longer than the reader's buffer
+	}
+	for i, test := range tests {
+		rec, err := src.Record()
+		var pos Position
+		if serr, ok := err.(*SourceError); ok && test.err {
+			pos = serr.Pos
+		} else if err != nil || test.err {
+			t.Fatalf("record %d: unexpected error %v", i, err)
+		} else {
+			pos = rec.Pos
+		}
+		if pos.Line != test.line {
+			t.Errorf("record %d: expected line %d, got %v", i, test.line, pos)
+		}
+	}
+	if rec, err := src.Record(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v, %v", rec, err)
+	}
+}
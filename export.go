@@ -0,0 +1,495 @@
+package pdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pilosa/pilosa"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestFile is the name of the manifest written by a FileIndex.
+	ManifestFile = "manifest.json"
+
+	// DefaultMaxExportFileSize is the size at which use cases rotate the
+	// files written by a FileIndex.
+	DefaultMaxExportFileSize int64 = 512 * 1024 * 1024
+)
+
+// FileIndex is an Indexer which writes files suitable for `pilosa import`
+// instead of sending data to Pilosa, so that data can be produced on one
+// machine and loaded elsewhere.
+//
+// Bits for each frame are written to "<frame>/bits-NNNN.csv" as "row,col" or
+// "row,col,timestamp" lines, and values for each BSI field to
+// "<frame>/field-<field>-NNNN.csv" as "col,value" lines. Attributes can't be
+// imported, so they are written as SetRowAttrs queries to
+// "<frame>/attrs-NNNN.pql" and SetColumnAttrs queries to
+// "column-attrs-NNNN.pql", one per line, to be sent to the index's query
+// endpoint. Clearing bits can't be imported either, so once a bit has been
+// cleared in a frame, and for every bit in a mutex frame, bits are written in
+// order as SetBit and ClearBit queries to "<frame>/updates-NNNN.pql" instead
+// of to the CSV files. A file is closed and
+// the next one started before it would exceed the maximum size. A manifest
+// describing the index, its frames, and every file written is kept in
+// manifest.json, and is rewritten on each Flush and on Close. OpenFileIndex
+// continues from an existing manifest.
+type FileIndex struct {
+	dir         string
+	maxFileSize int64
+
+	mu       sync.Mutex
+	manifest ExportManifest
+	current  map[exportKey]*exportFile
+	closed   bool
+	buf      []byte
+	mutex    *mutexTracker
+	updates  map[string]bool // frames whose bits are written as queries
+}
+
+// ExportManifest describes the contents of a FileIndex's output directory.
+type ExportManifest struct {
+	Index  string       `json:"index"`
+	Frames []FrameSpec  `json:"frames"`
+	Files  []ExportFile `json:"files"`
+}
+
+// ExportFile describes a single file written by a FileIndex. Path is relative
+// to the output directory. Field is only set for value files, and Frame is
+// empty for column attribute files. Count is the number of lines.
+type ExportFile struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	Frame string `json:"frame,omitempty"`
+	Field string `json:"field,omitempty"`
+	Count uint64 `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Kinds of ExportFile.
+const (
+	ExportBits        = "bits"
+	ExportValues      = "values"
+	ExportRowAttrs    = "row-attrs"
+	ExportColumnAttrs = "column-attrs"
+	ExportUpdates     = "updates"
+)
+
+// exportKey identifies a sequence of rotated files.
+type exportKey struct {
+	kind, frame, field string
+}
+
+func (k exportKey) filename(seq int) string {
+	switch k.kind {
+	case ExportValues:
+		return filepath.Join(k.frame, fmt.Sprintf("field-%s-%04d.csv", k.field, seq))
+	case ExportRowAttrs:
+		return filepath.Join(k.frame, fmt.Sprintf("attrs-%04d.pql", seq))
+	case ExportColumnAttrs:
+		return fmt.Sprintf("column-attrs-%04d.pql", seq)
+	case ExportUpdates:
+		return filepath.Join(k.frame, fmt.Sprintf("updates-%04d.pql", seq))
+	}
+	return filepath.Join(k.frame, fmt.Sprintf("bits-%04d.csv", seq))
+}
+
+type exportFile struct {
+	idx int // position in manifest.Files
+	f   *os.File
+	w   *bufio.Writer
+}
+
+// NewFileIndex creates a FileIndex which writes to dir, creating it if
+// necessary. If maxFileSize is zero or negative, files are never rotated.
+func NewFileIndex(dir, index string, frames []FrameSpec, maxFileSize int64) (*FileIndex, error) {
+	return newFileIndex(dir, ExportManifest{Index: index, Frames: frames, Files: make([]ExportFile, 0)}, maxFileSize)
+}
+
+// OpenFileIndex is NewFileIndex for resuming an import into dir. If dir
+// already has a manifest, its files are kept and new files continue their
+// numbering. Frames in the manifest which aren't in frames stay in it.
+func OpenFileIndex(dir, index string, frames []FrameSpec, maxFileSize int64) (*FileIndex, error) {
+	manifest := ExportManifest{Index: index, Frames: frames, Files: make([]ExportFile, 0)}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return newFileIndex(dir, manifest, maxFileSize)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading manifest")
+	}
+	var prev ExportManifest
+	if err := json.Unmarshal(bs, &prev); err != nil {
+		return nil, errors.Wrap(err, "decoding manifest")
+	}
+	if prev.Index != index {
+		return nil, errors.Errorf("%v was written for index %v, not %v", dir, prev.Index, index)
+	}
+	manifest.Frames = append([]FrameSpec(nil), frames...)
+	for _, spec := range prev.Frames {
+		if _, ok := manifest.frameSpec(spec.Name); !ok {
+			manifest.Frames = append(manifest.Frames, spec)
+		}
+	}
+	manifest.Files = append(manifest.Files, prev.Files...)
+	return newFileIndex(dir, manifest, maxFileSize)
+}
+
+func newFileIndex(dir string, manifest ExportManifest, maxFileSize int64) (*FileIndex, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating output directory")
+	}
+	for _, frame := range manifest.Frames {
+		err := os.MkdirAll(filepath.Join(dir, frame.Name), 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating directory for frame %v", frame.Name)
+		}
+	}
+	fi := &FileIndex{
+		dir:         dir,
+		maxFileSize: maxFileSize,
+		manifest:    manifest,
+		current:     make(map[exportKey]*exportFile),
+		mutex:       newMutexTracker(manifest.Frames),
+		updates:     make(map[string]bool),
+	}
+	for _, frame := range manifest.Frames {
+		if frame.Mutex {
+			fi.updates[frame.Name] = true
+		}
+	}
+	// frames which were written as queries before stay that way, so that
+	// their bits stay in order with the earlier queries
+	for _, info := range manifest.Files {
+		if info.Kind == ExportUpdates {
+			fi.updates[info.Frame] = true
+		}
+	}
+	err = fi.writeManifest()
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (fi *FileIndex) AddBit(frame string, col uint64, row uint64) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if _, ok := fi.frameSpec(frame); !ok {
+		return errors.Errorf("unknown frame in AddBit: %v", frame)
+	}
+	if fi.updates[frame] {
+		return fi.writeSetBit(frame, col, row, nil)
+	}
+	fi.buf = strconv.AppendUint(fi.buf[:0], row, 10)
+	fi.buf = append(fi.buf, ',')
+	fi.buf = strconv.AppendUint(fi.buf, col, 10)
+	fi.buf = append(fi.buf, '\n')
+	return fi.write(exportKey{kind: ExportBits, frame: frame})
+}
+
+func (fi *FileIndex) AddBitTimestamp(frame string, col uint64, row uint64, ts time.Time) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if _, ok := fi.frameSpec(frame); !ok {
+		return errors.Errorf("unknown frame in AddBitTimestamp: %v", frame)
+	}
+	if fi.updates[frame] {
+		return fi.writeSetBit(frame, col, row, &ts)
+	}
+	fi.buf = strconv.AppendUint(fi.buf[:0], row, 10)
+	fi.buf = append(fi.buf, ',')
+	fi.buf = strconv.AppendUint(fi.buf, col, 10)
+	fi.buf = append(fi.buf, ',')
+	fi.buf = ts.UTC().AppendFormat(fi.buf, pilosa.TimeFormat)
+	fi.buf = append(fi.buf, '\n')
+	return fi.write(exportKey{kind: ExportBits, frame: frame})
+}
+
+// ClearBit writes a ClearBit query for the bit, and switches the frame to
+// writing its bits as queries so that they stay in order with it.
+func (fi *FileIndex) ClearBit(frame string, col uint64, row uint64) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if _, ok := fi.frameSpec(frame); !ok {
+		return errors.Errorf("unknown frame in ClearBit: %v", frame)
+	}
+	fi.updates[frame] = true
+	if fi.mutex.isMutex(frame) {
+		if err := fi.mutex.clear(frame, col, row); err != nil {
+			return errors.Wrapf(err, "tracking mutex frame %v", frame)
+		}
+	}
+	return fi.writeBitQuery("ClearBit", frame, col, row, nil)
+}
+
+// writeSetBit writes a SetBit query, preceded by a ClearBit query for the
+// column's previous row if frame is a mutex frame. fi.mu must be held.
+func (fi *FileIndex) writeSetBit(frame string, col, row uint64, ts *time.Time) error {
+	if fi.mutex.isMutex(frame) {
+		prev, seen, err := fi.mutex.set(frame, col, row)
+		if err != nil {
+			return errors.Wrapf(err, "tracking mutex frame %v", frame)
+		}
+		if seen && prev != row {
+			if err := fi.writeBitQuery("ClearBit", frame, col, prev, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return fi.writeBitQuery("SetBit", frame, col, row, ts)
+}
+
+// writeBitQuery writes a SetBit or ClearBit query to the frame's updates
+// file. fi.mu must be held.
+func (fi *FileIndex) writeBitQuery(call, frame string, col, row uint64, ts *time.Time) error {
+	fi.buf = append(fi.buf[:0], call...)
+	fi.buf = append(fi.buf, "(frame="...)
+	fi.buf = strconv.AppendQuote(fi.buf, frame)
+	fi.buf = append(fi.buf, ", rowID="...)
+	fi.buf = strconv.AppendUint(fi.buf, row, 10)
+	fi.buf = append(fi.buf, ", columnID="...)
+	fi.buf = strconv.AppendUint(fi.buf, col, 10)
+	if ts != nil {
+		fi.buf = append(fi.buf, ", timestamp="...)
+		fi.buf = strconv.AppendQuote(fi.buf, ts.UTC().Format(pilosa.TimeFormat))
+	}
+	fi.buf = append(fi.buf, ")\n"...)
+	return fi.write(exportKey{kind: ExportUpdates, frame: frame})
+}
+
+func (fi *FileIndex) AddValue(frame, field string, col uint64, val uint64) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	spec, ok := fi.frameSpec(frame)
+	if !ok {
+		return errors.Errorf("unknown frame in AddValue: %v", frame)
+	}
+	if !spec.hasField(field) {
+		return errors.Errorf("unknown field in AddValue: %v, frame: %v", field, frame)
+	}
+	fi.buf = strconv.AppendUint(fi.buf[:0], col, 10)
+	fi.buf = append(fi.buf, ',')
+	fi.buf = strconv.AppendUint(fi.buf, val, 10)
+	fi.buf = append(fi.buf, '\n')
+	return fi.write(exportKey{kind: ExportValues, frame: frame, field: field})
+}
+
+func (fi *FileIndex) AddRowAttrs(frame string, row uint64, attrs map[string]interface{}) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if _, ok := fi.frameSpec(frame); !ok {
+		return errors.Errorf("unknown frame in AddRowAttrs: %v", frame)
+	}
+	fi.buf = append(fi.buf[:0], "SetRowAttrs(frame="...)
+	fi.buf = strconv.AppendQuote(fi.buf, frame)
+	fi.buf = append(fi.buf, ", rowID="...)
+	fi.buf = strconv.AppendUint(fi.buf, row, 10)
+	fi.buf = appendPQLAttrs(fi.buf, attrs)
+	return fi.write(exportKey{kind: ExportRowAttrs, frame: frame})
+}
+
+func (fi *FileIndex) AddColumnAttrs(col uint64, attrs map[string]interface{}) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.buf = append(fi.buf[:0], "SetColumnAttrs(columnID="...)
+	fi.buf = strconv.AppendUint(fi.buf, col, 10)
+	fi.buf = appendPQLAttrs(fi.buf, attrs)
+	return fi.write(exportKey{kind: ExportColumnAttrs})
+}
+
+// appendPQLAttrs appends attrs as PQL arguments in a stable order, followed
+// by the closing parenthesis of the call and a newline.
+func appendPQLAttrs(buf []byte, attrs map[string]interface{}) []byte {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf = append(buf, ", "...)
+		buf = append(buf, k...)
+		buf = append(buf, '=')
+		switch v := attrs[k].(type) {
+		case nil:
+			buf = append(buf, "null"...)
+		case string:
+			buf = strconv.AppendQuote(buf, v)
+		case []byte:
+			buf = strconv.AppendQuote(buf, string(v))
+		default:
+			buf = append(buf, fmt.Sprint(v)...)
+		}
+	}
+	return append(buf, ")\n"...)
+}
+
+// Flush flushes buffered data to the files, and rewrites the manifest.
+func (fi *FileIndex) Flush() error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var errs Errors
+	for _, ef := range fi.current {
+		if err := ef.w.Flush(); err != nil {
+			errs = append(errs, errors.Wrapf(err, "flushing %v", fi.manifest.Files[ef.idx].Path))
+		}
+	}
+	if err := fi.writeManifest(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close closes all open files and writes the final manifest.
+func (fi *FileIndex) Close() error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var errs Errors
+	for key, ef := range fi.current {
+		if err := fi.closeFile(ef); err != nil {
+			errs = append(errs, err)
+		}
+		delete(fi.current, key)
+	}
+	fi.closed = true
+	if err := fi.writeManifest(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := fi.mutex.close(); err != nil {
+		errs = append(errs, errors.Wrap(err, "closing mutex tracker"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Manifest returns a copy of the current manifest.
+func (fi *FileIndex) Manifest() ExportManifest {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	m := fi.manifest
+	m.Files = append([]ExportFile(nil), fi.manifest.Files...)
+	return m
+}
+
+func (fi *FileIndex) frameSpec(frame string) (FrameSpec, bool) {
+	return fi.manifest.frameSpec(frame)
+}
+
+func (m ExportManifest) frameSpec(frame string) (FrameSpec, bool) {
+	for _, spec := range m.Frames {
+		if spec.Name == frame {
+			return spec, true
+		}
+	}
+	return FrameSpec{}, false
+}
+
+func (fs FrameSpec) hasField(field string) bool {
+	for _, f := range fs.Fields {
+		if f.Name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// write appends fi.buf to the current file for key, opening or rotating the
+// file as necessary. fi.mu must be held.
+func (fi *FileIndex) write(key exportKey) error {
+	if fi.closed {
+		return errors.New("FileIndex is closed")
+	}
+	ef, ok := fi.current[key]
+	if ok && fi.maxFileSize > 0 && fi.manifest.Files[ef.idx].Bytes+int64(len(fi.buf)) > fi.maxFileSize {
+		delete(fi.current, key)
+		if err := fi.closeFile(ef); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		var err error
+		ef, err = fi.openFile(key)
+		if err != nil {
+			return err
+		}
+		fi.current[key] = ef
+	}
+	n, err := ef.w.Write(fi.buf)
+	info := &fi.manifest.Files[ef.idx]
+	info.Bytes += int64(n)
+	if err != nil {
+		return errors.Wrapf(err, "writing %v", info.Path)
+	}
+	info.Count++
+	return nil
+}
+
+// openFile creates the next file for key. Files which already exist, such as
+// those written by an import which stopped before writing its manifest, are
+// skipped rather than truncated. fi.mu must be held.
+func (fi *FileIndex) openFile(key exportKey) (*exportFile, error) {
+	seq := 0
+	for _, info := range fi.manifest.Files {
+		if info.Kind == key.kind && info.Frame == key.frame && info.Field == key.field {
+			seq++
+		}
+	}
+	var path string
+	var f *os.File
+	for {
+		var err error
+		path = key.filename(seq)
+		f, err = os.OpenFile(filepath.Join(fi.dir, path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			seq++
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "creating export file")
+		}
+		break
+	}
+	fi.manifest.Files = append(fi.manifest.Files, ExportFile{Path: path, Kind: key.kind, Frame: key.frame, Field: key.field})
+	return &exportFile{
+		idx: len(fi.manifest.Files) - 1,
+		f:   f,
+		w:   bufio.NewWriter(f),
+	}, nil
+}
+
+func (fi *FileIndex) closeFile(ef *exportFile) error {
+	path := fi.manifest.Files[ef.idx].Path
+	if err := ef.w.Flush(); err != nil {
+		ef.f.Close()
+		return errors.Wrapf(err, "flushing %v", path)
+	}
+	return errors.Wrapf(ef.f.Close(), "closing %v", path)
+}
+
+// writeManifest atomically replaces the manifest file. fi.mu must be held.
+func (fi *FileIndex) writeManifest() error {
+	bs, err := json.MarshalIndent(fi.manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding manifest")
+	}
+	tmp := filepath.Join(fi.dir, ManifestFile+".tmp")
+	err = ioutil.WriteFile(tmp, bs, 0644)
+	if err != nil {
+		return errors.Wrap(err, "writing manifest")
+	}
+	return errors.Wrap(os.Rename(tmp, filepath.Join(fi.dir, ManifestFile)), "renaming manifest")
+}
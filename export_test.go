@@ -0,0 +1,174 @@
+package pdk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := []FrameSpec{
+		NewRankedFrameSpec("color", 100),
+		NewFieldsFrameSpec("stats", FieldSpec{Name: "price", Min: 0, Max: 100}),
+	}
+	fi, err := NewFileIndex(dir, "idx", frames, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBit("color", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBitTimestamp("color", 11, 2, time.Date(2017, 3, 4, 5, 6, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBit("color", 12, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddRowAttrs("color", 1, map[string]interface{}{"name": "red", "n": 3, "gone": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddColumnAttrs(10, map[string]interface{}{"ok": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddValue("stats", "price", 10, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBit("nope", 1, 1); err == nil {
+		t.Error("expected error adding bit to unknown frame")
+	}
+	if err := fi.AddValue("stats", "nope", 1, 1); err == nil {
+		t.Error("expected error adding value to unknown field")
+	}
+	if err := fi.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBit("color", 1, 1); err == nil {
+		t.Error("expected error adding bit after close")
+	}
+
+	expFiles := map[string]string{
+		"color/bits-0000.csv":        "1,10\n",
+		"color/bits-0001.csv":        "2,11,2017-03-04T05:06\n",
+		"color/bits-0002.csv":        "3,12\n",
+		"stats/field-price-0000.csv": "10,42\n",
+		"color/attrs-0000.pql":       `SetRowAttrs(frame="color", rowID=1, gone=null, n=3, name="red")` + "\n",
+		"column-attrs-0000.pql":      "SetColumnAttrs(columnID=10, ok=true)\n",
+	}
+	for path, exp := range expFiles {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != exp {
+			t.Errorf("%v: expected %q, got %q", path, exp, bs)
+		}
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ExportManifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest, fi.Manifest()) {
+		t.Fatalf("manifest file %v doesn't match %v", manifest, fi.Manifest())
+	}
+	if manifest.Index != "idx" || !reflect.DeepEqual(manifest.Frames, frames) {
+		t.Errorf("unexpected index or frames in manifest: %v", manifest)
+	}
+	expEntries := []ExportFile{
+		{Path: "color/bits-0000.csv", Kind: ExportBits, Frame: "color", Count: 1, Bytes: 5},
+		{Path: "color/bits-0001.csv", Kind: ExportBits, Frame: "color", Count: 1, Bytes: 22},
+		{Path: "color/bits-0002.csv", Kind: ExportBits, Frame: "color", Count: 1, Bytes: 5},
+		{Path: "color/attrs-0000.pql", Kind: ExportRowAttrs, Frame: "color", Count: 1, Bytes: 64},
+		{Path: "column-attrs-0000.pql", Kind: ExportColumnAttrs, Count: 1, Bytes: 37},
+		{Path: "stats/field-price-0000.csv", Kind: ExportValues, Frame: "stats", Field: "price", Count: 1, Bytes: 6},
+	}
+	if !reflect.DeepEqual(manifest.Files, expEntries) {
+		t.Errorf("expected manifest files %v, got %v", expEntries, manifest.Files)
+	}
+}
+
+func TestFileIndexResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schema := Schema{Index: "idx", Frames: []FrameSpec{NewRankedFrameSpec("color", 100)}}
+	conf := IndexerConfig{OutputDir: dir}
+	first, err := NewIndexer(conf, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.AddBit("color", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a file left by an import which stopped before writing its manifest
+	if err := ioutil.WriteFile(filepath.Join(dir, "color", "bits-0001.csv"), []byte("9,9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewIndexer(IndexerConfig{OutputDir: dir, Resume: true}, Schema{Index: "other", Frames: schema.Frames}); err == nil {
+		t.Error("expected error resuming into the output of another index")
+	}
+	conf.Resume = true
+	schema.Frames = append(schema.Frames, NewRankedFrameSpec("size", 100))
+	second, err := NewIndexer(conf, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.AddBit("color", 11, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.AddBit("size", 11, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expFiles := map[string]string{
+		"color/bits-0000.csv": "1,10\n",
+		"color/bits-0001.csv": "9,9\n",
+		"color/bits-0002.csv": "2,11\n",
+		"size/bits-0000.csv":  "3,11\n",
+	}
+	for path, exp := range expFiles {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != exp {
+			t.Errorf("%v: expected %q, got %q", path, exp, bs)
+		}
+	}
+	manifest := second.(*FileIndex).Manifest()
+	expEntries := []ExportFile{
+		{Path: "color/bits-0000.csv", Kind: ExportBits, Frame: "color", Count: 1, Bytes: 5},
+		{Path: "color/bits-0002.csv", Kind: ExportBits, Frame: "color", Count: 1, Bytes: 5},
+		{Path: "size/bits-0000.csv", Kind: ExportBits, Frame: "size", Count: 1, Bytes: 5},
+	}
+	if !reflect.DeepEqual(manifest.Files, expEntries) {
+		t.Errorf("expected manifest files %v, got %v", expEntries, manifest.Files)
+	}
+	if !reflect.DeepEqual(manifest.Frames, schema.Frames) {
+		t.Errorf("expected frames %v, got %v", schema.Frames, manifest.Frames)
+	}
+}
@@ -11,6 +11,10 @@ type FileFragment struct {
 	file     *os.File
 	startLoc int64
 	endLoc   int64
+
+	// dec is set for a compressed file, which is read as a single fragment
+	// through a decompressor.
+	dec io.ReadCloser
 }
 
 func NewFileFragment(f *os.File, startLoc, endLoc int64) (*FileFragment, error) {
@@ -30,6 +34,9 @@ func NewFileFragment(f *os.File, startLoc, endLoc int64) (*FileFragment, error)
 }
 
 func (ff *FileFragment) Read(b []byte) (n int, err error) {
+	if ff.dec != nil {
+		return ff.dec.Read(b)
+	}
 	offset, err := ff.file.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return 0, err
@@ -45,15 +52,37 @@ func (ff *FileFragment) Read(b []byte) (n int, err error) {
 	return ff.file.Read(b)
 }
 
+// Start returns the offset in the file at which the fragment begins. For a
+// compressed file it is 0, and offsets count decompressed bytes.
+func (ff *FileFragment) Start() int64 {
+	return ff.startLoc
+}
+
 func (ff *FileFragment) Close() error {
 	return nil // TODO
 }
 
+// SplitFileLines splits f into approximately numParts fragments, each ending
+// at a newline. Compressed files can't be split without decompressing them,
+// so a gzip or bzip2 file is returned as a single fragment which decompresses
+// as it is read.
 func SplitFileLines(f *os.File, numParts int64) ([]*FileFragment, error) {
 	stats, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
+	compressed, err := IsCompressed(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "checking for compression")
+	}
+	if compressed {
+		ff, err := NewFileFragment(f, 0, stats.Size())
+		if err != nil {
+			return nil, errors.Wrap(err, "creating new file fragment")
+		}
+		ff.dec = NewDecompressReader(ff.file, f.Name())
+		return []*FileFragment{ff}, nil
+	}
 	splitSize := stats.Size() / numParts
 
 	ret := make([]*FileFragment, 0)
@@ -0,0 +1,349 @@
+package pdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pilosa/pilosa"
+	"github.com/pilosa/pilosa/roaring"
+	"github.com/pkg/errors"
+)
+
+// fragmentKey identifies a fragment: the bits of one slice of a view of a
+// frame.
+type fragmentKey struct {
+	frame, view string
+	slice       uint64
+}
+
+// fragment is a roaring bitmap laid out as Pilosa stores a fragment, with bit
+// row*SliceWidth + col%SliceWidth set for each bit in the slice.
+// changed is set when bits are added or removed, and cleared by each
+// periodic send.
+type fragment struct {
+	bits    *roaring.Bitmap
+	rows    map[uint64]struct{}
+	changed bool
+}
+
+func (f *fragment) add(row, col uint64) {
+	f.bits.Add(row*pilosa.SliceWidth + col%pilosa.SliceWidth)
+	f.rows[row] = struct{}{}
+	f.changed = true
+}
+
+func (f *fragment) remove(row, col uint64) {
+	if changed, _ := f.bits.Remove(row*pilosa.SliceWidth + col%pilosa.SliceWidth); changed {
+		f.changed = true
+	}
+}
+
+// archive returns the fragment in the tar format which Pilosa's
+// /fragment/data endpoint reads: the roaring data, and the IDs of its rows
+// for the TopN cache, encoded as a protobuf Cache message.
+func (f *fragment) archive() ([]byte, error) {
+	data := &bytes.Buffer{}
+	if _, err := f.bits.WriteTo(data); err != nil {
+		return nil, errors.Wrap(err, "writing roaring data")
+	}
+	rows := make([]uint64, 0, len(f.rows))
+	for row := range f.rows {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+	cache := make([]byte, 0, len(rows)*(binary.MaxVarintLen64+1))
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, row := range rows {
+		cache = append(cache, 0x08) // field 1 (IDs), varint
+		cache = append(cache, varint[:binary.PutUvarint(varint, row)]...)
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{"data", data.Bytes()}, {"cache", cache}} {
+		err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.data)), ModTime: time.Now()})
+		if err != nil {
+			return nil, errors.Wrap(err, "writing archive header")
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, errors.Wrap(err, "writing archive")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "closing archive")
+	}
+	return buf.Bytes(), nil
+}
+
+// fragmentBuilder builds the fragments of an index locally from imported bits,
+// including the time and inverse views of each frame, and sends whole
+// fragments to the nodes which own them. It is used by Index for the roaring
+// backend in place of importing batches of bits.
+//
+// Sending a fragment replaces the fragment in Pilosa, so this is only
+// suitable for loading data into frames which are empty. Each fragment is sent
+// once, when its slice is finished (see send), and then freed. Bits which
+// arrive for a fragment after it was sent are imported with importSent
+// instead, which adds them to what Pilosa holds.
+type fragmentBuilder struct {
+	hosts      []string
+	index      string
+	specs      map[string]FrameSpec
+	client     *http.Client
+	importSent func(fram *pcli.Frame, it pcli.BitIterator) error
+
+	mu        sync.Mutex
+	fragments map[fragmentKey]*fragment
+	sent      map[fragmentKey]struct{}
+	nodes     map[uint64][]string
+}
+
+func newFragmentBuilder(hosts []string, schema Schema, importSent func(fram *pcli.Frame, it pcli.BitIterator) error) *fragmentBuilder {
+	b := &fragmentBuilder{
+		index:      schema.Index,
+		specs:      make(map[string]FrameSpec, len(schema.Frames)),
+		client:     &http.Client{Timeout: 10 * time.Minute},
+		importSent: importSent,
+		fragments:  make(map[fragmentKey]*fragment),
+		sent:       make(map[fragmentKey]struct{}),
+		nodes:      make(map[uint64][]string),
+	}
+	for _, host := range hosts {
+		b.hosts = append(b.hosts, hostURL(host))
+	}
+	for _, frame := range schema.Frames {
+		b.specs[frame.Name] = frame
+	}
+	return b
+}
+
+// hostURL adds the http scheme to host if it has none.
+func hostURL(host string) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return "http://" + host
+}
+
+// importBits adds the bits from it to the fragments of fram. Bits which
+// belong in a fragment which has already been sent are imported with
+// importSent, and are still added to the fragments of their other views which
+// haven't been sent, so that sending those doesn't drop them.
+func (b *fragmentBuilder) importBits(fram *pcli.Frame, it pcli.BitIterator) error {
+	spec, ok := b.specs[fram.Name()]
+	if !ok {
+		return errors.Errorf("unknown frame %v", fram.Name())
+	}
+	var late []pcli.Bit
+	b.mu.Lock()
+	for bit, err := it.NextBit(); err != io.EOF; bit, err = it.NextBit() {
+		if err != nil {
+			b.mu.Unlock()
+			return errors.Wrap(err, "reading bits")
+		}
+		sent := false
+		for _, view := range fragmentViews(pilosa.ViewStandard, spec, bit.Timestamp) {
+			sent = b.add(fragmentKey{spec.Name, view, bit.ColumnID / pilosa.SliceWidth}, bit.RowID, bit.ColumnID) || sent
+		}
+		if spec.InverseEnabled {
+			for _, view := range fragmentViews(pilosa.ViewInverse, spec, bit.Timestamp) {
+				sent = b.add(fragmentKey{spec.Name, view, bit.RowID / pilosa.SliceWidth}, bit.ColumnID, bit.RowID) || sent
+			}
+		}
+		if sent {
+			late = append(late, bit)
+		}
+	}
+	b.mu.Unlock()
+	if len(late) == 0 {
+		return nil
+	}
+	return errors.Wrap(b.importSent(fram, &sliceBitIterator{bits: late}), "importing bits for fragments which were sent")
+}
+
+// add sets a bit in the fragment for key, unless it has been sent, which add
+// reports. b.mu must be held.
+func (b *fragmentBuilder) add(key fragmentKey, row, col uint64) (sent bool) {
+	if _, ok := b.sent[key]; ok {
+		return true
+	}
+	f, ok := b.fragments[key]
+	if !ok {
+		f = &fragment{bits: roaring.NewBitmap(), rows: make(map[uint64]struct{})}
+		b.fragments[key] = f
+	}
+	f.add(row, col)
+	return false
+}
+
+// clearBit removes a bit from the standard and inverse fragments of fram, so
+// that it isn't set again when they are sent. Like a ClearBit query without a
+// timestamp, it doesn't affect time views. Fragments which have been sent are
+// left to the ClearBit query.
+func (b *fragmentBuilder) clearBit(fram *pcli.Frame, col, row uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.fragments[fragmentKey{fram.Name(), pilosa.ViewStandard, col / pilosa.SliceWidth}]; ok {
+		f.remove(row, col)
+	}
+	if f, ok := b.fragments[fragmentKey{fram.Name(), pilosa.ViewInverse, row / pilosa.SliceWidth}]; ok {
+		f.remove(col, row)
+	}
+}
+
+// fragmentViews returns the views which a bit with timestamp ts (in
+// nanoseconds, or 0 for none) is set in, starting from base.
+func fragmentViews(base string, spec FrameSpec, ts int64) []string {
+	views := []string{base}
+	if ts != 0 && spec.TimeQuantum != "" {
+		t := time.Unix(0, ts).UTC()
+		views = append(views, pilosa.ViewsByTime(base, t, pilosa.TimeQuantum(spec.TimeQuantum))...)
+	}
+	return views
+}
+
+// send sends fragments to the nodes which own their slices, retrying with
+// retry, and frees them. If final is set every fragment is sent. Otherwise,
+// as for a periodic Flush, only the fragments whose slices are finished are
+// sent, which are taken to be those that haven't changed since the previous
+// send. Fragments which fail are kept, to be sent by a later call.
+func (b *fragmentBuilder) send(retry RetryPolicy, throttle *throttle, final bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]fragmentKey, 0)
+	for key, f := range b.fragments {
+		if final || !f.changed {
+			keys = append(keys, key)
+		}
+		f.changed = false
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.frame != kj.frame {
+			return ki.frame < kj.frame
+		}
+		if ki.view != kj.view {
+			return ki.view < kj.view
+		}
+		return ki.slice < kj.slice
+	})
+
+	var errs Errors
+	for _, key := range keys {
+		f := b.fragments[key]
+		data, err := f.archive()
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "archiving fragment %v/%v/%v", key.frame, key.view, key.slice))
+			continue
+		}
+		err = retry.do(func() error {
+			return throttle.do(int(f.bits.Count()), func() error {
+				return b.sendFragment(key, data)
+			})
+		}, func(attempt int, wait time.Duration, err error) {
+			log.Printf("sending fragment %v/%v/%v failed (attempt %d), retrying in %v: %v", key.frame, key.view, key.slice, attempt, wait, err)
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "sending fragment %v/%v/%v", key.frame, key.view, key.slice))
+			continue
+		}
+		delete(b.fragments, key)
+		b.sent[key] = struct{}{}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// sendFragment posts an archived fragment to each node which owns its slice.
+func (b *fragmentBuilder) sendFragment(key fragmentKey, data []byte) error {
+	nodes, err := b.fragmentNodes(key.slice)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		q := url.Values{}
+		q.Set("index", b.index)
+		q.Set("frame", key.frame)
+		q.Set("view", key.view)
+		q.Set("slice", fmt.Sprint(key.slice))
+		resp, err := b.client.Post(node+"/fragment/data?"+q.Encode(), "application/octet-stream", bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrapf(err, "posting to %v", node)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("posting to %v: %v: %s", node, resp.Status, bytes.TrimSpace(body))
+		}
+	}
+	return nil
+}
+
+// fragmentNodes returns the URLs of the nodes which own slice, asking each of
+// the configured hosts in turn until one answers.
+func (b *fragmentBuilder) fragmentNodes(slice uint64) ([]string, error) {
+	if nodes, ok := b.nodes[slice]; ok {
+		return nodes, nil
+	}
+	var err error
+	for _, host := range b.hosts {
+		var nodes []string
+		nodes, err = b.fetchFragmentNodes(host, slice)
+		if err == nil {
+			b.nodes[slice] = nodes
+			return nodes, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no hosts")
+	}
+	return nil, errors.Wrapf(err, "getting nodes for slice %v", slice)
+}
+
+func (b *fragmentBuilder) fetchFragmentNodes(host string, slice uint64) ([]string, error) {
+	resp, err := b.client.Get(fmt.Sprintf("%s/fragment/nodes?index=%s&slice=%d", host, url.QueryEscape(b.index), slice))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.Errorf("%v: %v: %s", host, resp.Status, bytes.TrimSpace(body))
+	}
+	var nodes []struct {
+		Scheme string `json:"scheme"`
+		Host   string `json:"host"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+		return nil, errors.Wrapf(err, "decoding nodes from %v", host)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Errorf("%v: no nodes own slice %v", host, slice)
+	}
+	urls := make([]string, len(nodes))
+	for i, node := range nodes {
+		urls[i] = hostURL(node.Host)
+		if node.Scheme != "" && !strings.Contains(node.Host, "://") {
+			urls[i] = node.Scheme + "://" + node.Host
+		}
+	}
+	return urls, nil
+}
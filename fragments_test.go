@@ -0,0 +1,191 @@
+package pdk
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+)
+
+func TestFragmentBuilder(t *testing.T) {
+	const w = 1048576
+	var mu sync.Mutex
+	posts := make(map[string][]uint64) // fragment -> cached row IDs
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fragment/nodes":
+			if r.URL.Query().Get("index") != "i" {
+				http.Error(rw, "bad index", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(rw, `[{"scheme":"http","host":"`+r.Host+`"}]`)
+		case "/fragment/data":
+			q := r.URL.Query()
+			cache, err := readFragmentCache(r.Body)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			posts[q.Get("frame")+"/"+q.Get("view")+"/"+q.Get("slice")] = cache
+			mu.Unlock()
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer srv.Close()
+
+	schema := Schema{Index: "i", Frames: []FrameSpec{
+		{Name: "f", InverseEnabled: true},
+		{Name: "t", TimeQuantum: pcli.TimeQuantumYearMonth},
+	}}
+	var late []pcli.Bit
+	b := newFragmentBuilder([]string{strings.TrimPrefix(srv.URL, "http://")}, schema, func(fram *pcli.Frame, it pcli.BitIterator) error {
+		for bit, err := it.NextBit(); err != io.EOF; bit, err = it.NextBit() {
+			late = append(late, bit)
+		}
+		return nil
+	})
+	idx, _ := pcli.NewIndex("i", nil)
+	f, _ := idx.Frame("f", nil)
+	tf, _ := idx.Frame("t", nil)
+
+	ts := time.Date(2017, 3, 4, 0, 0, 0, 0, time.UTC).UnixNano()
+	if err := b.importBits(f, &sliceBitIterator{bits: []pcli.Bit{
+		{RowID: 1, ColumnID: 2}, {RowID: 3, ColumnID: w + 4}, {RowID: 1, ColumnID: 3},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.importBits(tf, &sliceBitIterator{bits: []pcli.Bit{{RowID: 5, ColumnID: 6, Timestamp: ts}}}); err != nil {
+		t.Fatal(err)
+	}
+	b.clearBit(f, 3, 1)
+
+	bits := map[string][]uint64{
+		"f/standard/0":        {1*w + 2},
+		"f/standard/1":        {3*w + 4},
+		"f/inverse/0":         {2*w + 1, (w+4)*w + 3},
+		"t/standard/0":        {5*w + 6},
+		"t/standard_2017/0":   {5*w + 6},
+		"t/standard_201703/0": {5*w + 6},
+	}
+	if len(b.fragments) != len(bits) {
+		t.Errorf("expected %d fragments, got %d", len(bits), len(b.fragments))
+	}
+	for key, f := range b.fragments {
+		name := fmt.Sprintf("%v/%v/%v", key.frame, key.view, key.slice)
+		if got := f.bits.Slice(); !reflect.DeepEqual(got, bits[name]) {
+			t.Errorf("fragment %v: expected %v, got %v", name, bits[name], got)
+		}
+	}
+
+	// every fragment changed since the last send, so none are finished
+	if err := b.send(RetryPolicy{Attempts: 1}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("expected no fragments to be sent, got %v", sortedKeys(posts))
+	}
+	if err := b.importBits(f, &sliceBitIterator{bits: []pcli.Bit{{RowID: 2, ColumnID: w}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.send(RetryPolicy{Attempts: 1}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string][]uint64{
+		"f/standard/0":        {1},
+		"t/standard/0":        {5},
+		"t/standard_2017/0":   {5},
+		"t/standard_201703/0": {5},
+	}
+	if !reflect.DeepEqual(posts, exp) {
+		t.Errorf("expected posts %v, got %v", exp, posts)
+	}
+	if len(b.fragments) != 2 {
+		t.Errorf("expected the sent fragments to be freed, %d left", len(b.fragments))
+	}
+
+	// a bit for a sent fragment is imported, and still added to the inverse
+	// fragment, which hasn't been sent
+	mu.Lock()
+	posts = make(map[string][]uint64)
+	mu.Unlock()
+	if err := b.importBits(f, &sliceBitIterator{bits: []pcli.Bit{{RowID: 4, ColumnID: 7}}}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []pcli.Bit{{RowID: 4, ColumnID: 7}}; !reflect.DeepEqual(late, exp) {
+		t.Errorf("expected %v to be imported, got %v", exp, late)
+	}
+	if err := b.send(RetryPolicy{Attempts: 1}, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string][]uint64{
+		"f/standard/1": {2, 3},
+		"f/inverse/0":  {2, 3, 7, w, w + 4},
+	}
+	if !reflect.DeepEqual(posts, exp) {
+		t.Errorf("expected posts %v, got %v", exp, posts)
+	}
+	if len(b.fragments) != 0 {
+		t.Errorf("expected every fragment to be freed, %d left", len(b.fragments))
+	}
+}
+
+// readFragmentCache reads a fragment archive, checks that it has data, and
+// returns the row IDs in its cache.
+func readFragmentCache(r io.Reader) ([]uint64, error) {
+	tr := tar.NewReader(r)
+	var names []string
+	var ids []uint64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		names = append(names, hdr.Name)
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name != "cache" {
+			continue
+		}
+		for len(data) > 0 {
+			if data[0] != 0x08 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			id, n := binary.Uvarint(data[1:])
+			if n <= 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			ids = append(ids, id)
+			data = data[1+n:]
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"data", "cache"}) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ids, nil
+}
+
+func sortedKeys(m map[string][]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
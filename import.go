@@ -1,63 +1,35 @@
 package pdk
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"sync"
 	"time"
 
-	"context"
-
+	pcli "github.com/pilosa/go-pilosa"
 	"github.com/pilosa/pilosa"
 	"github.com/pilosa/pilosa/ctl"
+	"github.com/pkg/errors"
 )
 
-type PilosaImporter interface {
-	SetBit(rowID, columnID uint64, frame string)
-	SetBitTimestamp(rowID, columnID uint64, frame string, timestamp time.Time)
-	Close()
-}
-
-type Bit struct {
-	row uint64
-	col uint64
-	ts  *time.Time
-}
-
-type ImportClient struct {
-	BufferSize int
-
-	channels map[string]chan Bit
-	wg       sync.WaitGroup
-}
-
-func NewImportClient(host, index string, frames []string, bufsize int) *ImportClient {
-	ic := &ImportClient{
-		BufferSize: bufsize,
-	}
-	ic.channels = make(map[string]chan Bit, len(frames))
-	for _, frame := range frames {
-		ic.wg.Add(1)
-		ic.channels[frame] = make(chan Bit, bufsize)
-		go writer(ic.channels[frame], host, index, frame, ic.BufferSize, &ic.wg)
-	}
-	return ic
-}
-
-func (ic *ImportClient) SetBit(rowID, columnID uint64, frame string) {
-	ic.channels[frame] <- Bit{row: rowID, col: columnID}
+// NewImportClient creates the index and frames in Pilosa if necessary, and
+// returns an Indexer which imports bits by piping them through a
+// ctl.ImportCommand (as `pilosa import` does) rather than with go-pilosa.
+// bufsize is the number of bits the import command sends at once.
+func NewImportClient(host, index string, frames []FrameSpec, bufsize int) (Indexer, error) {
+	return NewIndexer(IndexerConfig{
+		Backend:   BackendCtl,
+		Hosts:     []string{host},
+		BatchSize: bufsize,
+	}, Schema{Index: index, Frames: frames})
 }
 
-func (ic *ImportClient) SetBitTimestamp(rowID, columnID uint64, frame string, timestamp time.Time) {
-	ic.channels[frame] <- Bit{row: rowID, col: columnID, ts: &timestamp}
-}
-
-func writer(bits <-chan Bit, host, index, frame string, bufsize int, wg *sync.WaitGroup) {
-	defer wg.Done()
+// ctlImport writes the bits from it to a ctl.ImportCommand as CSV, and
+// returns once they have all been imported. The import command reads bufsize
+// bits before sending them to Pilosa, during which writes to the pipe block.
+func ctlImport(host, index, frame string, bufsize int, it pcli.BitIterator) error {
 	pipeR, pipeW := io.Pipe()
-	defer pipeW.Close()
 	importer := ctl.ImportCommand{
 		Host:       host,
 		Index:      index,
@@ -68,32 +40,42 @@ func writer(bits <-chan Bit, host, index, frame string, bufsize int, wg *sync.Wa
 		CmdIO: pilosa.NewCmdIO(pipeR, os.Stdout, os.Stderr),
 	}
 
+	done := make(chan error, 1)
 	go func() {
 		err := importer.Run(context.Background())
-		if err != nil {
-			log.Printf("Error with importer - frame: %v, err: %v", frame, err)
-		}
+		// unblock the writer if the importer stopped early
+		pipeR.CloseWithError(errors.New("importer exited"))
+		done <- err
 	}()
 
-	for bit := range bits {
-		var err error
-		if bit.ts == nil {
-			_, err = pipeW.Write([]byte(fmt.Sprintf("%d,%d\n", bit.row, bit.col)))
+	var werr error
+	for {
+		bit, err := it.NextBit()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			werr = errors.Wrap(err, "reading bits")
+			break
+		}
+		if bit.Timestamp == 0 {
+			_, err = fmt.Fprintf(pipeW, "%d,%d\n", bit.RowID, bit.ColumnID)
 		} else {
-			_, err = pipeW.Write([]byte(
-				fmt.Sprintf("%d,%d,%s\n", bit.row, bit.col, bit.ts.Format(pilosa.TimeFormat))))
+			ts := time.Unix(0, bit.Timestamp).UTC()
+			_, err = fmt.Fprintf(pipeW, "%d,%d,%s\n", bit.RowID, bit.ColumnID, ts.Format(pilosa.TimeFormat))
 		}
 		if err != nil {
-			log.Printf("Error writing to import pipe frame: %s, err: %v", frame, err)
+			werr = errors.Wrap(err, "writing to import pipe")
+			break
 		}
 	}
-}
-
-func (ic *ImportClient) Close() {
-	for _, c := range ic.channels {
-		close(c)
+	pipeW.Close()
+	if werr != nil {
+		// drain it so that senders don't block
+		for _, err := it.NextBit(); err == nil; _, err = it.NextBit() {
+		}
+	}
+	if err := <-done; err != nil {
+		return errors.Wrap(err, "running import command")
 	}
-	log.Println("Waiting for all import client subroutines to complete")
-	ic.wg.Wait()
-	log.Println("Import client closed")
+	return werr
 }
@@ -0,0 +1,197 @@
+package pdk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSONRecord is a single record read from a JSONSource. Values has one entry
+// per path the source was created with, so BitMapper.Fields index into it the
+// same way they index into the fields of a CSV line. A path may resolve to
+// zero or more values - arrays are fanned out into multiple values.
+type JSONRecord struct {
+	Values [][]string
+	Pos    Position
+}
+
+// JSONSource reads newline delimited JSON objects and extracts fields from
+// them by path.
+//
+// Paths are dotted object keys, with optional array indexes either in
+// brackets or as a numeric segment: "user.name", "items[0].sku" and
+// "items.0.sku" are all valid. An array which is reached without an index is
+// fanned out, so "items.sku" returns the sku of every item.
+type JSONSource struct {
+	name  string
+	paths [][]pathSeg
+	r     *bufio.Reader
+	line  int
+}
+
+// NewJSONSource creates a JSONSource reading from r, which may be gzip or
+// bzip2 compressed. name is used to report positions, and is typically the
+// file name or URL.
+func NewJSONSource(r io.Reader, name string, paths ...string) (*JSONSource, error) {
+	s := &JSONSource{
+		name:  name,
+		paths: make([][]pathSeg, len(paths)),
+		r:     bufio.NewReader(NewDecompressReader(r, name)),
+	}
+	for i, path := range paths {
+		segs, err := parsePath(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing path '%v'", path)
+		}
+		s.paths[i] = segs
+	}
+	return s, nil
+}
+
+// Record returns the next record. It returns io.EOF when the input is
+// exhausted, and a *SourceError for a line which is not a valid JSON object -
+// in which case the caller may log it and call Record again. Blank lines are
+// skipped.
+func (s *JSONSource) Record() (*JSONRecord, error) {
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Wrapf(err, "reading %v", s.name)
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		s.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		pos := Position{File: s.name, Line: s.line}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return nil, &SourceError{Pos: pos, Err: errors.Wrap(err, "decoding json")}
+		}
+		rec := &JSONRecord{
+			Values: make([][]string, len(s.paths)),
+			Pos:    pos,
+		}
+		for i, segs := range s.paths {
+			rec.Values[i] = extract(obj, segs, nil)
+		}
+		return rec, nil
+	}
+}
+
+// pathSeg is one element of a parsed path. It selects either an object key,
+// or (if index is non-negative) an array element.
+type pathSeg struct {
+	key   string
+	index int
+}
+
+func parsePath(path string) ([]pathSeg, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	segs := make([]pathSeg, 0)
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var idxs []int
+		if b := strings.IndexByte(part, '['); b >= 0 {
+			key = part[:b]
+			rest := part[b:]
+			for len(rest) > 0 {
+				end := strings.IndexByte(rest, ']')
+				if rest[0] != '[' || end < 0 {
+					return nil, errors.Errorf("malformed index in '%v'", part)
+				}
+				idx, err := strconv.Atoi(rest[1:end])
+				if err != nil || idx < 0 {
+					return nil, errors.Errorf("bad array index in '%v'", part)
+				}
+				idxs = append(idxs, idx)
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && len(idxs) == 0 {
+			return nil, errors.Errorf("empty segment in '%v'", path)
+		}
+		if key != "" {
+			segs = append(segs, pathSeg{key: key, index: -1})
+		}
+		for _, idx := range idxs {
+			segs = append(segs, pathSeg{index: idx})
+		}
+	}
+	return segs, nil
+}
+
+// extract walks segs through the decoded JSON value v and appends the string
+// form of every value it reaches to vals.
+func extract(v interface{}, segs []pathSeg, vals []string) []string {
+	if arr, ok := v.([]interface{}); ok {
+		if len(segs) > 0 && segs[0].index >= 0 {
+			if segs[0].index >= len(arr) {
+				return vals
+			}
+			return extract(arr[segs[0].index], segs[1:], vals)
+		}
+		if len(segs) > 0 && segs[0].key != "" {
+			if idx, err := strconv.Atoi(segs[0].key); err == nil {
+				if idx < 0 || idx >= len(arr) {
+					return vals
+				}
+				return extract(arr[idx], segs[1:], vals)
+			}
+		}
+		// no index given - fan out
+		for _, elem := range arr {
+			vals = extract(elem, segs, vals)
+		}
+		return vals
+	}
+	if len(segs) == 0 {
+		if s, ok := jsonString(v); ok {
+			vals = append(vals, s)
+		}
+		return vals
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok || segs[0].index >= 0 {
+		return vals
+	}
+	child, ok := obj[segs[0].key]
+	if !ok {
+		return vals
+	}
+	return extract(child, segs[1:], vals)
+}
+
+// jsonString converts a leaf JSON value to a string suitable for a Parser.
+// Nulls produce no value, and objects are re-encoded as JSON.
+func jsonString(v interface{}) (string, bool) {
+	switch vt := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return vt, true
+	case json.Number:
+		return vt.String(), true
+	case bool:
+		return strconv.FormatBool(vt), true
+	default:
+		bs, err := json.Marshal(vt)
+		if err != nil {
+			return "", false
+		}
+		return string(bs), true
+	}
+}
@@ -0,0 +1,125 @@
+package pdk
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJSONSource(t *testing.T) {
+	data := `{"user": {"name": "bob", "age": 31}, "items": [{"sku": "a1"}, {"sku": "b2"}], "tags": ["x", "y"], "ok": true}
+
+{"user": {"name": "alice"}, "items": [], "tags": null}
+{not json}
+{"user": {"name": "eve", "age": 7}, "items": [{"sku": "c3"}], "matrix": [[1, 2], [3]]}
+`
+	src, err := NewJSONSource(strings.NewReader(data), "app.log",
+		"user.name", "user.age", "items.sku", "items[1].sku", "tags.0", "ok", "matrix", "matrix[0][1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line int
+		exp  [][]string
+	}{
+		{
+			line: 1,
+			exp:  [][]string{{"bob"}, {"31"}, {"a1", "b2"}, {"b2"}, {"x"}, {"true"}, nil, nil},
+		},
+		{
+			line: 3,
+			exp:  [][]string{{"alice"}, nil, nil, nil, nil, nil, nil, nil},
+		},
+		{
+			line: 5,
+			exp:  [][]string{{"eve"}, {"7"}, {"c3"}, nil, nil, nil, {"1", "2", "3"}, {"2"}},
+		},
+	}
+
+	for i, test := range tests {
+		rec, err := src.Record()
+		if serr, ok := err.(*SourceError); ok && serr.Pos.Line == 4 {
+			rec, err = src.Record()
+		}
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if rec.Pos.Line != test.line {
+			t.Fatalf("test %d: expected line %d, got %v", i, test.line, rec.Pos)
+		}
+		if !reflect.DeepEqual(rec.Values, test.exp) {
+			t.Fatalf("test %d: expected %#v, got %#v", i, test.exp, rec.Values)
+		}
+	}
+	_, err = src.Record()
+	if err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		exp  []pathSeg
+		err  bool
+	}{
+		{path: "a.b", exp: []pathSeg{{key: "a", index: -1}, {key: "b", index: -1}}},
+		{path: "a[2].b", exp: []pathSeg{{key: "a", index: -1}, {index: 2}, {key: "b", index: -1}}},
+		{path: "a[1][0]", exp: []pathSeg{{key: "a", index: -1}, {index: 1}, {index: 0}}},
+		{path: "a..b", err: true},
+		{path: "a[x]", err: true},
+		{path: "a[1", err: true},
+		{path: "", err: true},
+	}
+	for i, test := range tests {
+		segs, err := parsePath(test.path)
+		if test.err {
+			if err == nil {
+				t.Fatalf("test %d: expected error for '%s', got %v", i, test.path, segs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(segs, test.exp) {
+			t.Fatalf("test %d: expected %v, got %v", i, test.exp, segs)
+		}
+	}
+}
+
+func TestBitMapperMultiRowIDs(t *testing.T) {
+	bm := BitMapper{
+		Frame:   "total",
+		Mapper:  CustomMapper{Func: func(fields ...interface{}) interface{} { return fields[0].(int64) + fields[1].(int64) }, Mapper: IntMapper{Min: 0, Max: 100}},
+		Parsers: []Parser{IntParser{}, IntParser{}},
+		Fields:  []int{0, 2},
+	}
+	ids, err := bm.MultiRowIDs([][]string{{"1", "2"}, {"unused"}, {"10", "20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(int64Slice(ids))
+	if !reflect.DeepEqual(ids, []int64{11, 12, 21, 22}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	ids, err = bm.MultiRowIDs([][]string{{"1"}, nil, nil})
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("expected no ids for missing value, got %v, %v", ids, err)
+	}
+
+	_, err = bm.MultiRowIDs([][]string{{"1"}, nil, {"x"}})
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -78,6 +78,16 @@ func NewLevelTranslator(dirname string, frames ...string) (lt *LevelTranslator,
 		if err != nil {
 			return nil, errors.Wrapf(err, "opening leveldb at %v", dirname+"/"+frame+"-val")
 		}
+		// continue numbering after the highest id from a previous run, so
+		// that existing ids are not handed out again.
+		iter := mdbs.idMap.NewIterator(nil, nil)
+		if iter.Last() {
+			initialID = binary.BigEndian.Uint64(iter.Key()) + 1
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, errors.Wrapf(err, "finding highest id for frame %v", frame)
+		}
 		lt.frames[frame] = mdbs
 	}
 	return lt, err
@@ -98,6 +108,24 @@ func (lt *LevelTranslator) Get(frame string, id uint64) (val interface{}) {
 	return data
 }
 
+// LookupValue returns the value mapped to id in frame. ok is false if id
+// hasn't been mapped.
+func (lt *LevelTranslator) LookupValue(frame string, id uint64) (val interface{}, ok bool, err error) {
+	dbs, ok := lt.frames[frame]
+	if !ok {
+		return nil, false, errors.Errorf("frame %v not found in level translator", frame)
+	}
+	idBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(idBytes, id)
+	data, err := dbs.idMap.Get(idBytes, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.Wrap(err, "trying to read id map")
+	}
+	return data, true, nil
+}
+
 func (lt *LevelTranslator) GetID(frame string, val interface{}) (id uint64, err error) {
 	var dbs mapDBs
 	var ok bool
@@ -148,6 +176,47 @@ func (lt *LevelTranslator) GetID(frame string, val interface{}) (id uint64, err
 	return new - 1, nil
 }
 
+// LookupID returns the id of val without allocating one.
+func (lt *LevelTranslator) LookupID(frame string, val interface{}) (id uint64, ok bool, err error) {
+	dbs, ok := lt.frames[frame]
+	if !ok {
+		return 0, false, errors.Errorf("frame %v not found in level translator", frame)
+	}
+	var valBytes []byte
+	switch valt := val.(type) {
+	case []byte:
+		valBytes = valt
+	case string:
+		valBytes = []byte(valt)
+	default:
+		return 0, false, errors.Errorf("val needs to be of type []byte, but is type: %T, val: '%v'", val, val)
+	}
+	data, err := dbs.valMap.Get(valBytes, &opt.ReadOptions{})
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "trying to read value map")
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// RangeKeys calls fn with each value mapped in frame, in byte order, until fn
+// returns false.
+func (lt *LevelTranslator) RangeKeys(frame string, fn func(key string) bool) error {
+	dbs, ok := lt.frames[frame]
+	if !ok {
+		return errors.Errorf("frame %v not found in level translator", frame)
+	}
+	iter := dbs.valMap.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if !fn(string(iter.Key())) {
+			break
+		}
+	}
+	return errors.Wrapf(iter.Error(), "iterating values of frame %v", frame)
+}
+
 type ValueLocker interface {
 	Lock(val []byte)
 	Unlock(val []byte)
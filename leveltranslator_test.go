@@ -66,6 +66,14 @@ func TestLevelTranslator(t *testing.T) {
 	if id1again != id1 || id2again != id2 {
 		t.Fatalf("didn't get same ids for same values id1: %v, 1again: %v, 2: %v, 2again: %v", id1, id1again, id2, id2again)
 	}
+
+	id3, err := bt.GetID("f1", []byte("world"))
+	if err != nil {
+		t.Fatalf("couldn't get id for world in f1: %v", err)
+	}
+	if id3 == id1 {
+		t.Fatalf("after reopen, new value got existing id %v", id3)
+	}
 }
 
 func TestConcLevelTranslator(t *testing.T) {
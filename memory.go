@@ -0,0 +1,426 @@
+package pdk
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pilosa/pilosa/pql"
+	"github.com/pkg/errors"
+)
+
+// MemIndex is an in-memory Indexer which keeps a row to column bitmap for each
+// frame, and the values of each BSI field. It can evaluate simple PQL queries
+// (Bitmap, Intersect, Union, Difference, Count and TopN) against those
+// bitmaps, so that pipelines can be tested and small datasets explored
+// without a running Pilosa. Timestamps are accepted but time views are not
+// kept - a timestamped bit is treated like any other bit. Setting a bit in a
+// mutex frame clears any other bit in the same column.
+type MemIndex struct {
+	mu       sync.RWMutex
+	frames   map[string]*memFrame
+	colAttrs map[uint64]map[string]interface{}
+}
+
+type memFrame struct {
+	mutex    bool
+	rows     map[uint64]columnSet
+	fields   map[string]map[uint64]uint64
+	rowAttrs map[uint64]map[string]interface{}
+}
+
+// columnSet is a set of column IDs.
+type columnSet map[uint64]struct{}
+
+// sorted returns the columns in cs in ascending order.
+func (cs columnSet) sorted() []uint64 {
+	cols := make([]uint64, 0, len(cs))
+	for col := range cs {
+		cols = append(cols, col)
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i] < cols[j] })
+	return cols
+}
+
+// NewMemIndex creates a MemIndex with the given frames. As with an Index
+// created by SetupPilosa, adding bits or values to a frame or field which was
+// not specified is an error.
+func NewMemIndex(frames []FrameSpec) *MemIndex {
+	m := &MemIndex{
+		frames:   make(map[string]*memFrame, len(frames)),
+		colAttrs: make(map[uint64]map[string]interface{}),
+	}
+	for _, spec := range frames {
+		f := &memFrame{
+			mutex:    spec.Mutex,
+			rows:     make(map[uint64]columnSet),
+			fields:   make(map[string]map[uint64]uint64, len(spec.Fields)),
+			rowAttrs: make(map[uint64]map[string]interface{}),
+		}
+		for _, field := range spec.Fields {
+			f.fields[field.Name] = make(map[uint64]uint64)
+		}
+		m.frames[spec.Name] = f
+	}
+	return m
+}
+
+func (m *MemIndex) AddBit(frame string, col uint64, row uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddBit: %v", frame)
+	}
+	f.setBit(row, col)
+	return nil
+}
+
+func (m *MemIndex) AddBitTimestamp(frame string, col uint64, row uint64, ts time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddBitTimestamp: %v", frame)
+	}
+	f.setBit(row, col)
+	return nil
+}
+
+func (m *MemIndex) ClearBit(frame string, col uint64, row uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in ClearBit: %v", frame)
+	}
+	f.clearBit(row, col)
+	return nil
+}
+
+func (m *MemIndex) AddValue(frame, field string, col uint64, val uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddValue: %v", frame)
+	}
+	vals, ok := f.fields[field]
+	if !ok {
+		return errors.Errorf("unknown field in AddValue: %v, frame: %v", field, frame)
+	}
+	vals[col] = val
+	return nil
+}
+
+func (m *MemIndex) AddRowAttrs(frame string, row uint64, attrs map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddRowAttrs: %v", frame)
+	}
+	mergeAttrs(f.rowAttrs, row, attrs)
+	return nil
+}
+
+func (m *MemIndex) AddColumnAttrs(col uint64, attrs map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mergeAttrs(m.colAttrs, col, attrs)
+	return nil
+}
+
+// mergeAttrs merges attrs into the existing attributes of id, removing those
+// with a nil value as Pilosa does.
+func mergeAttrs(all map[uint64]map[string]interface{}, id uint64, attrs map[string]interface{}) {
+	existing, ok := all[id]
+	if !ok {
+		existing = make(map[string]interface{}, len(attrs))
+		all[id] = existing
+	}
+	for k, v := range attrs {
+		if v == nil {
+			delete(existing, k)
+		} else {
+			existing[k] = v
+		}
+	}
+	if len(existing) == 0 {
+		delete(all, id)
+	}
+}
+
+// Flush is a no-op - everything added to a MemIndex is immediately visible.
+func (m *MemIndex) Flush() error { return nil }
+
+// Close is a no-op. The MemIndex may still be queried after it is closed.
+func (m *MemIndex) Close() error { return nil }
+
+func (f *memFrame) setBit(row, col uint64) {
+	if f.mutex {
+		for r := range f.rows {
+			if r != row {
+				f.clearBit(r, col)
+			}
+		}
+	}
+	cols, ok := f.rows[row]
+	if !ok {
+		cols = make(columnSet)
+		f.rows[row] = cols
+	}
+	cols[col] = struct{}{}
+}
+
+func (f *memFrame) clearBit(row, col uint64) {
+	cols, ok := f.rows[row]
+	if !ok {
+		return
+	}
+	delete(cols, col)
+	if len(cols) == 0 {
+		delete(f.rows, row)
+	}
+}
+
+// Row returns the columns set in a row of frame in ascending order.
+func (m *MemIndex) Row(frame string, row uint64) []uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return nil
+	}
+	return f.rows[row].sorted()
+}
+
+// Value returns the value of a BSI field for col, and whether it was set.
+func (m *MemIndex) Value(frame, field string, col uint64) (uint64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return 0, false
+	}
+	val, ok := f.fields[field][col]
+	return val, ok
+}
+
+// RowAttrs returns a copy of the attributes of a row of frame.
+func (m *MemIndex) RowAttrs(frame string, row uint64) map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.frames[frame]
+	if !ok {
+		return nil
+	}
+	return copyAttrs(f.rowAttrs[row])
+}
+
+// ColumnAttrs returns a copy of the attributes of a column.
+func (m *MemIndex) ColumnAttrs(col uint64) map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyAttrs(m.colAttrs[col])
+}
+
+func copyAttrs(attrs map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	return cp
+}
+
+// RowCount is a row ID and the number of columns set in it, as returned by
+// TopN.
+type RowCount struct {
+	ID    uint64 `json:"id"`
+	Count uint64 `json:"count"`
+}
+
+// Query parses and executes a PQL query, returning one result per top level
+// call. See Execute for the result types.
+func (m *MemIndex) Query(query string) ([]interface{}, error) {
+	q, err := pql.ParseString(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing query")
+	}
+	results := make([]interface{}, len(q.Calls))
+	for i, call := range q.Calls {
+		results[i], err = m.Execute(call)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// Execute evaluates a single PQL call. Bitmap, Intersect, Union and
+// Difference return the matching columns as a sorted []uint64, Count returns
+// a uint64, and TopN returns a []RowCount ordered by descending count. TopN
+// takes "frame" and optionally "n" arguments, and may be given a bitmap call
+// as a child to restrict the columns which are counted.
+func (m *MemIndex) Execute(call *pql.Call) (interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch call.Name {
+	case "Count":
+		if len(call.Children) != 1 {
+			return nil, errors.Errorf("Count expects 1 bitmap argument, got %v", len(call.Children))
+		}
+		cols, err := m.bitmap(call.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return uint64(len(cols)), nil
+	case "TopN":
+		return m.topN(call)
+	}
+	cols, err := m.bitmap(call)
+	if err != nil {
+		return nil, err
+	}
+	return cols.sorted(), nil
+}
+
+// bitmap evaluates a call which results in a set of columns. m.mu must be
+// held.
+func (m *MemIndex) bitmap(call *pql.Call) (columnSet, error) {
+	switch call.Name {
+	case "Bitmap":
+		f, err := m.frame(call)
+		if err != nil {
+			return nil, err
+		}
+		row, err := uintArg(call, "rowID")
+		if err != nil {
+			return nil, err
+		}
+		res := make(columnSet, len(f.rows[row]))
+		for col := range f.rows[row] {
+			res[col] = struct{}{}
+		}
+		return res, nil
+	case "Intersect", "Union", "Difference":
+		if len(call.Children) == 0 {
+			return nil, errors.Errorf("%v expects at least 1 bitmap argument", call.Name)
+		}
+		res, err := m.bitmap(call.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range call.Children[1:] {
+			other, err := m.bitmap(child)
+			if err != nil {
+				return nil, err
+			}
+			switch call.Name {
+			case "Intersect":
+				for col := range res {
+					if _, ok := other[col]; !ok {
+						delete(res, col)
+					}
+				}
+			case "Union":
+				for col := range other {
+					res[col] = struct{}{}
+				}
+			case "Difference":
+				for col := range other {
+					delete(res, col)
+				}
+			}
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("unsupported call in MemIndex: %v", call.Name)
+}
+
+// topN counts the columns of each row in a frame. m.mu must be held.
+func (m *MemIndex) topN(call *pql.Call) ([]RowCount, error) {
+	f, err := m.frame(call)
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	if _, ok := call.Args["n"]; ok {
+		n, err = uintArg(call, "n")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var filter columnSet
+	if len(call.Children) > 1 {
+		return nil, errors.Errorf("TopN expects at most 1 bitmap argument, got %v", len(call.Children))
+	} else if len(call.Children) == 1 {
+		filter, err = m.bitmap(call.Children[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make([]RowCount, 0, len(f.rows))
+	for row, cols := range f.rows {
+		count := uint64(len(cols))
+		if filter != nil {
+			count = 0
+			for col := range cols {
+				if _, ok := filter[col]; ok {
+					count++
+				}
+			}
+		}
+		if count > 0 {
+			counts = append(counts, RowCount{ID: row, Count: count})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].ID < counts[j].ID
+	})
+	if n > 0 && uint64(len(counts)) > n {
+		counts = counts[:n]
+	}
+	return counts, nil
+}
+
+// frame returns the frame named by call's "frame" argument. m.mu must be held.
+func (m *MemIndex) frame(call *pql.Call) (*memFrame, error) {
+	name, ok := call.Args["frame"].(string)
+	if !ok {
+		return nil, errors.Errorf("%v requires a frame argument", call.Name)
+	}
+	f, ok := m.frames[name]
+	if !ok {
+		return nil, errors.Errorf("unknown frame in %v: %v", call.Name, name)
+	}
+	return f, nil
+}
+
+// uintArg returns an integer argument of call, which the PQL parser may
+// produce as any of several numeric types.
+func uintArg(call *pql.Call, name string) (uint64, error) {
+	switch v := call.Args[name].(type) {
+	case uint64:
+		return v, nil
+	case int64:
+		if v >= 0 {
+			return uint64(v), nil
+		}
+	case int:
+		if v >= 0 {
+			return uint64(v), nil
+		}
+	case float64:
+		if v >= 0 && v == float64(uint64(v)) {
+			return uint64(v), nil
+		}
+	case nil:
+		return 0, errors.Errorf("%v requires a %v argument", call.Name, name)
+	}
+	return 0, errors.Errorf("invalid %v argument to %v: %v", name, call.Name, call.Args[name])
+}
@@ -0,0 +1,109 @@
+package pdk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pilosa/pilosa/pql"
+)
+
+func bitmapCall(frame string, row uint64) *pql.Call {
+	return &pql.Call{Name: "Bitmap", Args: map[string]interface{}{"frame": frame, "rowID": int64(row)}}
+}
+
+func TestMemIndex(t *testing.T) {
+	m := NewMemIndex([]FrameSpec{
+		NewRankedFrameSpec("color", 100),
+		NewRankedFrameSpec("size", 100),
+		NewFieldFrameSpec("price", 0, 1000),
+	})
+	bits := []struct {
+		frame    string
+		row, col uint64
+	}{
+		{"color", 1, 1}, {"color", 1, 2}, {"color", 1, 3}, {"color", 2, 4}, {"color", 2, 5}, {"color", 3, 6},
+		{"size", 7, 2}, {"size", 7, 4}, {"size", 8, 3},
+	}
+	for _, b := range bits {
+		if err := m.AddBit(b.frame, b.col, b.row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.AddBitTimestamp("color", 7, 3, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddBit("color", 8, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValue("price", "price", 2, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddBit("nope", 1, 1); err == nil {
+		t.Error("expected error adding bit to unknown frame")
+	}
+	if err := m.AddValue("color", "color", 1, 1); err == nil {
+		t.Error("expected error adding value to unknown field")
+	}
+	if err := m.AddRowAttrs("color", 1, map[string]interface{}{"name": "red", "hex": "f00"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRowAttrs("color", 1, map[string]interface{}{"hex": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddColumnAttrs(2, map[string]interface{}{"sku": int64(7)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRowAttrs("nope", 1, map[string]interface{}{"a": 1}); err == nil {
+		t.Error("expected error adding attrs to unknown frame")
+	}
+	if attrs := m.RowAttrs("color", 1); !reflect.DeepEqual(attrs, map[string]interface{}{"name": "red"}) {
+		t.Errorf("unexpected row attrs: %v", attrs)
+	}
+	if attrs := m.ColumnAttrs(2); !reflect.DeepEqual(attrs, map[string]interface{}{"sku": int64(7)}) {
+		t.Errorf("unexpected column attrs: %v", attrs)
+	}
+	if val, ok := m.Value("price", "price", 2); !ok || val != 42 {
+		t.Errorf("expected price 42, got %v, %v", val, ok)
+	}
+	if row := m.Row("color", 2); !reflect.DeepEqual(row, []uint64{4, 5, 8}) {
+		t.Errorf("unexpected row: %v", row)
+	}
+
+	tests := []struct {
+		call *pql.Call
+		exp  interface{}
+	}{
+		{bitmapCall("color", 1), []uint64{1, 2, 3}},
+		{&pql.Call{Name: "Intersect", Children: []*pql.Call{bitmapCall("color", 1), bitmapCall("size", 7)}}, []uint64{2}},
+		{&pql.Call{Name: "Union", Children: []*pql.Call{bitmapCall("color", 1), bitmapCall("size", 7)}}, []uint64{1, 2, 3, 4}},
+		{&pql.Call{Name: "Difference", Children: []*pql.Call{bitmapCall("color", 1), bitmapCall("size", 7)}}, []uint64{1, 3}},
+		{&pql.Call{Name: "Count", Children: []*pql.Call{bitmapCall("color", 2)}}, uint64(3)},
+		{&pql.Call{Name: "TopN", Args: map[string]interface{}{"frame": "color", "n": int64(2)}},
+			[]RowCount{{ID: 1, Count: 3}, {ID: 2, Count: 3}}},
+		{&pql.Call{Name: "TopN", Args: map[string]interface{}{"frame": "color"}, Children: []*pql.Call{bitmapCall("size", 7)}},
+			[]RowCount{{ID: 1, Count: 1}, {ID: 2, Count: 1}}},
+	}
+	for i, test := range tests {
+		res, err := m.Execute(test.call)
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, test.exp) {
+			t.Errorf("test %d: expected %v, got %v", i, test.exp, res)
+		}
+	}
+
+	for _, call := range []*pql.Call{
+		bitmapCall("nope", 1),
+		{Name: "Bitmap", Args: map[string]interface{}{"frame": "color"}},
+		{Name: "Count"},
+		{Name: "Range", Args: map[string]interface{}{"frame": "color"}},
+	} {
+		if _, err := m.Execute(call); err == nil {
+			t.Errorf("expected error executing %v", call.Name)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package pdk
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is the process wide registry of import metrics. Index and
+// ImportClient record per-frame counters here, and use cases may register
+// their own counters. It is published to expvar (under "pdk_imports" and
+// "pdk_counters"), and rendered in the Prometheus text format by
+// MetricsHandler.
+var Stats = NewStatsRegistry()
+
+func init() {
+	expvar.Publish("pdk_imports", expvar.Func(func() interface{} { return Stats.Snapshot() }))
+	expvar.Publish("pdk_counters", expvar.Func(func() interface{} { return Stats.counterValues() }))
+}
+
+// FrameStats holds import counters for a single frame, or a single BSI field
+// within a frame.
+type FrameStats struct {
+	Index string
+	Frame string
+	Field string
+
+	enqueued   uint64
+	cleared    uint64
+	sent       uint64
+	errors     uint64
+	retries    uint64
+	spooled    uint64
+	batches    uint64
+	batchNanos uint64
+
+	qmu   sync.Mutex
+	queue func() (length, capacity int)
+}
+
+// FrameStatsSnapshot is a point in time copy of a FrameStats.
+type FrameStatsSnapshot struct {
+	Enqueued      uint64  `json:"enqueued"`
+	Cleared       uint64  `json:"cleared"`
+	Sent          uint64  `json:"sent"`
+	Errors        uint64  `json:"errors"`
+	Retries       uint64  `json:"retries"`
+	Spooled       uint64  `json:"spooled"`
+	Batches       uint64  `json:"batches"`
+	BatchSeconds  float64 `json:"batch_seconds"`
+	QueueLength   int     `json:"queue_length"`
+	QueueCapacity int     `json:"queue_capacity"`
+}
+
+func (fs *FrameStats) addEnqueued()       { atomic.AddUint64(&fs.enqueued, 1) }
+func (fs *FrameStats) addCleared()        { atomic.AddUint64(&fs.cleared, 1) }
+func (fs *FrameStats) addError()          { atomic.AddUint64(&fs.errors, 1) }
+func (fs *FrameStats) addRetry()          { atomic.AddUint64(&fs.retries, 1) }
+func (fs *FrameStats) addSpooled(n int)   { atomic.AddUint64(&fs.spooled, uint64(n)) }
+func (fs *FrameStats) addUnspooled(n int) { atomic.AddUint64(&fs.spooled, ^uint64(n-1)) }
+func (fs *FrameStats) setSpooled(n int)   { atomic.StoreUint64(&fs.spooled, uint64(n)) }
+
+// addBatch records a batch of n bits or values which was sent successfully.
+func (fs *FrameStats) addBatch(n int, latency time.Duration) {
+	atomic.AddUint64(&fs.sent, uint64(n))
+	atomic.AddUint64(&fs.batches, 1)
+	atomic.AddUint64(&fs.batchNanos, uint64(latency))
+}
+
+// setQueue sets the function used to report the fill of the channel feeding
+// this frame's import.
+func (fs *FrameStats) setQueue(queue func() (length, capacity int)) {
+	fs.qmu.Lock()
+	fs.queue = queue
+	fs.qmu.Unlock()
+}
+
+// Snapshot returns the current values of fs's counters.
+func (fs *FrameStats) Snapshot() FrameStatsSnapshot {
+	snap := FrameStatsSnapshot{
+		Enqueued:     atomic.LoadUint64(&fs.enqueued),
+		Cleared:      atomic.LoadUint64(&fs.cleared),
+		Sent:         atomic.LoadUint64(&fs.sent),
+		Errors:       atomic.LoadUint64(&fs.errors),
+		Retries:      atomic.LoadUint64(&fs.retries),
+		Spooled:      atomic.LoadUint64(&fs.spooled),
+		Batches:      atomic.LoadUint64(&fs.batches),
+		BatchSeconds: time.Duration(atomic.LoadUint64(&fs.batchNanos)).Seconds(),
+	}
+	fs.qmu.Lock()
+	if fs.queue != nil {
+		snap.QueueLength, snap.QueueCapacity = fs.queue()
+	}
+	fs.qmu.Unlock()
+	return snap
+}
+
+func (fs *FrameStats) key() string {
+	key := fs.Index + "/" + fs.Frame
+	if fs.Field != "" {
+		key += "/" + fs.Field
+	}
+	return key
+}
+
+type counter struct {
+	typ  string
+	help string
+	fn   func() int64
+}
+
+// StatsRegistry holds FrameStats and custom counters.
+type StatsRegistry struct {
+	mu       sync.RWMutex
+	frames   map[string]*FrameStats
+	counters map[string]counter
+}
+
+// NewStatsRegistry creates an empty StatsRegistry. Most code should use the
+// global Stats.
+func NewStatsRegistry() *StatsRegistry {
+	return &StatsRegistry{
+		frames:   make(map[string]*FrameStats),
+		counters: make(map[string]counter),
+	}
+}
+
+// Frame returns the FrameStats for the given index, frame, and field (which
+// is empty for bits), creating it if necessary.
+func (s *StatsRegistry) Frame(index, frame, field string) *FrameStats {
+	fs := &FrameStats{Index: index, Frame: frame, Field: field}
+	key := fs.key()
+	s.mu.RLock()
+	existing, ok := s.frames[key]
+	s.mu.RUnlock()
+	if ok {
+		return existing
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.frames[key]; ok {
+		return existing
+	}
+	s.frames[key] = fs
+	return fs
+}
+
+// RegisterCounter adds a named counter which is read by calling fn whenever
+// metrics are requested. name should be a valid Prometheus metric name.
+func (s *StatsRegistry) RegisterCounter(name, help string, fn func() int64) {
+	s.mu.Lock()
+	s.counters[name] = counter{typ: "counter", help: help, fn: fn}
+	s.mu.Unlock()
+}
+
+// RegisterGauge is like RegisterCounter, but for a value which may go down.
+func (s *StatsRegistry) RegisterGauge(name, help string, fn func() int64) {
+	s.mu.Lock()
+	s.counters[name] = counter{typ: "gauge", help: help, fn: fn}
+	s.mu.Unlock()
+}
+
+// Snapshot returns the current stats of every frame keyed by
+// "index/frame[/field]".
+func (s *StatsRegistry) Snapshot() map[string]FrameStatsSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snaps := make(map[string]FrameStatsSnapshot, len(s.frames))
+	for key, fs := range s.frames {
+		snaps[key] = fs.Snapshot()
+	}
+	return snaps
+}
+
+func (s *StatsRegistry) counterValues() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vals := make(map[string]int64, len(s.counters))
+	for name, c := range s.counters {
+		vals[name] = c.fn()
+	}
+	return vals
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition
+// format.
+func (s *StatsRegistry) WritePrometheus(w io.Writer) error {
+	s.mu.RLock()
+	frames := make([]*FrameStats, 0, len(s.frames))
+	for _, fs := range s.frames {
+		frames = append(frames, fs)
+	}
+	counterNames := make([]string, 0, len(s.counters))
+	counters := make(map[string]counter, len(s.counters))
+	for name, c := range s.counters {
+		counterNames = append(counterNames, name)
+		counters[name] = c
+	}
+	s.mu.RUnlock()
+	sort.Slice(frames, func(i, j int) bool { return frames[i].key() < frames[j].key() })
+	sort.Strings(counterNames)
+
+	snaps := make([]FrameStatsSnapshot, len(frames))
+	for i, fs := range frames {
+		snaps[i] = fs.Snapshot()
+	}
+	metrics := []struct {
+		name string
+		typ  string
+		help string
+		val  func(FrameStatsSnapshot) float64
+	}{
+		{"pdk_import_enqueued_total", "counter", "Bits or values handed to the importer.", func(s FrameStatsSnapshot) float64 { return float64(s.Enqueued) }},
+		{"pdk_import_cleared_total", "counter", "Bits handed to the importer to be cleared.", func(s FrameStatsSnapshot) float64 { return float64(s.Cleared) }},
+		{"pdk_import_sent_total", "counter", "Bits or values sent to Pilosa.", func(s FrameStatsSnapshot) float64 { return float64(s.Sent) }},
+		{"pdk_import_errors_total", "counter", "Batches which failed to import after retrying.", func(s FrameStatsSnapshot) float64 { return float64(s.Errors) }},
+		{"pdk_import_retries_total", "counter", "Retried batch imports.", func(s FrameStatsSnapshot) float64 { return float64(s.Retries) }},
+		{"pdk_import_spooled", "gauge", "Bits or values waiting in the spool directory.", func(s FrameStatsSnapshot) float64 { return float64(s.Spooled) }},
+		{"pdk_import_batches_total", "counter", "Batches flushed to Pilosa.", func(s FrameStatsSnapshot) float64 { return float64(s.Batches) }},
+		{"pdk_import_batch_seconds_total", "counter", "Total time spent flushing batches to Pilosa.", func(s FrameStatsSnapshot) float64 { return s.BatchSeconds }},
+		{"pdk_import_queue_length", "gauge", "Bits or values waiting in the import queue.", func(s FrameStatsSnapshot) float64 { return float64(s.QueueLength) }},
+		{"pdk_import_queue_capacity", "gauge", "Capacity of the import queue.", func(s FrameStatsSnapshot) float64 { return float64(s.QueueCapacity) }},
+	}
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		if err != nil {
+			return err
+		}
+		for i, fs := range frames {
+			_, err := fmt.Fprintf(w, "%s{index=%s,frame=%s,field=%s} %v\n", m.name, promQuote(fs.Index), promQuote(fs.Frame), promQuote(fs.Field), m.val(snaps[i]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range counterNames {
+		c := counters[name]
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, c.help, name, c.typ, name, c.fn())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func promQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// MetricsHandler serves the metrics in Stats in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		err := Stats.WritePrometheus(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+var registerMetricsOnce sync.Once
+
+// StartMetricsServer serves Prometheus metrics at /metrics on bind, along with
+// anything else registered on http.DefaultServeMux (such as expvar's
+// /debug/vars, and /debug/pprof if net/http/pprof is imported). This function
+// does not return unless there is a problem (like http.ListenAndServe).
+func StartMetricsServer(bind string) error {
+	registerMetricsOnce.Do(func() {
+		http.Handle("/metrics", MetricsHandler())
+	})
+	return http.ListenAndServe(bind, nil)
+}
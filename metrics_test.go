@@ -0,0 +1,50 @@
+package pdk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsRegistryWritePrometheus(t *testing.T) {
+	reg := NewStatsRegistry()
+	fs := reg.Frame("i", "f", "")
+	if reg.Frame("i", "f", "") != fs {
+		t.Fatal("expected Frame to return existing stats")
+	}
+	fs.addEnqueued()
+	fs.addEnqueued()
+	fs.addCleared()
+	fs.addError()
+	fs.addRetry()
+	fs.addSpooled(5)
+	fs.addUnspooled(2)
+	fs.addBatch(1, time.Second)
+	fs.setQueue(func() (int, int) { return 3, 10 })
+	reg.Frame("i", "g", "v").addEnqueued()
+	reg.RegisterCounter("records_total", "Records read.", func() int64 { return 7 })
+
+	buf := &bytes.Buffer{}
+	if err := reg.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{
+		`pdk_import_enqueued_total{index="i",frame="f",field=""} 2`,
+		`pdk_import_enqueued_total{index="i",frame="g",field="v"} 1`,
+		`pdk_import_cleared_total{index="i",frame="f",field=""} 1`,
+		`pdk_import_sent_total{index="i",frame="f",field=""} 1`,
+		`pdk_import_errors_total{index="i",frame="f",field=""} 1`,
+		`pdk_import_retries_total{index="i",frame="f",field=""} 1`,
+		`pdk_import_spooled{index="i",frame="f",field=""} 3`,
+		`pdk_import_batch_seconds_total{index="i",frame="f",field=""} 1`,
+		`pdk_import_queue_length{index="i",frame="f",field=""} 3`,
+		`# TYPE records_total counter`,
+		"records_total 7\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected output to contain '%v', got:\n%v", exp, out)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package pdk
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// clearTimestamp marks a bit on an import channel which is to be cleared
+// rather than set.
+const clearTimestamp = math.MinInt64
+
+// mutexTracker remembers which row each column was set to in the mutex
+// frames of an Indexer, so that the previous bit can be cleared when a column
+// is set to a different row. The rows are kept in memory unless the tracker is
+// opened with openMutexTracker, which keeps them in leveldb so that later
+// imports into the same index know about the columns set by earlier ones.
+type mutexTracker struct {
+	mu     sync.Mutex
+	frames map[string]bool
+	rows   mutexRows
+}
+
+// mutexRows stores the row of each column in the mutex frames.
+type mutexRows interface {
+	get(frame string, col uint64) (row uint64, ok bool, err error)
+	put(frame string, col, row uint64) error
+	del(frame string, col uint64) error
+	close() error
+}
+
+func newMutexTracker(frames []FrameSpec) *mutexTracker {
+	t := &mutexTracker{frames: make(map[string]bool), rows: make(memMutexRows)}
+	for _, frame := range frames {
+		if frame.Mutex {
+			t.frames[frame.Name] = true
+		}
+	}
+	return t
+}
+
+// openMutexTracker is newMutexTracker with the rows stored in a leveldb in
+// dir. No database is opened if none of frames are mutex frames.
+func openMutexTracker(frames []FrameSpec, dir string) (*mutexTracker, error) {
+	t := newMutexTracker(frames)
+	if len(t.frames) == 0 {
+		return t, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "making mutex directory")
+	}
+	db, err := leveldb.OpenFile(dir, &opt.Options{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening leveldb at %v", dir)
+	}
+	t.rows = levelMutexRows{db: db}
+	return t, nil
+}
+
+func (t *mutexTracker) isMutex(frame string) bool {
+	return t.frames[frame]
+}
+
+// set records that col is set to row in frame, and returns the row it was
+// previously set to. seen is false if col has not been set in frame before.
+func (t *mutexTracker) set(frame string, col, row uint64) (prev uint64, seen bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, seen, err = t.rows.get(frame, col)
+	if err != nil || (seen && prev == row) {
+		return prev, seen, err
+	}
+	return prev, seen, t.rows.put(frame, col, row)
+}
+
+// clear forgets that col is set to row in frame.
+func (t *mutexTracker) clear(frame string, col, row uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, seen, err := t.rows.get(frame, col)
+	if err != nil || !seen || prev != row {
+		return err
+	}
+	return t.rows.del(frame, col)
+}
+
+func (t *mutexTracker) close() error {
+	return t.rows.close()
+}
+
+// memMutexRows keeps every column set in the mutex frames, so its memory
+// grows with the number of columns imported (about 40 bytes each per frame).
+// Imports of many columns should set IndexerConfig.MutexDir instead.
+type memMutexRows map[string]map[uint64]uint64
+
+func (m memMutexRows) get(frame string, col uint64) (uint64, bool, error) {
+	row, ok := m[frame][col]
+	return row, ok, nil
+}
+
+func (m memMutexRows) put(frame string, col, row uint64) error {
+	if m[frame] == nil {
+		m[frame] = make(map[uint64]uint64)
+	}
+	m[frame][col] = row
+	return nil
+}
+
+func (m memMutexRows) del(frame string, col uint64) error {
+	delete(m[frame], col)
+	return nil
+}
+
+func (m memMutexRows) close() error { return nil }
+
+// levelMutexRows keys each row by the frame name, a zero byte (which frame
+// names can't contain), and the column in big endian.
+type levelMutexRows struct {
+	db *leveldb.DB
+}
+
+func mutexKey(frame string, col uint64) []byte {
+	key := make([]byte, len(frame)+9)
+	copy(key, frame)
+	binary.BigEndian.PutUint64(key[len(frame)+1:], col)
+	return key
+}
+
+func (l levelMutexRows) get(frame string, col uint64) (uint64, bool, error) {
+	val, err := l.db.Get(mutexKey(frame, col), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "getting mutex row")
+	}
+	return binary.BigEndian.Uint64(val), true, nil
+}
+
+func (l levelMutexRows) put(frame string, col, row uint64) error {
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, row)
+	return errors.Wrap(l.db.Put(mutexKey(frame, col), val, nil), "putting mutex row")
+}
+
+func (l levelMutexRows) del(frame string, col uint64) error {
+	return errors.Wrap(l.db.Delete(mutexKey(frame, col), nil), "deleting mutex row")
+}
+
+func (l levelMutexRows) close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,337 @@
+package pdk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pkg/errors"
+)
+
+func TestIndexMutex(t *testing.T) {
+	var mu sync.Mutex
+	ops := make(map[string][]string)
+	clearCalls := 0
+	idx := NewIndex()
+	idx.name = "mutextest"
+	idx.batchSize = 10
+	idx.specs = []FrameSpec{{Name: "f", Mutex: true}, {Name: "g"}}
+	idx.importBits = func(fram *pcli.Frame, it pcli.BitIterator) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for bit, err := it.NextBit(); err != io.EOF; bit, err = it.NextBit() {
+			ops[fram.Name()] = append(ops[fram.Name()], "set "+bitString(bit))
+		}
+		return nil
+	}
+	idx.clearBits = func(fram *pcli.Frame, bits []pcli.Bit) error {
+		mu.Lock()
+		defer mu.Unlock()
+		clearCalls++
+		for _, bit := range bits {
+			ops[fram.Name()] = append(ops[fram.Name()], "clear "+bitString(bit))
+		}
+		return nil
+	}
+	idx.retry = RetryPolicy{Attempts: 1}
+	for _, spec := range idx.specs {
+		idx.frames[spec.Name] = testFrame(t, spec.Name)
+	}
+	idx.startImports()
+
+	adds := []struct {
+		frame    string
+		col, row uint64
+	}{
+		{"f", 1, 1}, {"f", 2, 1}, {"f", 1, 1}, {"f", 1, 2}, {"f", 2, 3},
+	}
+	for _, a := range adds {
+		if err := idx.AddBit(a.frame, a.col, a.row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// the tracker survives Flush, so the bit set before it is cleared
+	if err := idx.AddBitTimestamp("f", 1, 4, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ClearBit("f", 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.AddBit("f", 2, 5); err != nil {
+		t.Fatal(err)
+	}
+	// a bit set again after being cleared isn't cleared
+	for _, clear := range []bool{false, true, false, true, true} {
+		var err error
+		if clear {
+			err = idx.ClearBit("g", 7, 7)
+		} else {
+			err = idx.AddBit("g", 7, 7)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.AddBit("g", 8, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ClearBit("nope", 1, 1); err == nil {
+		t.Error("expected error clearing bit in unknown frame")
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sets go before the clears which were queued with them
+	exp := map[string][]string{
+		"f": {
+			"set 1/1", "set 1/2", "set 1/1", "set 2/1", "set 3/2",
+			"clear 1/1", "clear 1/2",
+			"set 4/1", "set 5/2",
+			"clear 2/1", "clear 3/2",
+		},
+		"g": {"set 7/7", "set 7/7", "set 8/8", "clear 7/7"},
+	}
+	if !reflect.DeepEqual(ops, exp) {
+		t.Errorf("expected:\n%v\ngot:\n%v", exp, ops)
+	}
+	if clearCalls != 3 {
+		t.Errorf("expected clears to be sent in 3 batches, got %v", clearCalls)
+	}
+	// mutex clears are counted along with ClearBit calls
+	for frame, exp := range map[string]uint64{"f": 4, "g": 3} {
+		if cleared := idx.bitStats[frame].Snapshot().Cleared; cleared != exp {
+			t.Errorf("expected %v bits cleared in %v, got %v", exp, frame, cleared)
+		}
+	}
+}
+
+func testFrame(t *testing.T, name string) *pcli.Frame {
+	index, err := pcli.NewIndex("mutextest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fram, err := index.Frame(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fram
+}
+
+func TestIndexClearSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imp := &flakyImporter{}
+	var cleared []pcli.Bit
+	down := true
+	idx := NewIndex()
+	idx.name = "clearspooltest"
+	idx.batchSize = 10
+	idx.specs = []FrameSpec{{Name: "f"}}
+	idx.importBits = imp.importBits
+	idx.clearBits = func(fram *pcli.Frame, bits []pcli.Bit) error {
+		if down {
+			return errors.New("connection refused")
+		}
+		cleared = append(cleared, bits...)
+		return nil
+	}
+	idx.retry = RetryPolicy{Attempts: 1}
+	idx.spool = newSpool(dir)
+	idx.startImports()
+
+	if err := idx.ClearBit("f", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Flush(); err == nil {
+		t.Fatal("expected error from Flush with clears left in the spool")
+	}
+	down = false
+	if err := idx.AddBit("f", 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []pcli.Bit{{RowID: 1, ColumnID: 1, Timestamp: clearTimestamp}}; !reflect.DeepEqual(cleared, exp) {
+		t.Errorf("expected spooled clear %v to be replayed, got %v", exp, cleared)
+	}
+	if exp := []pcli.Bit{{RowID: 2, ColumnID: 2}}; !reflect.DeepEqual(imp.bits, exp) {
+		t.Errorf("expected %v, got %v", exp, imp.bits)
+	}
+}
+
+func TestLevelMutexTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := []FrameSpec{{Name: "f", Mutex: true}, {Name: "g"}}
+	tr, err := openMutexTracker(frames, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.isMutex("f") || tr.isMutex("g") {
+		t.Errorf("expected only f to be a mutex frame")
+	}
+	for col := uint64(1); col <= 3; col++ {
+		if _, seen, err := tr.set("f", col, col*10); err != nil || seen {
+			t.Fatalf("setting column %v: seen %v, %v", col, seen, err)
+		}
+	}
+	if err := tr.clear("f", 2, 20); err != nil {
+		t.Fatal(err)
+	}
+	// clearing a row the column isn't set to leaves it
+	if err := tr.clear("f", 3, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err = openMutexTracker(frames, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+	tests := []struct {
+		col  uint64
+		prev uint64
+		seen bool
+	}{
+		{1, 10, true},
+		{2, 0, false},
+		{3, 30, true},
+		{1, 11, true}, // set by the first test
+	}
+	for _, test := range tests {
+		prev, seen, err := tr.set("f", test.col, 11)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev != test.prev || seen != test.seen {
+			t.Errorf("column %v: expected %v, %v, got %v, %v", test.col, test.prev, test.seen, prev, seen)
+		}
+	}
+}
+
+func TestSetMutex(t *testing.T) {
+	frames := []FrameSpec{{Name: "f"}, {Name: "g"}, {Name: "h", Mutex: true}}
+	SetMutex(frames, BitMapper{Frame: "f", Mutex: true}, BitMapper{Frame: "g"}, BitMapper{Frame: "x", Mutex: true})
+	for i, exp := range []bool{true, false, true} {
+		if frames[i].Mutex != exp {
+			t.Errorf("frame %v: expected mutex %v", frames[i].Name, exp)
+		}
+	}
+}
+
+func bitString(bit pcli.Bit) string {
+	return fmt.Sprintf("%d/%d", bit.RowID, bit.ColumnID)
+}
+
+func TestMemIndexMutex(t *testing.T) {
+	m := NewMemIndex([]FrameSpec{{Name: "f", Mutex: true}, {Name: "g"}})
+	for _, frame := range []string{"f", "g"} {
+		if err := m.AddBit(frame, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddBit(frame, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ClearBit("g", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ClearBit("nope", 1, 1); err == nil {
+		t.Error("expected error clearing bit in unknown frame")
+	}
+	tests := []struct {
+		frame string
+		row   uint64
+		exp   []uint64
+	}{
+		{"f", 1, []uint64{}},
+		{"f", 2, []uint64{1}},
+		{"g", 1, []uint64{}},
+		{"g", 2, []uint64{1}},
+	}
+	for i, test := range tests {
+		res, err := m.Execute(bitmapCall(test.frame, test.row))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, test.exp) {
+			t.Errorf("test %d: expected %v, got %v", i, test.exp, res)
+		}
+	}
+}
+
+func TestFileIndexUpdates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fi, err := NewFileIndex(dir, "idx", []FrameSpec{{Name: "f", Mutex: true}, {Name: "g"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range []string{"f", "g"} {
+		if err := fi.AddBit(frame, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := fi.AddBit(frame, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fi.ClearBit("g", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.AddBitTimestamp("g", 2, 3, time.Date(2017, 3, 4, 5, 6, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fi.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"f/updates-0000.pql": `SetBit(frame="f", rowID=1, columnID=1)
+ClearBit(frame="f", rowID=1, columnID=1)
+SetBit(frame="f", rowID=2, columnID=1)
+`,
+		"g/bits-0000.csv": "1,1\n2,1\n",
+		"g/updates-0000.pql": `ClearBit(frame="g", rowID=1, columnID=1)
+SetBit(frame="g", rowID=3, columnID=2, timestamp="2017-03-04T05:06")
+`,
+	}
+	for path, exp := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != exp {
+			t.Errorf("%v: expected:\n%s\ngot:\n%s", path, exp, data)
+		}
+	}
+	if kind := fi.Manifest().Files[0].Kind; kind != ExportUpdates {
+		t.Errorf("expected first file to be %v, got %v", ExportUpdates, kind)
+	}
+}
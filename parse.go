@@ -1,6 +1,7 @@
 package pdk
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -63,6 +64,25 @@ type BitMapper struct {
 	Mapper  Mapper
 	Parsers []Parser
 	Fields  []int
+	// Mutex means that each record maps to at most one row of Frame, which
+	// replaces the row the record's column was set to before. It takes
+	// effect through the frame's FrameSpec (see SetMutex).
+	Mutex bool
+}
+
+// SetMutex sets Mutex on the frames which any of bms with Mutex set map to.
+func SetMutex(frames []FrameSpec, bms ...BitMapper) {
+	mutex := make(map[string]bool)
+	for _, bm := range bms {
+		if bm.Mutex {
+			mutex[bm.Frame] = true
+		}
+	}
+	for i := range frames {
+		if mutex[frames[i].Name] {
+			frames[i].Mutex = true
+		}
+	}
 }
 
 // AttrMapper is a struct for mapping some set of data fields to a
@@ -72,3 +92,68 @@ type AttrMapper struct {
 	Parsers []Parser
 	Fields  []int
 }
+
+// RowIDs parses the fields of a record selected by bm.Fields and maps them to
+// row IDs.
+func (bm BitMapper) RowIDs(fields []string) ([]int64, error) {
+	if len(bm.Fields) != len(bm.Parsers) {
+		return nil, fmt.Errorf("BitMapper for frame %v has different number of fields: %v and parsers: %v", bm.Frame, bm.Fields, bm.Parsers)
+	}
+	parsed := make([]interface{}, len(bm.Fields))
+	for n, fieldnum := range bm.Fields {
+		if fieldnum >= len(fields) {
+			return nil, fmt.Errorf("field index: %v out of range for: %v", fieldnum, fields)
+		}
+		val, err := bm.Parsers[n].Parse(fields[fieldnum])
+		if err != nil {
+			return nil, fmt.Errorf("parsing field: %v, err: %v", fields[fieldnum], err)
+		}
+		parsed[n] = val
+	}
+	return bm.Mapper.ID(parsed...)
+}
+
+// MultiRowIDs is like RowIDs, but each field may have any number of values
+// (as produced by a JSONSource). Every combination of values is mapped, and
+// the union of the resulting row IDs is returned. If any field has no values,
+// no row IDs are returned.
+func (bm BitMapper) MultiRowIDs(values [][]string) ([]int64, error) {
+	if len(bm.Fields) != len(bm.Parsers) {
+		return nil, fmt.Errorf("BitMapper for frame %v has different number of fields: %v and parsers: %v", bm.Frame, bm.Fields, bm.Parsers)
+	}
+	combos := [][]string{{}}
+	for _, fieldnum := range bm.Fields {
+		if fieldnum >= len(values) {
+			return nil, fmt.Errorf("field index: %v out of range for: %v", fieldnum, values)
+		}
+		next := make([][]string, 0, len(combos)*len(values[fieldnum]))
+		for _, combo := range combos {
+			for _, val := range values[fieldnum] {
+				next = append(next, append(combo[:len(combo):len(combo)], val))
+			}
+		}
+		combos = next
+	}
+
+	// each combo holds one value per field, in order, so remap Fields to
+	// index into it directly.
+	direct := BitMapper{Frame: bm.Frame, Mapper: bm.Mapper, Parsers: bm.Parsers, Fields: make([]int, len(bm.Fields)), Mutex: bm.Mutex}
+	for i := range direct.Fields {
+		direct.Fields[i] = i
+	}
+	seen := make(map[int64]struct{})
+	rowIDs := make([]int64, 0, len(combos))
+	for _, combo := range combos {
+		ids, err := direct.RowIDs(combo)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				rowIDs = append(rowIDs, id)
+			}
+		}
+	}
+	return rowIDs, nil
+}
@@ -2,52 +2,203 @@ package pdk
 
 import (
 	"io"
-	"log"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	pcli "github.com/pilosa/go-pilosa"
 	"github.com/pkg/errors"
 )
 
+// Indexer is the interface through which mapped data is sent to Pilosa (or
+// somewhere else). It is implemented by Index, which imports into Pilosa
+// using either go-pilosa or ctl.ImportCommand, and by MemIndex and FileIndex.
+// Use NewIndexer to create one by backend name.
 type Indexer interface {
-	AddBit(frame string, col uint64, row uint64)
-	AddValue(frame string, col uint64, val uint64)
+	// AddBit sets a bit in frame. It returns an error if the frame is
+	// unknown.
+	AddBit(frame string, col uint64, row uint64) error
+	// AddBitTimestamp sets a bit in frame with a timestamp, so that it is
+	// included in Range queries over the frame's time quantum.
+	AddBitTimestamp(frame string, col uint64, row uint64, ts time.Time) error
+	// ClearBit clears a bit in frame. It is ordered with the bits added to
+	// the same frame, so a bit which is set and then cleared ends up clear.
+	ClearBit(frame string, col uint64, row uint64) error
+	// AddValue sets the value of a BSI field in frame. It returns an error if
+	// the frame or field is unknown.
+	AddValue(frame, field string, col uint64, val uint64) error
+	// AddRowAttrs sets attributes on a row of frame. Attributes with a nil
+	// value are removed.
+	AddRowAttrs(frame string, row uint64, attrs map[string]interface{}) error
+	// AddColumnAttrs sets attributes on a column. Attributes with a nil value
+	// are removed.
+	AddColumnAttrs(col uint64, attrs map[string]interface{}) error
+	// Flush blocks until everything added so far has been imported, and
+	// returns any import errors which have occurred since the last Flush.
+	Flush() error
+	// Close flushes and stops the Indexer. It returns any import errors
+	// which have occurred since the last Flush.
 	Close() error
 }
 
+// Index is an Indexer which imports into Pilosa. Bits are imported in
+// batches by importBits, which uses go-pilosa's ImportFrame for an Index
+// created by SetupPilosa, and ctl.ImportCommand for one created by
+// NewImportClient. Values are always imported with go-pilosa, and attributes
+// are set with SetRowAttrs and SetColumnAttrs queries. Failed batches are
+// retried according to retry, and then written to spool if it is set.
 type Index struct {
-	client *pcli.Client
+	client       *pcli.Client
+	name         string
+	batchSize    uint
+	maxBuffered  uint
+	index        *pcli.Index
+	frames       map[string]*pcli.Frame
+	specs        []FrameSpec
+	importBits   func(frame *pcli.Frame, it pcli.BitIterator) error
+	importValues func(frame *pcli.Frame, field string, it pcli.ValueIterator) error
+	clearBits    func(frame *pcli.Frame, bits []pcli.Bit) error
+	retry        RetryPolicy
+	spool        *spool
+	mutex        *mutexTracker
+	fragments    *fragmentBuilder
+	// throttle limits imports, and fragmentThrottle limits sending fragments
+	// instead for the roaring backend, whose imports are local.
+	throttle         *throttle
+	fragmentThrottle *throttle
 
+	// lock is held for reading while sending on the import channels, and for
+	// writing while they are being closed or replaced.
+	lock       sync.RWMutex
 	bitChans   map[string]ChanBitIterator
 	fieldChans map[string]map[string]ChanValIterator
+	bitStats   map[string]*FrameStats
+	fieldStats map[string]map[string]*FrameStats
+
+	wg    sync.WaitGroup
+	errMu sync.Mutex
+	errs  Errors
 }
 
 func NewIndex() *Index {
 	return &Index{
+		frames:     make(map[string]*pcli.Frame),
 		bitChans:   make(map[string]ChanBitIterator),
 		fieldChans: make(map[string]map[string]ChanValIterator),
+		bitStats:   make(map[string]*FrameStats),
+		fieldStats: make(map[string]map[string]*FrameStats),
 	}
 }
 
-func (i *Index) AddBit(frame string, col uint64, row uint64) {
-	var c ChanBitIterator
-	var ok bool
-	if c, ok = i.bitChans[frame]; !ok {
-		log.Printf("Unknown frame in AddBit: %v", frame)
+func (i *Index) AddBit(frame string, col uint64, row uint64) error {
+	return i.addBit(frame, pcli.Bit{RowID: row, ColumnID: col}, "AddBit")
+}
+
+func (i *Index) AddBitTimestamp(frame string, col uint64, row uint64, ts time.Time) error {
+	return i.addBit(frame, pcli.Bit{RowID: row, ColumnID: col, Timestamp: ts.UnixNano()}, "AddBitTimestamp")
+}
+
+// addBit queues bit for frame. If frame is a mutex frame and the column was
+// set to another row earlier in the import, that bit is cleared first.
+func (i *Index) addBit(frame string, bit pcli.Bit, caller string) error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	c, ok := i.bitChans[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in %v: %v", caller, frame)
+	}
+	if i.mutex.isMutex(frame) {
+		prev, seen, err := i.mutex.set(frame, bit.ColumnID, bit.RowID)
+		if err != nil {
+			return errors.Wrapf(err, "tracking mutex frame %v", frame)
+		}
+		if seen && prev != bit.RowID {
+			c <- pcli.Bit{RowID: prev, ColumnID: bit.ColumnID, Timestamp: clearTimestamp}
+			i.bitStats[frame].addCleared()
+		}
+	}
+	c <- bit
+	i.bitStats[frame].addEnqueued()
+	return nil
+}
+
+// ClearBit queues a bit to be cleared in frame. Clears are sent in batches
+// (see runBitImport), after the bits queued for the frame before them.
+func (i *Index) ClearBit(frame string, col uint64, row uint64) error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	c, ok := i.bitChans[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in ClearBit: %v", frame)
 	}
-	c <- pcli.Bit{RowID: row, ColumnID: col}
+	if i.mutex.isMutex(frame) {
+		if err := i.mutex.clear(frame, col, row); err != nil {
+			return errors.Wrapf(err, "tracking mutex frame %v", frame)
+		}
+	}
+	c <- pcli.Bit{RowID: row, ColumnID: col, Timestamp: clearTimestamp}
+	i.bitStats[frame].addCleared()
+	return nil
 }
 
-func (i *Index) AddValue(frame string, col uint64, val uint64) {
-	var c ChanValIterator
-	var ok bool
-	if c, ok = i.fieldChans[frame][frame]; !ok { // TODO should really have field name
-		log.Printf("Unknown frame in AddValue: %v", frame)
+func (i *Index) AddValue(frame, field string, col uint64, val uint64) error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	fields, ok := i.fieldChans[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddValue: %v", frame)
+	}
+	c, ok := fields[field]
+	if !ok {
+		return errors.Errorf("unknown field in AddValue: %v, frame: %v", field, frame)
 	}
 	c <- pcli.FieldValue{ColumnID: col, Value: val}
+	i.fieldStats[frame][field].addEnqueued()
+	return nil
+}
+
+func (i *Index) AddRowAttrs(frame string, row uint64, attrs map[string]interface{}) error {
+	fram, ok := i.frames[frame]
+	if !ok {
+		return errors.Errorf("unknown frame in AddRowAttrs: %v", frame)
+	}
+	_, err := i.client.Query(fram.SetRowAttrs(row, attrs), nil)
+	return errors.Wrapf(err, "setting attrs on row %v of frame %v", row, frame)
+}
+
+func (i *Index) AddColumnAttrs(col uint64, attrs map[string]interface{}) error {
+	_, err := i.client.Query(i.index.SetColumnAttrs(col, attrs), nil)
+	return errors.Wrapf(err, "setting attrs on column %v", col)
+}
+
+// Flush closes the import channels so that the imports send their final
+// batches, waits for them to finish, and then starts a fresh set of imports.
+func (i *Index) Flush() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	err := i.closeAndWait(false)
+	i.startImports()
+	return err
 }
 
 func (i *Index) Close() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	err := i.closeAndWait(true)
+	if i.mutex != nil {
+		if cerr := i.mutex.close(); err == nil && cerr != nil {
+			err = errors.Wrap(cerr, "closing mutex tracker")
+		}
+	}
+	return err
+}
+
+// closeAndWait closes all import channels, waits for the import goroutines to
+// exit, and returns (and clears) any errors they encountered. For the roaring
+// backend it sends the fragments of finished slices, or every fragment if
+// final is set (see fragmentBuilder.send). i.lock must be held for writing.
+func (i *Index) closeAndWait(final bool) error {
 	for _, cbi := range i.bitChans {
 		close(cbi)
 	}
@@ -56,21 +207,90 @@ func (i *Index) Close() error {
 			close(cvi)
 		}
 	}
+	i.wg.Wait()
+	if i.fragments != nil {
+		if err := i.fragments.send(i.retry, i.fragmentThrottle, final); err != nil {
+			i.addErr(err)
+		}
+	}
+	i.bitChans = make(map[string]ChanBitIterator)
+	i.fieldChans = make(map[string]map[string]ChanValIterator)
+
+	i.errMu.Lock()
+	defer i.errMu.Unlock()
+	errs := i.errs
+	i.errs = nil
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
+func (i *Index) addErr(err error) {
+	i.errMu.Lock()
+	i.errs = append(i.errs, err)
+	i.errMu.Unlock()
+}
+
+// startImports creates a channel for each frame and field, and starts a
+// goroutine importing from each one. i.lock must be held for writing, or not
+// yet shared.
+func (i *Index) startImports() {
+	if i.mutex == nil {
+		i.mutex = newMutexTracker(i.specs)
+	}
+	for _, frame := range i.specs {
+		fram := i.frames[frame.Name]
+		bits := NewChanBitIterator()
+		i.bitChans[frame.Name] = bits
+		i.fieldChans[frame.Name] = make(map[string]ChanValIterator)
+		i.fieldStats[frame.Name] = make(map[string]*FrameStats)
+
+		stats := Stats.Frame(i.name, frame.Name, "")
+		stats.setQueue(func() (int, int) { return len(bits), cap(bits) })
+		i.bitStats[frame.Name] = stats
+		i.wg.Add(1)
+		go func(fram *pcli.Frame, frame FrameSpec) {
+			defer i.wg.Done()
+			i.runBitImport(fram, frame.Name, bits, stats)
+		}(fram, frame)
+		for _, field := range frame.Fields {
+			vals := NewChanValIterator()
+			i.fieldChans[frame.Name][field.Name] = vals
+
+			stats := Stats.Frame(i.name, frame.Name, field.Name)
+			stats.setQueue(func() (int, int) { return len(vals), cap(vals) })
+			i.fieldStats[frame.Name][field.Name] = stats
+			i.wg.Add(1)
+			go func(fram *pcli.Frame, frame, field string) {
+				defer i.wg.Done()
+				i.runValueImport(fram, frame, field, vals, stats)
+			}(fram, frame.Name, field.Name)
+		}
+	}
+}
+
 type FrameSpec struct {
-	Name           string
-	CacheType      pcli.CacheType
-	CacheSize      uint
-	InverseEnabled bool
-	Fields         []FieldSpec
+	Name           string         `json:"name"`
+	CacheType      pcli.CacheType `json:"cache-type,omitempty"`
+	CacheSize      uint           `json:"cache-size,omitempty"`
+	InverseEnabled bool           `json:"inverse-enabled,omitempty"`
+	// TimeQuantum enables time views on the frame (e.g. "YMD") for bits which
+	// are added with a timestamp.
+	TimeQuantum pcli.TimeQuantum `json:"time-quantum,omitempty"`
+	Fields      []FieldSpec      `json:"fields,omitempty"`
+	// Mutex means that a column has at most one row set in the frame, e.g.
+	// for a category which can change when a record is updated. Setting a
+	// column's bit clears the bit which the indexer last set for it (see
+	// IndexerConfig.MutexDir). Pilosa doesn't store this option, so it only
+	// affects importing.
+	Mutex bool `json:"mutex,omitempty"`
 }
 
 type FieldSpec struct {
-	Name string
-	Min  int
-	Max  int
+	Name string `json:"name"`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
 }
 
 func NewRankedFrameSpec(name string, size int) FrameSpec {
@@ -82,8 +302,17 @@ func NewRankedFrameSpec(name string, size int) FrameSpec {
 	return fs
 }
 
+// NewTimeFrameSpec creates a ranked frame which supports Range queries over
+// time at the given quantum.
+func NewTimeFrameSpec(name string, size int, quantum pcli.TimeQuantum) FrameSpec {
+	fs := NewRankedFrameSpec(name, size)
+	fs.TimeQuantum = quantum
+	return fs
+}
+
 // NewFieldFrameSpec creates a frame which is dedicated to a single BSI field
-// which will have the same name as the frame
+// which will have the same name as the frame. Use NewFieldsFrameSpec to group
+// several fields in one frame.
 func NewFieldFrameSpec(name string, min int, max int) FrameSpec {
 	fs := FrameSpec{
 		Name:      name,
@@ -94,9 +323,194 @@ func NewFieldFrameSpec(name string, min int, max int) FrameSpec {
 	return fs
 }
 
+// NewFieldsFrameSpec creates a frame which holds several BSI fields.
+func NewFieldsFrameSpec(name string, fields ...FieldSpec) FrameSpec {
+	fs := FrameSpec{
+		Name:      name,
+		CacheType: pcli.CacheType(""),
+		CacheSize: 0,
+		Fields:    fields,
+	}
+	return fs
+}
+
+// Backends which may be passed to NewIndexer.
+const (
+	// BackendGoPilosa imports into Pilosa with go-pilosa. It is the default.
+	BackendGoPilosa = "go-pilosa"
+	// BackendCtl imports bits into Pilosa through ctl.ImportCommand.
+	BackendCtl = "ctl"
+	// BackendFile writes import files to IndexerConfig.OutputDir.
+	BackendFile = "file"
+	// BackendMemory keeps everything in memory (see MemIndex).
+	BackendMemory = "memory"
+	// BackendRoaring builds Pilosa's roaring fragments locally and sends
+	// whole fragments to Pilosa, which is much cheaper than importing bits
+	// for large loads. Sent fragments replace those in Pilosa, so NewIndexer
+	// refuses it for frames which already exist, and for IndexerConfig.Resume.
+	// Flush only sends the fragments of slices which are finished, and Close
+	// sends the rest. Values and attributes are imported as with go-pilosa.
+	BackendRoaring = "roaring"
+)
+
+// Backends lists the valid values of IndexerConfig.Backend.
+var Backends = []string{BackendGoPilosa, BackendCtl, BackendFile, BackendMemory, BackendRoaring}
+
+// IndexerConfig holds the options for NewIndexer. Only the options used by
+// the chosen backend need to be set.
+type IndexerConfig struct {
+	// Backend is one of Backends. If it is empty, BackendFile is used if
+	// OutputDir is set, and BackendGoPilosa otherwise.
+	Backend string
+	// Hosts are the Pilosa hosts to import into. The ctl backend only uses
+	// the first.
+	Hosts []string
+	// BatchSize is the number of bits or values sent to Pilosa at once.
+	// Defaults to 1000000.
+	BatchSize int
+	// MaxBuffered limits the number of bits or values the Pilosa backends
+	// buffer for each frame and field while grouping them into batches by
+	// slice. Defaults to BatchSize, which is no more than is held by sending
+	// them in the order they arrive.
+	MaxBuffered int
+	// OutputDir is where the file backend writes import files.
+	OutputDir string
+	// MaxFileSize is the size at which the file backend rotates files.
+	// Defaults to DefaultMaxExportFileSize.
+	MaxFileSize int64
+	// Retry controls how the Pilosa backends retry failed batches. Defaults
+	// to DefaultRetryPolicy.
+	Retry RetryPolicy
+	// SpoolDir is where the Pilosa backends write batches which still fail
+	// after retrying, to be replayed once Pilosa is back. Batches left over
+	// from a previous run are replayed too. If it is empty, failed batches
+	// are reported as errors from Flush and Close and dropped.
+	SpoolDir string
+	// Throttle limits how fast the Pilosa backends send to Pilosa.
+	Throttle ThrottleConfig
+	// Resume is set when continuing an interrupted import, which the roaring
+	// backend can't do without replacing the fragments it sent before. The
+	// file backend keeps the files listed in OutputDir's manifest (see
+	// OpenFileIndex).
+	Resume bool
+	// MutexDir is where the Pilosa and file backends keep the row each
+	// column is set to in the mutex frames, so that imports into the same
+	// index clear the rows set by earlier ones. If it is empty the rows are
+	// kept in memory, and only the bits set by this import are cleared.
+	MutexDir string
+}
+
+// NewIndexer creates an Indexer for schema using the configured backend. For
+// the Pilosa backends, the index and frames are created if they don't exist.
+func NewIndexer(conf IndexerConfig, schema Schema) (Indexer, error) {
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 1000000
+	}
+	if conf.MaxFileSize == 0 {
+		conf.MaxFileSize = DefaultMaxExportFileSize
+	}
+	backend := conf.Backend
+	if backend == "" {
+		backend = BackendGoPilosa
+		if conf.OutputDir != "" {
+			backend = BackendFile
+		}
+	}
+	switch backend {
+	case BackendGoPilosa, BackendCtl, BackendRoaring:
+		if len(conf.Hosts) == 0 {
+			return nil, errors.Errorf("no pilosa hosts given for backend %v", backend)
+		}
+		if backend == BackendRoaring && conf.Resume {
+			return nil, errors.New("the roaring backend can't resume an import, since it would replace the fragments sent before")
+		}
+		indexer, err := newIndex(conf.Hosts, schema, uint(conf.BatchSize), backend == BackendRoaring)
+		if err != nil {
+			return nil, err
+		}
+		switch backend {
+		case BackendCtl:
+			host, bufsize := conf.Hosts[0], conf.BatchSize
+			indexer.importBits = func(fram *pcli.Frame, it pcli.BitIterator) error {
+				return ctlImport(host, schema.Index, fram.Name(), bufsize, it)
+			}
+		case BackendRoaring:
+			fb := newFragmentBuilder(conf.Hosts, schema, func(fram *pcli.Frame, it pcli.BitIterator) error {
+				return indexer.client.ImportFrame(fram, it, indexer.batchSize)
+			})
+			indexer.fragments = fb
+			indexer.importBits = fb.importBits
+			clearBits := indexer.clearBits
+			indexer.clearBits = func(fram *pcli.Frame, bits []pcli.Bit) error {
+				for _, bit := range bits {
+					fb.clearBit(fram, bit.ColumnID, bit.RowID)
+				}
+				return clearBits(fram, bits)
+			}
+		default:
+			indexer.importBits = func(fram *pcli.Frame, it pcli.BitIterator) error {
+				return indexer.client.ImportFrame(fram, it, indexer.batchSize)
+			}
+		}
+		if backend == BackendRoaring {
+			indexer.fragmentThrottle = newThrottle(conf.Throttle)
+		} else {
+			indexer.throttle = newThrottle(conf.Throttle)
+		}
+		indexer.maxBuffered = uint(conf.MaxBuffered)
+		indexer.retry = conf.Retry
+		if indexer.retry == (RetryPolicy{}) {
+			indexer.retry = DefaultRetryPolicy
+		}
+		if conf.SpoolDir != "" {
+			indexer.spool = newSpool(filepath.Join(conf.SpoolDir, schema.Index))
+		}
+		if conf.MutexDir != "" {
+			indexer.mutex, err = openMutexTracker(schema.Frames, conf.MutexDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		indexer.startImports()
+		return indexer, nil
+	case BackendFile:
+		if conf.OutputDir == "" {
+			return nil, errors.New("no output directory given for file backend")
+		}
+		open := NewFileIndex
+		if conf.Resume {
+			open = OpenFileIndex
+		}
+		fi, err := open(conf.OutputDir, schema.Index, schema.Frames, conf.MaxFileSize)
+		if err != nil {
+			return nil, err
+		}
+		if conf.MutexDir != "" {
+			fi.mutex, err = openMutexTracker(schema.Frames, conf.MutexDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return fi, nil
+	case BackendMemory:
+		return NewMemIndex(schema.Frames), nil
+	}
+	return nil, errors.Errorf("unknown backend '%v', expected one of %v", backend, Backends)
+}
+
+// SetupPilosa creates the index and frames in Pilosa if necessary, and
+// returns an Indexer which imports into them with go-pilosa.
 func SetupPilosa(hosts []string, index string, frames []FrameSpec) (Indexer, error) {
-	var BATCHSIZE uint = 1000000
+	return NewIndexer(IndexerConfig{Backend: BackendGoPilosa, Hosts: hosts}, Schema{Index: index, Frames: frames})
+}
+
+// newIndex connects to Pilosa and ensures that the schema exists. If newFrames
+// is set, it is an error for any of the schema's frames to exist already. The
+// caller must set importBits and retry, and call startImports.
+func newIndex(hosts []string, schema Schema, batchSize uint, newFrames bool) (*Index, error) {
 	indexer := NewIndex()
+	indexer.batchSize = batchSize
+	indexer.name = schema.Index
 	client, err := pcli.NewClientFromAddresses(hosts,
 		&pcli.ClientOptions{SocketTimeout: time.Minute * 60,
 			ConnectTimeout: time.Second * 60,
@@ -105,53 +519,57 @@ func SetupPilosa(hosts []string, index string, frames []FrameSpec) (Indexer, err
 		return nil, errors.Wrap(err, "creating pilosa cluster client")
 	}
 	indexer.client = client
-
-	idx, err := pcli.NewIndex(index, &pcli.IndexOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "making index")
+	indexer.importValues = func(fram *pcli.Frame, field string, it pcli.ValueIterator) error {
+		return client.ImportValueFrame(fram, field, it, batchSize)
 	}
-	err = client.EnsureIndex(idx)
-	if err != nil {
-		return nil, errors.Wrap(err, "ensuring index existence")
-	}
-	for _, frame := range frames {
-		frameOptions := &pcli.FrameOptions{CacheType: frame.CacheType, CacheSize: frame.CacheSize}
-		for _, field := range frame.Fields {
-			err := frameOptions.AddIntField(field.Name, field.Min, field.Max)
-			if err != nil {
-				return nil, errors.Wrapf(err, "adding int field %v", field)
+	indexer.clearBits = func(fram *pcli.Frame, bits []pcli.Bit) error {
+		for len(bits) > 0 {
+			n := len(bits)
+			if n > maxClearsPerQuery {
+				n = maxClearsPerQuery
 			}
+			query := indexer.index.BatchQuery()
+			for _, bit := range bits[:n] {
+				query.Add(fram.ClearBit(bit.RowID, bit.ColumnID))
+			}
+			if _, err := client.Query(query, nil); err != nil {
+				return err
+			}
+			bits = bits[n:]
 		}
-		fram, err := idx.Frame(frame.Name, frameOptions)
-		if err != nil {
-			return nil, errors.Wrap(err, "making frame: %v")
-		}
-		err = client.EnsureFrame(fram)
+		return nil
+	}
+
+	if newFrames {
+		diff, err := schema.Diff(client)
 		if err != nil {
-			return nil, errors.Wrapf(err, "creating frame '%v'", frame)
+			return nil, err
 		}
-
-		indexer.fieldChans[frame.Name] = make(map[string]ChanValIterator)
-		indexer.bitChans[frame.Name] = NewChanBitIterator()
-		go func(fram *pcli.Frame, frame FrameSpec) {
-			err := client.ImportFrame(fram, indexer.bitChans[frame.Name], BATCHSIZE)
-			if err != nil {
-				log.Println(errors.Wrapf(err, "starting frame import for %v", frame.Name))
+		if len(diff.Frames) < len(schema.Frames) {
+			missing := make(map[string]bool, len(diff.Frames))
+			for _, frame := range diff.Frames {
+				missing[frame.Name] = true
 			}
-		}(fram, frame)
-		for _, field := range frame.Fields {
-			indexer.fieldChans[frame.Name][field.Name] = NewChanValIterator()
-			go func(fram *pcli.Frame, frame FrameSpec, field FieldSpec) {
-				err := client.ImportValueFrame(fram, field.Name, indexer.fieldChans[frame.Name][field.Name], BATCHSIZE)
-				if err != nil {
-					log.Println(errors.Wrapf(err, "starting field import for %v", field))
+			var existing []string
+			for _, frame := range schema.Frames {
+				if !missing[frame.Name] {
+					existing = append(existing, frame.Name)
 				}
-			}(fram, frame, field)
+			}
+			return nil, errors.Errorf("frames %v of index %v already exist, and may not be empty", strings.Join(existing, ", "), schema.Index)
 		}
 	}
+	indexer.index, indexer.frames, err = schema.Ensure(client)
+	if err != nil {
+		return nil, err
+	}
+	indexer.specs = schema.Frames
 	return indexer, nil
 }
 
+// NewChanBitIterator creates a buffered channel of bits which implements
+// go-pilosa's BitIterator. Bits with a non-zero Timestamp (in nanoseconds
+// since the epoch) are imported into the frame's time views.
 func NewChanBitIterator() ChanBitIterator {
 	return make(chan pcli.Bit, 200000)
 }
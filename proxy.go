@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pilosa/pilosa"
 	"github.com/pilosa/pilosa/pql"
+	"github.com/pkg/errors"
 )
 
 // Translator describes the functionality which the proxy server requires to
@@ -19,33 +24,210 @@ import (
 type Translator interface {
 	// Get must be safe for concurrent access
 	Get(frame string, id uint64) interface{}
+	// GetID returns the id of val, allocating a new one if val hasn't been
+	// seen before.
 	GetID(frame string, val interface{}) (uint64, error)
+	// LookupID returns the id of val without allocating one. ok is false if
+	// val hasn't been mapped.
+	LookupID(frame string, val interface{}) (id uint64, ok bool, err error)
+}
+
+// KeyRanger is implemented by Translators which can list the values mapped in
+// a frame. The proxy uses it to suggest close matches for unknown values.
+type KeyRanger interface {
+	// RangeKeys calls fn with each value mapped in frame, until fn returns
+	// false.
+	RangeKeys(frame string, fn func(key string) bool) error
+}
+
+// ValueLooker is implemented by Translators which can report that an id
+// hasn't been mapped, where Get would panic or return nil. The proxy uses it to
+// translate columns, which needn't all have keys.
+type ValueLooker interface {
+	// LookupValue returns the value which id was mapped from in frame. ok is
+	// false if id hasn't been mapped.
+	LookupValue(frame string, id uint64) (val interface{}, ok bool, err error)
+}
+
+// lookupValue returns the value which id was mapped from in frame, with
+// LookupValue if m is a ValueLooker. Otherwise a nil value from Get means that
+// id isn't mapped, and a panic in Get is returned as an error.
+func lookupValue(m Translator, frame string, id uint64) (val interface{}, ok bool, err error) {
+	if vl, isLooker := m.(ValueLooker); isLooker {
+		return vl.LookupValue(frame, id)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			val, ok, err = nil, false, fmt.Errorf("getting id %v in frame %v: %v", id, frame, r)
+		}
+	}()
+	val = m.Get(frame, id)
+	if b, isBytes := val.([]byte); val == nil || (isBytes && b == nil) {
+		return nil, false, nil
+	}
+	return val, true, nil
 }
 
 // StartMappingProxy listens for incoming http connections on `bind` and
-// forwards all requests to `pilosa`. It inspects pilosa responses and runs the
-// row ids through the Translator `m` to translate them to whatever they were
-// mapped from. This function does not return unless there is a problem (like
-// http.ListenAndServe).
-func StartMappingProxy(bind, pilosa string, m Translator) error {
-	if !strings.HasPrefix(pilosa, "http://") {
-		pilosa = "http://" + pilosa
-	}
-	handler := &pilosaForwarder{phost: pilosa, m: m}
+// forwards all requests to the pilosa `hosts`, spreading them over the hosts
+// which are up (see ProxyConfig). PQL queries (POST /index/{index}/query)
+// have their row keys translated to ids, and the row ids in pilosa's
+// responses are run through the Translator `m` to translate them to whatever
+// they were mapped from. All other requests, such as /schema, /status, index
+// and frame creation and imports, are passed through untouched, so the proxy
+// can be used in place of pilosa. This function does not return unless there
+// is a problem (like http.ListenAndServe).
+func StartMappingProxy(bind string, hosts []string, m Translator) error {
+	return StartProxy(ProxyConfig{Bind: bind, Hosts: hosts, Translator: m})
+}
+
+// StartColumnMappingProxy is StartMappingProxy for an index whose columns are
+// derived from record keys by key. Queries with keys=true in their URL get the
+// columns of bitmap results translated back to record keys, and with
+// attrs=true, the columns' attributes are merged in. A key is returned as a
+// string if key has one field, as an array of its fields if it has several,
+// and as null for a column with no key. Columns are returned a page at a time
+// (see offset and limit, and DefaultColumnLimit).
+func StartColumnMappingProxy(bind string, hosts []string, m Translator, key ColumnKey) error {
+	return StartProxy(ProxyConfig{Bind: bind, Hosts: hosts, Translator: m, ColumnKey: &key})
+}
+
+// ProxyConfig configures the mapping proxy started by StartProxy.
+type ProxyConfig struct {
+	// Bind is the address the proxy listens on.
+	Bind string
+	// Hosts are the addresses of the Pilosa hosts which requests are
+	// forwarded to. Each request goes to the next host which is up. Hosts
+	// are marked down when a request to them fails, and checked every
+	// HealthCheckInterval (DefaultHealthCheckInterval if 0) to find out when
+	// they are up again. Reads, and PQL queries which couldn't connect, are
+	// retried on another host. Other requests which are passed through
+	// aren't, as their bodies have been sent.
+	Hosts               []string
+	HealthCheckInterval time.Duration
+	Translator          Translator
+	// ColumnKey is how the index's columns were derived from record keys,
+	// if they were (see StartColumnMappingProxy). Its Translator defaults to
+	// Translator.
+	ColumnKey *ColumnKey
+	// Cache configures caching of query responses. It is disabled by
+	// default.
+	Cache QueryCacheConfig
+}
+
+// StartProxy starts a mapping proxy configured by conf (see
+// StartMappingProxy). If the cache is enabled, its counters are added to
+// Stats, and served as JSON at /proxy/cache. This function does not return
+// unless there is a problem (like http.ListenAndServe).
+func StartProxy(conf ProxyConfig) error {
+	handler, err := newPilosaForwarder(conf.Hosts, conf.Translator)
+	if err != nil {
+		return err
+	}
+	if conf.ColumnKey != nil {
+		key := *conf.ColumnKey
+		if key.Translator == nil {
+			key.Translator = conf.Translator
+		}
+		handler.columnKey = &key
+	}
+	handler.cache = newQueryCache(conf.Cache)
+	if handler.cache != nil {
+		handler.cache.registerStats()
+	}
+	interval := conf.HealthCheckInterval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	handler.hosts.client.Timeout = interval
+	go handler.hosts.checkEvery(interval, nil)
+	Stats.RegisterGauge("pdk_proxy_healthy_hosts", "Pilosa hosts which the proxy considers up.", func() int64 { return int64(handler.hosts.healthy()) })
 	s := http.Server{
-		Addr:    bind,
+		Addr:    conf.Bind,
 		Handler: handler,
 	}
 	return s.ListenAndServe()
 }
 
 type pilosaForwarder struct {
-	phost  string
+	hosts  *hostPool
 	client http.Client
 	m      Translator
+	proxy  *httputil.ReverseProxy
+
+	// columnKey translates columns to record keys, if they have them.
+	columnKey *ColumnKey
+	cache     *queryCache
+}
+
+func newPilosaForwarder(hosts []string, m Translator) (*pilosaForwarder, error) {
+	pool, err := newHostPool(hosts)
+	if err != nil {
+		return nil, err
+	}
+	p := &pilosaForwarder{hosts: pool, m: m}
+	p.proxy = &httputil.ReverseProxy{
+		// hostTransport chooses the host
+		Director:  func(req *http.Request) {},
+		Transport: &hostTransport{hosts: pool, base: http.DefaultTransport},
+		// pass streamed responses on as they arrive
+		FlushInterval: 100 * time.Millisecond,
+	}
+	return p, nil
 }
 
 func (p *pilosaForwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if p.cache != nil && req.URL.Path == "/proxy/cache" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p.cache.snapshot()); err != nil {
+			log.Printf("encoding cache stats: %v", err)
+		}
+		return
+	}
+	if !isPQLQuery(req) {
+		p.proxy.ServeHTTP(w, req)
+		if !isReadOnly(req) {
+			p.cache.invalidate(requestIndex(req))
+		}
+		return
+	}
+	p.serveQuery(w, req)
+}
+
+// isReadOnly reports whether a request which isn't a PQL query leaves Pilosa's
+// data as it is. Anything but a GET (or HEAD or OPTIONS) is assumed to change
+// it, including protobuf queries, which can't be inspected.
+func isReadOnly(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// requestIndex returns the index which a request to Pilosa refers to, either
+// in its path (/index/{index}/...) or its parameters, or "" if there is none.
+func requestIndex(req *http.Request) string {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "index" {
+		return parts[1]
+	}
+	return req.URL.Query().Get("index")
+}
+
+// isPQLQuery reports whether req is a PQL query, whose keys and results need
+// translating. Protobuf queries are passed through, as they can't be parsed.
+func isPQLQuery(req *http.Request) bool {
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") == "application/x-protobuf" {
+		return false
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	return len(parts) == 3 && parts[0] == "index" && parts[2] == "query"
+}
+
+// serveQuery translates a PQL query, forwards it to pilosa, and translates the
+// results.
+func (p *pilosaForwarder) serveQuery(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -61,35 +243,103 @@ func (p *pilosaForwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	body, err = p.mapRequest(body)
-	if err != nil {
+	body, readOnly, err := p.mapRequest(body)
+	if uerr, ok := errors.Cause(err).(unknownKeyError); ok {
+		http.Error(w, uerr.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, "mapping request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	opts, err := parseColumnOptions(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.keys && p.columnKey == nil {
+		http.Error(w, "column keys requested, but the proxy has no column keys", http.StatusBadRequest)
+		return
+	}
+	// the column options are the proxy's, not pilosa's
+	params := req.URL.Query().Encode()
+	q := req.URL.Query()
+	for _, name := range []string{"keys", "attrs", "offset", "limit"} {
+		q.Del(name)
+	}
+	if opts.attrs {
+		q.Set("columnAttrs", "true")
+	}
+	req.URL.RawQuery = q.Encode()
+
+	// read only queries are answered from the cache when possible. The
+	// mapped body is the normalized PQL.
+	index := requestIndex(req)
+	var cacheKey string
+	var cacheGen uint64
+	if readOnly && p.cache != nil {
+		cacheKey = index + "\x00" + params + "\x00" + string(body)
+		if cached, ok := p.cache.get(cacheKey); ok {
+			w.Header().Set("Content-Type", "application/json")
+			if _, err := w.Write(cached); err != nil {
+				log.Printf("writing cached response: %v", err)
+			}
+			return
+		}
+		cacheGen = p.cache.generation(index)
+	}
+
 	// forward the request and get the pilosa response
-	resp, err := p.proxyRequest(req, body)
+	resp, err := p.proxyRequest(req, body, readOnly)
+	if !readOnly {
+		// even a failed write may have changed some data
+		p.cache.invalidate(index)
+	}
 	if err != nil {
 		log.Println("here", err)
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	defer resp.Body.Close()
+
+	// errors are passed back as they are
+	if resp.StatusCode != http.StatusOK {
+		copyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("copying error response: %v", err)
+		}
+		return
+	}
 
 	// decode pilosa response for inspection
 	dec := json.NewDecoder(resp.Body)
-	pilosaResp := &pilosa.QueryResponse{}
+	pilosaResp := &queryResponse{}
 	err = dec.Decode(pilosaResp)
 	if err != nil {
 		log.Printf("decoding json: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	colAttrs := make(map[uint64]map[string]interface{}, len(pilosaResp.ColumnAttrs))
+	for _, set := range pilosaResp.ColumnAttrs {
+		colAttrs[set.ID] = set.Attrs
+	}
 
 	// for each query result, try to map it
 	mappedResp := &pilosa.QueryResponse{
 		Results: make([]interface{}, len(pilosaResp.Results)),
 	}
 	for i, result := range pilosaResp.Results {
+		if bm, ok := result.(map[string]interface{}); ok && (opts.keys || opts.attrs) {
+			mappedResult, err := p.mapColumns(bm, opts, colAttrs)
+			if err != nil {
+				http.Error(w, "mapping columns: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			mappedResp.Results[i] = mappedResult
+			continue
+		}
 		if frames[i] == "" {
 			mappedResp.Results[i] = result
 			continue
@@ -103,31 +353,175 @@ func (p *pilosaForwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// write the mapped response back to the client
-	enc := json.NewEncoder(w)
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
 	err = enc.Encode(mappedResp)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "encoding newresp: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if readOnly {
+		p.cache.put(index, cacheKey, cacheGen, buf.Bytes())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// queryResponse is the JSON response to a PQL query.
+type queryResponse struct {
+	Results     []interface{} `json:"results"`
+	ColumnAttrs []struct {
+		ID    uint64                 `json:"id"`
+		Attrs map[string]interface{} `json:"attrs"`
+	} `json:"columnAttrs,omitempty"`
+}
+
+// DefaultColumnLimit is the number of columns of a bitmap result which the
+// proxy returns when translating columns, unless the query sets a limit.
+const DefaultColumnLimit = 1000
+
+// columnOptions are the query parameters with which a query to the proxy asks
+// for the columns of bitmap results to be translated.
+type columnOptions struct {
+	keys, attrs   bool
+	offset, limit int
+}
+
+// parseColumnOptions reads columnOptions from the parameters of a query:
+// keys=true translates columns to record keys, attrs=true merges in column
+// attributes, and offset and limit select a page of the columns.
+func parseColumnOptions(q url.Values) (columnOptions, error) {
+	opts := columnOptions{limit: DefaultColumnLimit}
+	var err error
+	for name, dst := range map[string]*bool{"keys": &opts.keys, "attrs": &opts.attrs} {
+		if v := q.Get(name); v != "" {
+			if *dst, err = strconv.ParseBool(v); err != nil {
+				return opts, fmt.Errorf("invalid %v: '%v'", name, v)
+			}
+		}
+	}
+	for name, dst := range map[string]*int{"offset": &opts.offset, "limit": &opts.limit} {
+		if v := q.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				return opts, fmt.Errorf("invalid %v: '%v'", name, v)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// mapColumns returns a page of the columns of a bitmap result, as "columns"
+// with their record keys and attributes. "bits" is replaced by the IDs of the
+// page, and "count" is the total number of bits.
+func (p *pilosaForwarder) mapColumns(bm map[string]interface{}, opts columnOptions, colAttrs map[uint64]map[string]interface{}) (map[string]interface{}, error) {
+	bits, _ := bm["bits"].([]interface{})
+	start, end := opts.offset, opts.offset+opts.limit
+	if start > len(bits) {
+		start = len(bits)
+	}
+	if end > len(bits) {
+		end = len(bits)
+	}
+	page := bits[start:end]
+
+	type column struct {
+		ID uint64 `json:"id"`
+		// Key is null for a column without a key, and omitted if keys
+		// weren't asked for.
+		Key   json.RawMessage        `json:"key,omitempty"`
+		Attrs map[string]interface{} `json:"attrs,omitempty"`
+	}
+	columns := make([]column, len(page))
+	for i, bit := range page {
+		id, ok := bit.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected column ID, got %v", bit)
+		}
+		columns[i].ID = uint64(id)
+		if opts.keys {
+			key, err := p.columnKeyJSON(columns[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			columns[i].Key = key
+		}
+		if opts.attrs {
+			columns[i].Attrs = colAttrs[columns[i].ID]
+		}
+	}
+	return map[string]interface{}{
+		"attrs":   bm["attrs"],
+		"bits":    page,
+		"count":   len(bits),
+		"offset":  opts.offset,
+		"columns": columns,
+	}, nil
+}
+
+// columnKeyJSON returns the record key of column id, encoded as described by
+// StartColumnMappingProxy.
+func (p *pilosaForwarder) columnKeyJSON(id uint64) (json.RawMessage, error) {
+	if p.columnKey == nil {
+		return nil, errors.New("the proxy has no column keys")
+	}
+	fields, ok, err := p.columnKey.LookupKeyFields(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "translating column %v", id)
+	}
+	var key interface{}
+	if ok && len(p.columnKey.Fields) == 1 {
+		key = fields[0]
+	} else if ok {
+		key = fields
+	}
+	return json.Marshal(key)
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vs := range src {
+		for _, v := range vs {
+			dst.Add(k, v)
+		}
+	}
 }
 
 // proxyRequest modifies the http.Request object in place to change it from a
 // server side request object to the proxy server to a client side request and
-// sends it to pilosa, returning the response.
-func (p *pilosaForwarder) proxyRequest(orig *http.Request, origbody []byte) (*http.Response, error) {
-	reqURL, err := url.Parse(p.phost + orig.URL.String())
-	if err != nil {
-		log.Printf("error parsing url: %v, err: %v", p.phost+orig.URL.String(), err)
-		return nil, err
+// sends it to one of the pilosa hosts, returning the response. If the host
+// can't be reached, the request is sent to the next one. Requests which fail
+// after connecting are only retried if they are idempotent.
+func (p *pilosaForwarder) proxyRequest(orig *http.Request, origbody []byte, idempotent bool) (*http.Response, error) {
+	uri := orig.URL.RequestURI()
+	tried := make(map[*pilosaNode]bool)
+	for {
+		node := p.hosts.pick(tried)
+		if node == nil {
+			return nil, errors.New("no pilosa hosts available")
+		}
+		tried[node] = true
+		reqURL, err := url.Parse(node.url.String() + uri)
+		if err != nil {
+			log.Printf("error parsing url: %v, err: %v", node.url.String()+uri, err)
+			return nil, err
+		}
+		orig.URL = reqURL
+		orig.Host = ""
+		orig.RequestURI = ""
+		orig.Body = ioutil.NopCloser(bytes.NewBuffer(origbody))
+		orig.ContentLength = int64(len(origbody))
+		resp, err := p.client.Do(orig)
+		if err == nil {
+			return resp, nil
+		}
+		p.hosts.markDown(node, err)
+		if !(idempotent || isDialError(err)) || len(tried) == len(p.hosts.nodes) {
+			return nil, errors.Wrapf(err, "querying %v", node.url.Host)
+		}
+		log.Printf("query failed on %v, retrying: %v", node.url.Host, err)
 	}
-	orig.URL = reqURL
-	orig.Host = ""
-	orig.RequestURI = ""
-	orig.Body = ioutil.NopCloser(bytes.NewBuffer(origbody))
-	orig.ContentLength = int64(len(origbody))
-	resp, err := p.client.Do(orig)
-	return resp, err
 }
 
 // mapResult converts the result of a single top level query (one element of
@@ -155,7 +549,11 @@ func (p *pilosaForwarder) mapResult(frame string, res interface{}) (mappedRes in
 				if !(isKeyFloat && isCountFloat) {
 					return nil, fmt.Errorf("expected pilosa.Pair, but have wrong value types: got %v", pair)
 				}
-				keyVal := p.m.Get(frame, uint64(keyFloat))
+				// ids which weren't mapped get a null key, as columns do
+				keyVal, _, err := lookupValue(p.m, frame, uint64(keyFloat))
+				if err != nil {
+					return nil, errors.Wrap(err, "translating TopN id")
+				}
 				switch kv := keyVal.(type) {
 				case []byte:
 					mr[i].Key = string(kv)
@@ -181,28 +579,68 @@ func (p *pilosaForwarder) mapResult(frame string, res interface{}) (mappedRes in
 	return mappedRes, nil
 }
 
-func (p *pilosaForwarder) mapRequest(body []byte) ([]byte, error) {
+// mapRequest translates the row keys in a PQL request, and reports whether
+// it only reads data.
+func (p *pilosaForwarder) mapRequest(body []byte) (mapped []byte, readOnly bool, err error) {
 	query, err := pql.ParseString(string(body))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	readOnly = true
 	for _, call := range query.Calls {
 		err := p.mapCall(call)
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+		readOnly = readOnly && !isWriteCall(call)
+	}
+	return []byte(query.String()), readOnly, nil
+}
+
+// isWriteCall reports whether call changes data in Pilosa.
+func isWriteCall(call *pql.Call) bool {
+	switch call.Name {
+	case "SetBit", "ClearBit", "SetRowAttrs", "SetColumnAttrs", "SetFieldValue",
+		"SetBitmapAttrs", "SetProfileAttrs":
+		return true
+	}
+	for _, child := range call.Children {
+		if isWriteCall(child) {
+			return true
 		}
 	}
-	return []byte(query.String()), nil
+	return false
 }
 
+// mapCall translates the row keys in call and its children to row IDs. Keys
+// are the row IDs of Bitmap, SetBit, ClearBit, Range and SetRowAttrs, and the
+// ids of TopN, given as strings. Numeric IDs are passed on as they are, so
+// frames which aren't translated can be queried too. The calls which write
+// rows allocate IDs for new keys, and the others refuse unknown keys.
 func (p *pilosaForwarder) mapCall(call *pql.Call) error {
-	if call.Name == "Bitmap" {
-		id, err := p.m.GetID(call.Args["frame"].(string), call.Args["rowID"])
-		if err != nil {
-			return err
+	frame, _ := call.Args["frame"].(string)
+	switch call.Name {
+	case "Bitmap", "SetBit", "ClearBit", "Range", "SetRowAttrs":
+		if key, ok := call.Args["rowID"]; ok {
+			alloc := call.Name == "SetBit" || call.Name == "ClearBit" || call.Name == "SetRowAttrs"
+			id, err := p.mapKey(frame, key, alloc)
+			if err != nil {
+				return err
+			}
+			call.Args["rowID"] = id
+		}
+	case "TopN":
+		if keys, ok := call.Args["ids"].([]interface{}); ok {
+			ids := make([]interface{}, len(keys))
+			for i, key := range keys {
+				id, err := p.mapKey(frame, key, false)
+				if err != nil {
+					return err
+				}
+				ids[i] = id
+			}
+			call.Args["ids"] = ids
 		}
-		call.Args["rowID"] = id
-		return nil
 	}
 	for _, child := range call.Children {
 		if err := p.mapCall(child); err != nil {
@@ -212,6 +650,53 @@ func (p *pilosaForwarder) mapCall(call *pql.Call) error {
 	return nil
 }
 
+// mapKey translates a row key in frame to its ID, allocating a new ID for a
+// key which hasn't been seen if alloc is set. Values which aren't strings are
+// returned unchanged.
+func (p *pilosaForwarder) mapKey(frame string, key interface{}, alloc bool) (interface{}, error) {
+	skey, ok := key.(string)
+	if !ok {
+		return key, nil
+	}
+	if frame == "" {
+		return nil, fmt.Errorf("can't translate row key '%v' without a frame", skey)
+	}
+	if alloc {
+		id, err := p.m.GetID(frame, skey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "translating row key '%v' in frame %v", skey, frame)
+		}
+		return id, nil
+	}
+	id, ok, err := p.m.LookupID(frame, skey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "translating row key '%v' in frame %v", skey, frame)
+	}
+	if !ok {
+		uerr := unknownKeyError{frame: frame, key: skey}
+		if kr, ok := p.m.(KeyRanger); ok {
+			uerr.suggestions = suggestKeys(kr, frame, skey)
+		}
+		return nil, uerr
+	}
+	return id, nil
+}
+
+// unknownKeyError is returned when a query refers to a value which hasn't
+// been mapped.
+type unknownKeyError struct {
+	frame, key  string
+	suggestions []string
+}
+
+func (e unknownKeyError) Error() string {
+	msg := fmt.Sprintf("unknown value '%v' for frame %v", e.key, e.frame)
+	if len(e.suggestions) > 0 {
+		msg += fmt.Sprintf("; did you mean '%v'?", strings.Join(e.suggestions, "', '"))
+	}
+	return msg
+}
+
 // getFrames interprets body as pql queries and then tries to determine the
 // frame of each. Some queries do not have frames, and the empty string will be
 // returned for these.
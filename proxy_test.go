@@ -0,0 +1,430 @@
+package pdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pilosa/pilosa/pql"
+)
+
+func TestProxyPassThrough(t *testing.T) {
+	pilosa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Pilosa", "yes")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/schema":
+			io.WriteString(w, `{"indexes":[]}`)
+		case r.Method == "POST" && r.URL.Path == "/index/i":
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, "index already exists")
+		case r.Method == "POST" && r.URL.Path == "/index/i/query":
+			// protobuf queries are passed on as they are
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.Write(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer pilosa.Close()
+
+	fwd, err := newPilosaForwarder([]string{strings.TrimPrefix(pilosa.URL, "http://")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(fwd)
+	defer proxy.Close()
+
+	tests := []struct {
+		method, path, contentType, body string
+		status                          int
+		expBody                         string
+	}{
+		{"GET", "/schema", "", "", http.StatusOK, `{"indexes":[]}`},
+		{"POST", "/index/i", "application/json", `{"options":{}}`, http.StatusConflict, "index already exists"},
+		{"POST", "/index/i/query", "application/x-protobuf", "\x0a\x03abc", http.StatusOK, "\x0a\x03abc"},
+		{"GET", "/nope", "", "", http.StatusNotFound, "404 page not found\n"},
+	}
+	for i, test := range tests {
+		req, err := http.NewRequest(test.method, proxy.URL+test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || string(body) != test.expBody {
+			t.Errorf("test %d: expected %v %q, got %v %q", i, test.status, test.expBody, resp.StatusCode, body)
+		}
+		if resp.Header.Get("X-Pilosa") != "yes" {
+			t.Errorf("test %d: pilosa's headers were not passed on: %v", i, resp.Header)
+		}
+	}
+}
+
+func TestIsPQLQuery(t *testing.T) {
+	tests := []struct {
+		method, path, contentType string
+		exp                       bool
+	}{
+		{"POST", "/index/i/query", "", true},
+		{"POST", "/index/i/query/", "text/plain", true},
+		{"POST", "/index/i/query", "application/x-protobuf", false},
+		{"GET", "/index/i/query", "", false},
+		{"POST", "/index/i/frame/f", "", false},
+		{"POST", "/index/i", "", false},
+		{"GET", "/status", "", false},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		if got := isPQLQuery(req); got != test.exp {
+			t.Errorf("test %d: expected %v for %v %v, got %v", i, test.exp, test.method, test.path, got)
+		}
+	}
+}
+
+// mapTranslator translates keys to IDs with a map per frame.
+type mapTranslator map[string]map[string]uint64
+
+func (m mapTranslator) Get(frame string, id uint64) interface{} {
+	for key, kid := range m[frame] {
+		if kid == id {
+			return key
+		}
+	}
+	return nil
+}
+
+func (m mapTranslator) GetID(frame string, val interface{}) (uint64, error) {
+	if id, ok := m[frame][val.(string)]; ok {
+		return id, nil
+	}
+	if m[frame] == nil {
+		m[frame] = make(map[string]uint64)
+	}
+	var id uint64
+	for _, kid := range m[frame] {
+		if kid >= id {
+			id = kid + 1
+		}
+	}
+	m[frame][val.(string)] = id
+	return id, nil
+}
+
+func (m mapTranslator) LookupID(frame string, val interface{}) (uint64, bool, error) {
+	id, ok := m[frame][val.(string)]
+	return id, ok, nil
+}
+
+func (m mapTranslator) RangeKeys(frame string, fn func(key string) bool) error {
+	keys := make([]string, 0, len(m[frame]))
+	for key := range m[frame] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestProxyMapCall(t *testing.T) {
+	m := mapTranslator{
+		"c_city":   {"UNITED KI1": 1, "UNITED KI5": 5},
+		"hostname": {"example.com": 7},
+	}
+	p := &pilosaForwarder{m: m}
+	call := func(name string, args map[string]interface{}, children ...*pql.Call) *pql.Call {
+		return &pql.Call{Name: name, Args: args, Children: children}
+	}
+	tests := []struct {
+		call *pql.Call
+		exp  *pql.Call
+		err  bool
+	}{
+		{
+			call: call("Count", nil, call("Bitmap", map[string]interface{}{"frame": "c_city", "rowID": "UNITED KI5"})),
+			exp:  call("Count", nil, call("Bitmap", map[string]interface{}{"frame": "c_city", "rowID": uint64(5)})),
+		},
+		{
+			call: call("SetBit", map[string]interface{}{"frame": "hostname", "rowID": "example.com", "columnID": int64(3)}),
+			exp:  call("SetBit", map[string]interface{}{"frame": "hostname", "rowID": uint64(7), "columnID": int64(3)}),
+		},
+		{
+			call: call("ClearBit", map[string]interface{}{"frame": "hostname", "rowID": "example.com", "columnID": int64(3)}),
+			exp:  call("ClearBit", map[string]interface{}{"frame": "hostname", "rowID": uint64(7), "columnID": int64(3)}),
+		},
+		{
+			call: call("SetRowAttrs", map[string]interface{}{"frame": "c_city", "rowID": "UNITED KI1", "name": "UNITED KI1"}),
+			exp:  call("SetRowAttrs", map[string]interface{}{"frame": "c_city", "rowID": uint64(1), "name": "UNITED KI1"}),
+		},
+		{
+			call: call("Range", map[string]interface{}{"frame": "hostname", "rowID": "example.com", "start": "2017-01-01T00:00"}),
+			exp:  call("Range", map[string]interface{}{"frame": "hostname", "rowID": uint64(7), "start": "2017-01-01T00:00"}),
+		},
+		{
+			call: call("TopN", map[string]interface{}{"frame": "c_city", "ids": []interface{}{"UNITED KI1", "UNITED KI5", int64(9)}},
+				call("Bitmap", map[string]interface{}{"frame": "hostname", "rowID": "example.com"})),
+			exp: call("TopN", map[string]interface{}{"frame": "c_city", "ids": []interface{}{uint64(1), uint64(5), int64(9)}},
+				call("Bitmap", map[string]interface{}{"frame": "hostname", "rowID": uint64(7)})),
+		},
+		{
+			// numeric IDs are left alone, even in frames without keys
+			call: call("Bitmap", map[string]interface{}{"frame": "lo_year", "rowID": int64(1997)}),
+			exp:  call("Bitmap", map[string]interface{}{"frame": "lo_year", "rowID": int64(1997)}),
+		},
+		{
+			// writes allocate ids for new keys
+			call: call("SetBit", map[string]interface{}{"frame": "hostname", "rowID": "new.example.com", "columnID": int64(3)}),
+			exp:  call("SetBit", map[string]interface{}{"frame": "hostname", "rowID": uint64(8), "columnID": int64(3)}),
+		},
+		{
+			call: call("Bitmap", map[string]interface{}{"frame": "hostname", "rowID": "new.example.com"}),
+			exp:  call("Bitmap", map[string]interface{}{"frame": "hostname", "rowID": uint64(8)}),
+		},
+		{call: call("Bitmap", map[string]interface{}{"frame": "c_city", "rowID": "nowhere"}), err: true},
+		{call: call("TopN", map[string]interface{}{"frame": "c_city", "ids": []interface{}{"nowhere"}}), err: true},
+		{call: call("Bitmap", map[string]interface{}{"rowID": "UNITED KI1"}), err: true},
+		{call: call("SetBit", map[string]interface{}{"rowID": "UNITED KI1", "columnID": int64(3)}), err: true},
+	}
+	for i, test := range tests {
+		err := p.mapCall(test.call)
+		if test.err {
+			if err == nil {
+				t.Errorf("test %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+		} else if !reflect.DeepEqual(test.call, test.exp) {
+			t.Errorf("test %d: expected %v, got %v", i, test.exp, test.call)
+		}
+	}
+	if _, ok := m["c_city"]["nowhere"]; ok {
+		t.Error("read allocated an id for an unknown key")
+	}
+}
+
+func TestProxyMapResultTopN(t *testing.T) {
+	p := &pilosaForwarder{m: mapTranslator{"c_city": {"UNITED KI1": 1}}}
+	res, err := p.mapResult("c_city", []interface{}{
+		map[string]interface{}{"id": float64(1), "count": float64(10)},
+		map[string]interface{}{"id": float64(2), "count": float64(4)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `[{"Key":"UNITED KI1","Count":10},{"Key":null,"Count":4}]`
+	if string(data) != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, data)
+	}
+}
+
+func TestProxyMapRequest(t *testing.T) {
+	p := &pilosaForwarder{m: mapTranslator{
+		"c_city":   {"UNITED KI1": 1, "UNITED KI5": 5},
+		"hostname": {"example.com": 7},
+	}}
+	tests := []struct {
+		query    string
+		ids      string // of the first call, after mapping and parsing again
+		rowID    string // of the first call's first child
+		readOnly bool
+	}{
+		{
+			query:    `TopN(Bitmap(frame="hostname", rowID="example.com"), frame="c_city", n=3, ids=["UNITED KI1", 9, "UNITED KI5"])`,
+			ids:      "[1 9 5]",
+			rowID:    "7",
+			readOnly: true,
+		},
+		{
+			query:    `TopN(Bitmap(frame="hostname", rowID=2), frame="c_city", ids=[4, 5]) SetBit(frame="hostname", rowID="example.com", columnID=1)`,
+			ids:      "[4 5]",
+			rowID:    "2",
+			readOnly: false,
+		},
+	}
+	for i, test := range tests {
+		mapped, readOnly, err := p.mapRequest([]byte(test.query))
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if readOnly != test.readOnly {
+			t.Errorf("test %d: expected read only %v, got %v", i, test.readOnly, readOnly)
+		}
+		query, err := pql.ParseString(string(mapped))
+		if err != nil {
+			t.Errorf("test %d: parsing mapped query %s: %v", i, mapped, err)
+			continue
+		}
+		call := query.Calls[0]
+		if ids := fmt.Sprint(call.Args["ids"]); ids != test.ids {
+			t.Errorf("test %d: expected ids %v, got %v in %s", i, test.ids, ids, mapped)
+		}
+		if rowID := fmt.Sprint(call.Children[0].Args["rowID"]); rowID != test.rowID {
+			t.Errorf("test %d: expected rowID %v, got %v in %s", i, test.rowID, rowID, mapped)
+		}
+		if frame := call.Args["frame"]; frame != "c_city" {
+			t.Errorf("test %d: expected frame c_city, got %v in %s", i, frame, mapped)
+		}
+	}
+}
+
+func TestParseColumnOptions(t *testing.T) {
+	tests := []struct {
+		query string
+		exp   columnOptions
+		err   bool
+	}{
+		{"", columnOptions{limit: DefaultColumnLimit}, false},
+		{"keys=true&attrs=1&offset=10&limit=5", columnOptions{keys: true, attrs: true, offset: 10, limit: 5}, false},
+		{"keys=maybe", columnOptions{}, true},
+		{"limit=-1", columnOptions{}, true},
+	}
+	for i, test := range tests {
+		q, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := parseColumnOptions(q)
+		if test.err {
+			if err == nil {
+				t.Errorf("test %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil || opts != test.exp {
+			t.Errorf("test %d: expected %+v, got %+v, %v", i, test.exp, opts, err)
+		}
+	}
+}
+
+func TestProxyMapColumns(t *testing.T) {
+	m := mapTranslator{"key": {"1,1": 3, "1,2": 4, "2,1": 8}}
+	p := &pilosaForwarder{m: m, columnKey: &ColumnKey{Frame: "key", Fields: []int{0, 1}, Translator: m}}
+	bm := map[string]interface{}{
+		"attrs": map[string]interface{}{"name": "red"},
+		"bits":  []interface{}{float64(3), float64(4), float64(8), float64(9)},
+	}
+	colAttrs := map[uint64]map[string]interface{}{4: {"fare": 12.5}, 8: {"fare": 3.0}}
+
+	res, err := p.mapColumns(bm, columnOptions{keys: true, attrs: true, offset: 1, limit: 5}, colAttrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"attrs":{"name":"red"},"bits":[4,8,9],"columns":[{"id":4,"key":["1","2"],"attrs":{"fare":12.5}},{"id":8,"key":["2","1"],"attrs":{"fare":3}},{"id":9,"key":null}],"count":4,"offset":1}`
+	if string(data) != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, data)
+	}
+
+	res, err = p.mapColumns(bm, columnOptions{attrs: true, offset: 5, limit: 5}, colAttrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := res["bits"].([]interface{}); len(page) != 0 || res["count"] != 4 {
+		t.Errorf("expected empty page past the end, got %v", res)
+	}
+}
+
+func TestProxyMapColumnsLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lt, err := NewLevelTranslator(dir, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lt.Close()
+	id, err := lt.GetID("key", []byte("a,b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// LevelTranslator.Get panics for ids which weren't mapped
+	p := &pilosaForwarder{m: lt, columnKey: &ColumnKey{Frame: "key", Fields: []int{0}, Translator: lt}}
+	bm := map[string]interface{}{"bits": []interface{}{float64(id), float64(id + 1)}}
+	res, err := p.mapColumns(bm, columnOptions{keys: true, limit: 5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(res["columns"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := fmt.Sprintf(`[{"id":%d,"key":"a,b"},{"id":%d,"key":null}]`, id, id+1)
+	if string(data) != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, data)
+	}
+}
+
+func TestProxyUnknownKey(t *testing.T) {
+	m := mapTranslator{"c_city": {"UNITED KI1": 1, "UNITED KI5": 5, "PERU     0": 9}}
+	p := &pilosaForwarder{m: m}
+	err := p.mapCall(&pql.Call{Name: "Bitmap", Args: map[string]interface{}{"frame": "c_city", "rowID": "united ki2"}})
+	exp := "unknown value 'united ki2' for frame c_city; did you mean 'UNITED KI1', 'UNITED KI5'?"
+	if err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+	err = p.mapCall(&pql.Call{Name: "Bitmap", Args: map[string]interface{}{"frame": "c_city", "rowID": "ZIMBABWE"}})
+	exp = "unknown value 'ZIMBABWE' for frame c_city"
+	if err == nil || err.Error() != exp {
+		t.Errorf("expected error %q, got %v", exp, err)
+	}
+	if len(m["c_city"]) != 3 {
+		t.Errorf("lookups allocated ids: %v", m)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		exp  int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"UNITED KI1", "UNITED KI5", 1},
+		{"héllo", "hello", 1},
+	}
+	for _, test := range tests {
+		if d := editDistance([]rune(test.a), []rune(test.b)); d != test.exp {
+			t.Errorf("distance between %q and %q: expected %v, got %v", test.a, test.b, test.exp, d)
+		}
+	}
+}
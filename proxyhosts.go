@@ -0,0 +1,179 @@
+package pdk
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHealthCheckInterval is how often the mapping proxy checks each
+// Pilosa host, unless ProxyConfig sets another interval.
+const DefaultHealthCheckInterval = 5 * time.Second
+
+// pilosaNode is one of the Pilosa hosts behind the proxy.
+type pilosaNode struct {
+	url     *url.URL
+	healthy int32 // accessed atomically
+}
+
+func (n *pilosaNode) isHealthy() bool {
+	return atomic.LoadInt32(&n.healthy) == 1
+}
+
+// hostPool spreads the proxy's requests over a set of Pilosa hosts, round
+// robin, skipping hosts which are down. A host is marked down when a request
+// to it fails, or when it fails a health check, and up again when it passes
+// one.
+type hostPool struct {
+	nodes  []*pilosaNode
+	next   uint32 // accessed atomically
+	client *http.Client
+}
+
+func newHostPool(hosts []string) (*hostPool, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no pilosa hosts")
+	}
+	hp := &hostPool{client: &http.Client{Timeout: DefaultHealthCheckInterval}}
+	for _, host := range hosts {
+		u, err := url.Parse(hostURL(host))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing pilosa address %v", host)
+		}
+		hp.nodes = append(hp.nodes, &pilosaNode{url: u, healthy: 1})
+	}
+	return hp, nil
+}
+
+// pick returns the next healthy host which isn't in tried. If every host which
+// hasn't been tried is down, one of them is returned anyway, as health checks
+// may be out of date. pick returns nil once every host has been tried.
+func (hp *hostPool) pick(tried map[*pilosaNode]bool) *pilosaNode {
+	var healthy, down []*pilosaNode
+	for _, node := range hp.nodes {
+		if tried[node] {
+			continue
+		}
+		if node.isHealthy() {
+			healthy = append(healthy, node)
+		} else {
+			down = append(down, node)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = down
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[int(atomic.AddUint32(&hp.next, 1))%len(healthy)]
+}
+
+func (hp *hostPool) markDown(node *pilosaNode, err error) {
+	if atomic.CompareAndSwapInt32(&node.healthy, 1, 0) {
+		log.Printf("pilosa host %v is down: %v", node.url.Host, err)
+	}
+}
+
+func (hp *hostPool) markUp(node *pilosaNode) {
+	if atomic.CompareAndSwapInt32(&node.healthy, 0, 1) {
+		log.Printf("pilosa host %v is up", node.url.Host)
+	}
+}
+
+// healthy returns the number of hosts which are up.
+func (hp *hostPool) healthy() int {
+	n := 0
+	for _, node := range hp.nodes {
+		if node.isHealthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// check asks node for its status, and marks it up or down.
+func (hp *hostPool) check(node *pilosaNode) {
+	resp, err := hp.client.Get(node.url.String() + "/status")
+	if err == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = errors.Errorf("status check: %v", resp.Status)
+		}
+	}
+	if err != nil {
+		hp.markDown(node, err)
+		return
+	}
+	hp.markUp(node)
+}
+
+// checkEvery checks each host every interval until stop is closed.
+func (hp *hostPool) checkEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, node := range hp.nodes {
+				go hp.check(node)
+			}
+		}
+	}
+}
+
+// isDialError reports whether err happened while connecting, in which case
+// nothing was sent, and any request can be retried on another host.
+func isDialError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	operr, ok := err.(*net.OpError)
+	return ok && operr.Op == "dial"
+}
+
+// hostTransport is the http.RoundTripper of the proxy's pass through. It sends
+// each request to the next host which is up, marks the host down if the
+// request fails, and sends requests without bodies, which are read only, on to
+// another host. Other requests aren't retried, as their bodies have been
+// consumed.
+type hostTransport struct {
+	hosts *hostPool
+	base  http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*pilosaNode]bool)
+	for {
+		node := t.hosts.pick(tried)
+		if node == nil {
+			return nil, errors.New("no pilosa hosts available")
+		}
+		tried[node] = true
+		out := new(http.Request)
+		*out = *req
+		u := *req.URL
+		u.Scheme = node.url.Scheme
+		u.Host = node.url.Host
+		out.URL = &u
+		resp, err := t.base.RoundTrip(out)
+		if err == nil {
+			return resp, nil
+		}
+		t.hosts.markDown(node, err)
+		if !isReadOnly(req) || len(tried) == len(t.hosts.nodes) {
+			return nil, errors.Wrapf(err, "%v %v on %v", req.Method, req.URL.Path, node.url.Host)
+		}
+		log.Printf("%v %v failed on %v, retrying: %v", req.Method, req.URL.Path, node.url.Host, err)
+	}
+}
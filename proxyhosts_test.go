@@ -0,0 +1,120 @@
+package pdk
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHostPoolPick(t *testing.T) {
+	hp, err := newHostPool([]string{"a:10101", "http://b:10101", "c:10101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := hp.nodes[0], hp.nodes[1], hp.nodes[2]
+	if b.url.Host != "b:10101" || b.url.Scheme != "http" {
+		t.Fatalf("unexpected url %v", b.url)
+	}
+	hp.markDown(b, io.EOF)
+
+	counts := make(map[*pilosaNode]int)
+	for i := 0; i < 6; i++ {
+		counts[hp.pick(nil)]++
+	}
+	if counts[a] != 3 || counts[c] != 3 {
+		t.Errorf("expected requests spread over a and c, got a:%d b:%d c:%d", counts[a], counts[b], counts[c])
+	}
+	// a host which is down is used once the others have been tried
+	if node := hp.pick(map[*pilosaNode]bool{a: true, c: true}); node != b {
+		t.Errorf("expected b, got %v", node.url)
+	}
+	if node := hp.pick(map[*pilosaNode]bool{a: true, b: true, c: true}); node != nil {
+		t.Errorf("expected no host, got %v", node.url)
+	}
+	if n := hp.healthy(); n != 2 {
+		t.Errorf("expected 2 healthy hosts, got %d", n)
+	}
+
+	if _, err := newHostPool(nil); err == nil {
+		t.Error("expected error without hosts")
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status", "/schema":
+			io.WriteString(w, `{}`)
+		case "/index/i/query":
+			io.WriteString(w, `{"results":[3]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	fwd, err := newPilosaForwarder([]string{strings.TrimPrefix(dead.URL, "http://"), live.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadNode, liveNode := fwd.hosts.nodes[0], fwd.hosts.nodes[1]
+	proxy := httptest.NewServer(fwd)
+	defer proxy.Close()
+
+	// passed through reads and queries fail over to the live host, whichever
+	// host they start on
+	for i := 0; i < 2; i++ {
+		fwd.hosts.markUp(deadNode)
+		resp, err := http.Get(proxy.URL + "/schema")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != `{}` {
+			t.Errorf("attempt %d: expected schema, got %v %q", i, resp.Status, body)
+		}
+
+		fwd.hosts.markUp(deadNode)
+		req := httptest.NewRequest("POST", "/index/i/query", nil)
+		resp, err = fwd.proxyRequest(req, []byte("SetBit()"), false)
+		if err != nil {
+			t.Fatalf("attempt %d: connection errors should be retried: %v", i, err)
+		}
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != `{"results":[3]}` {
+			t.Errorf("attempt %d: unexpected response %q", i, body)
+		}
+	}
+	if deadNode.isHealthy() {
+		t.Error("expected dead host to be marked down")
+	}
+
+	// writes which are passed through aren't retried
+	fwd.hosts.markUp(deadNode)
+	statuses := make(map[int]int)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(proxy.URL+"/index/i/frame/f/import", "application/x-protobuf", strings.NewReader("bits"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		statuses[resp.StatusCode]++
+		fwd.hosts.markUp(deadNode)
+	}
+	if statuses[http.StatusBadGateway] != 1 || statuses[http.StatusNotFound] != 1 {
+		t.Errorf("expected one write to fail and one to reach pilosa, got %v", statuses)
+	}
+
+	fwd.hosts.check(liveNode)
+	fwd.hosts.check(deadNode)
+	if !liveNode.isHealthy() || deadNode.isHealthy() {
+		t.Errorf("unexpected health after checks: live %v, dead %v", liveNode.isHealthy(), deadNode.isHealthy())
+	}
+}
@@ -0,0 +1,184 @@
+package pdk
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// QueryCacheConfig configures the mapping proxy's cache of query responses.
+// Responses to read only queries are cached by index and query, and all of an
+// index's responses are dropped whenever a write to it (a SetBit, ClearBit or
+// attribute query, an import, or any other request which isn't a GET) passes
+// through the proxy. Writes which go to Pilosa directly aren't seen, so TTL
+// bounds how stale a response can get.
+type QueryCacheConfig struct {
+	// MaxBytes bounds the total size of the cached responses, beyond which
+	// the least recently used are evicted. Zero disables the cache.
+	MaxBytes int
+	// TTL is how long a response is served from the cache. Zero means until
+	// it is evicted or invalidated.
+	TTL time.Duration
+}
+
+// QueryCacheStats are the counters of a proxy's query cache.
+type QueryCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Bytes         int    `json:"bytes"`
+}
+
+type cacheEntry struct {
+	key     string
+	index   string
+	body    []byte
+	expires time.Time
+}
+
+// queryCache is an LRU cache of query responses. A nil *queryCache caches
+// nothing.
+type queryCache struct {
+	mu      sync.Mutex
+	conf    QueryCacheConfig
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	bytes   int
+	stats   QueryCacheStats
+
+	// generations count the invalidations of each index, and all is the
+	// count of invalidations of every index. A response is only stored if
+	// its index hasn't been invalidated since the query was sent.
+	generations map[string]uint64
+	all         uint64
+
+	now func() time.Time
+}
+
+func newQueryCache(conf QueryCacheConfig) *queryCache {
+	if conf.MaxBytes <= 0 {
+		return nil
+	}
+	return &queryCache{
+		conf:        conf,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		generations: make(map[string]uint64),
+		now:         time.Now,
+	}
+}
+
+// get returns the cached response for key, if there is one which hasn't
+// expired.
+func (c *queryCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && c.conf.TTL > 0 && c.now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).body, true
+}
+
+// generation returns a token which changes whenever index is invalidated. It
+// is taken before sending a query, and passed to put with the response.
+func (c *queryCache) generation(index string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.all + c.generations[index]
+}
+
+// put caches the response to the query key on index, unless the index has been
+// invalidated since gen was taken. Responses bigger than the cache aren't
+// stored.
+func (c *queryCache) put(index, key string, gen uint64, body []byte) {
+	if c == nil || len(body) > c.conf.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.all+c.generations[index] {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		index:   index,
+		body:    body,
+		expires: c.now().Add(c.conf.TTL),
+	})
+	c.bytes += len(body)
+	for c.bytes > c.conf.MaxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the cached responses for index, or for every index if it
+// is empty.
+func (c *queryCache) invalidate(index string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index == "" {
+		c.all++
+	} else {
+		c.generations[index]++
+	}
+	c.stats.Invalidations++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if index == "" || el.Value.(*cacheEntry).index == index {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *queryCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.body)
+}
+
+// snapshot returns the cache's counters.
+func (c *queryCache) snapshot() QueryCacheStats {
+	if c == nil {
+		return QueryCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// registerStats adds the cache's counters to Stats.
+func (c *queryCache) registerStats() {
+	Stats.RegisterCounter("pdk_proxy_cache_hits_total", "Queries answered from the proxy's cache.", func() int64 { return int64(c.snapshot().Hits) })
+	Stats.RegisterCounter("pdk_proxy_cache_misses_total", "Cacheable queries sent to Pilosa.", func() int64 { return int64(c.snapshot().Misses) })
+	Stats.RegisterCounter("pdk_proxy_cache_evictions_total", "Responses evicted from the proxy's cache to make room.", func() int64 { return int64(c.snapshot().Evictions) })
+	Stats.RegisterCounter("pdk_proxy_cache_invalidations_total", "Writes which invalidated cached responses.", func() int64 { return int64(c.snapshot().Invalidations) })
+	Stats.RegisterGauge("pdk_proxy_cache_entries", "Responses in the proxy's cache.", func() int64 { return int64(c.snapshot().Entries) })
+	Stats.RegisterGauge("pdk_proxy_cache_bytes", "Size of the responses in the proxy's cache.", func() int64 { return int64(c.snapshot().Bytes) })
+}
@@ -0,0 +1,114 @@
+package pdk
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pilosa/pilosa/pql"
+)
+
+func TestQueryCache(t *testing.T) {
+	if c := newQueryCache(QueryCacheConfig{}); c != nil {
+		t.Fatalf("expected nil cache without a size, got %+v", c)
+	}
+	var nilCache *queryCache
+	nilCache.put("i", "q", nilCache.generation("i"), []byte("x"))
+	if _, ok := nilCache.get("q"); ok {
+		t.Fatal("nil cache returned a response")
+	}
+
+	now := time.Now()
+	c := newQueryCache(QueryCacheConfig{MaxBytes: 10, TTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	c.put("i", "a", c.generation("i"), []byte("aaaa"))
+	c.put("i", "b", c.generation("i"), []byte("bbbb"))
+	c.put("j", "big", c.generation("j"), []byte("too big to cache"))
+	if _, ok := c.get("a"); !ok { // a is now the most recently used
+		t.Error("expected a to be cached")
+	}
+	c.put("j", "c", c.generation("j"), []byte("cccc"))
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	// a write to i drops its responses, and any sent before it
+	gen := c.generation("i")
+	c.invalidate("i")
+	c.put("i", "d", gen, []byte("dd"))
+	for _, key := range []string{"a", "d"} {
+		if _, ok := c.get(key); ok {
+			t.Errorf("expected %v to be invalidated", key)
+		}
+	}
+	if body, ok := c.get("c"); !ok || string(body) != "cccc" {
+		t.Errorf("expected c to survive invalidation of another index, got %q", body)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("expected c to expire")
+	}
+	c.put("j", "e", c.generation("j"), []byte("e"))
+	c.invalidate("")
+	if _, ok := c.get("e"); ok {
+		t.Error("expected e to be invalidated with every index")
+	}
+
+	exp := QueryCacheStats{Hits: 2, Misses: 5, Evictions: 1, Invalidations: 2}
+	if stats := c.snapshot(); !reflect.DeepEqual(stats, exp) {
+		t.Errorf("expected stats %+v, got %+v", exp, stats)
+	}
+}
+
+func TestProxyInvalidation(t *testing.T) {
+	fwd, err := newPilosaForwarder([]string{"localhost:0"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd.cache = newQueryCache(QueryCacheConfig{MaxBytes: 100})
+
+	tests := []struct {
+		method, path string
+		invalidated  []string
+	}{
+		{"GET", "/index/i/frame/f/views", nil},
+		{"POST", "/index/i/frame/f/import", []string{"i"}},
+		{"DELETE", "/index/j", []string{"j"}},
+		{"POST", "/fragment/data?index=j&frame=f&view=standard&slice=0", []string{"j"}},
+		{"POST", "/import", []string{"i", "j"}},
+	}
+	for i, test := range tests {
+		fwd.cache.put("i", "qi", fwd.cache.generation("i"), []byte("i"))
+		fwd.cache.put("j", "qj", fwd.cache.generation("j"), []byte("j"))
+		fwd.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+		var invalidated []string
+		for _, index := range []string{"i", "j"} {
+			if _, ok := fwd.cache.get("q" + index); !ok {
+				invalidated = append(invalidated, index)
+			}
+		}
+		if !reflect.DeepEqual(invalidated, test.invalidated) {
+			t.Errorf("test %d: expected %v invalidated, got %v", i, test.invalidated, invalidated)
+		}
+	}
+}
+
+func TestIsWriteCall(t *testing.T) {
+	tests := []struct {
+		call *pql.Call
+		exp  bool
+	}{
+		{&pql.Call{Name: "Count", Children: []*pql.Call{{Name: "Bitmap"}}}, false},
+		{&pql.Call{Name: "TopN"}, false},
+		{&pql.Call{Name: "SetBit"}, true},
+		{&pql.Call{Name: "SetRowAttrs"}, true},
+	}
+	for i, test := range tests {
+		if got := isWriteCall(test.call); got != test.exp {
+			t.Errorf("test %d: expected %v, got %v", i, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package pdk
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how Index retries a batch which fails to import.
+type RetryPolicy struct {
+	// Attempts is the number of times a batch is sent before giving up on
+	// it. Values below 1 mean 1.
+	Attempts int
+	// InitialBackoff is the wait after the first failure. It doubles after
+	// each further failure, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy retries a batch for about two minutes before giving up
+// on it.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       8,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// do calls fn until it succeeds or p.Attempts calls have failed, in which case
+// the last error is returned. onRetry is called before each wait.
+func (p RetryPolicy) do(fn func() error, onRetry func(attempt int, wait time.Duration, err error)) error {
+	wait := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts {
+			return err
+		}
+		onRetry(attempt, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > p.MaxBackoff {
+			wait = p.MaxBackoff
+		}
+	}
+}
+
+type sliceBitIterator struct {
+	bits []pcli.Bit
+	i    int
+}
+
+func (it *sliceBitIterator) NextBit() (pcli.Bit, error) {
+	if it.i >= len(it.bits) {
+		return pcli.Bit{}, io.EOF
+	}
+	it.i++
+	return it.bits[it.i-1], nil
+}
+
+type sliceValIterator struct {
+	vals []pcli.FieldValue
+	i    int
+}
+
+func (it *sliceValIterator) NextValue() (pcli.FieldValue, error) {
+	if it.i >= len(it.vals) {
+		return pcli.FieldValue{}, io.EOF
+	}
+	it.i++
+	return it.vals[it.i-1], nil
+}
+
+// maxClearsPerQuery is the most ClearBit calls sent in one query.
+const maxClearsPerQuery = 1000
+
+// runBitImport reads bits for a frame from c until it is closed, grouping
+// them by slice and sending them in batches of up to i.batchSize (see
+// bitBuffer). A batch which fails after retrying is spooled if the Index has
+// a spool. Spooled batches are replayed before each batch is sent, and once
+// more when c is closed; while some remain, new batches are spooled behind
+// them, so that batches are imported in order.
+//
+// Bits to be cleared are collected in a clearBuffer, and once there are
+// i.batchSize of them, or c is closed, the bit buffer is sent followed by the
+// clears. A bit which is set again before its clear is sent is dropped from
+// the clearBuffer instead, so sending every set before every clear leaves
+// each bit as it was last set or cleared.
+//
+// Failures are recorded with addErr rather than ending the import, which must
+// read c until it is closed so that AddBit never blocks.
+func (i *Index) runBitImport(fram *pcli.Frame, frame string, c ChanBitIterator, stats *FrameStats) {
+	i.countSpooled(frame, "", spoolBitSize, stats)
+	buf := newBitBuffer(i.batchSize, i.maxBuffered)
+	clears := newClearBuffer()
+	flush := func() {
+		for _, batch := range buf.drain() {
+			i.sendBits(fram, frame, batch, stats)
+		}
+		if bits := clears.take(); len(bits) > 0 {
+			i.sendClears(fram, frame, bits, stats)
+		}
+	}
+	for bit := range c {
+		if bit.Timestamp == clearTimestamp {
+			if clears.add(bit) >= int(i.batchSize) {
+				flush()
+			}
+			continue
+		}
+		clears.remove(bit)
+		if batch := buf.add(bit); batch != nil {
+			i.sendBits(fram, frame, batch, stats)
+		}
+	}
+	flush()
+	i.replay(frame, "", stats, i.replayBits(fram), true)
+}
+
+func (i *Index) sendBits(fram *pcli.Frame, frame string, bits []pcli.Bit, stats *FrameStats) {
+	// spooled batches are older, so they go first, or they would overwrite
+	// newer bits when replayed.
+	if !i.replay(frame, "", stats, i.replayBits(fram), false) {
+		i.spoolBits(frame, bits, stats, errors.New("earlier batches are still spooled"))
+		return
+	}
+	start := time.Now()
+	err := i.retry.do(func() error {
+		return i.throttle.do(len(bits), func() error {
+			return i.importBits(fram, &sliceBitIterator{bits: bits})
+		})
+	}, func(attempt int, wait time.Duration, err error) {
+		stats.addRetry()
+		log.Printf("importing %d bits into frame %v failed (attempt %d), retrying in %v: %v", len(bits), frame, attempt, wait, err)
+	})
+	if err == nil {
+		stats.addBatch(len(bits), time.Since(start))
+		return
+	}
+	stats.addError()
+	i.spoolBits(frame, bits, stats, err)
+}
+
+// spoolBits writes a batch which couldn't be sent because of err to the
+// spool, or records err if there is no spool.
+func (i *Index) spoolBits(frame string, bits []pcli.Bit, stats *FrameStats, err error) {
+	if i.spool == nil {
+		i.addErr(errors.Wrapf(err, "importing frame %v", frame))
+		return
+	}
+	path, serr := i.spool.writeBits(frame, bits)
+	if serr != nil {
+		i.addErr(errors.Wrapf(err, "importing frame %v (and spooling failed: %v)", frame, serr))
+		return
+	}
+	stats.addSpooled(len(bits))
+	log.Printf("importing frame %v: %v; spooled %d bits to %v", frame, err, len(bits), path)
+}
+
+// sendClears clears bits, retrying on failure. Like batches of bits, a batch of
+// clears which fails is spooled, with each bit's Timestamp set to
+// clearTimestamp, and is spooled behind any earlier batches, so that it is
+// never replayed before bits which were set earlier.
+func (i *Index) sendClears(fram *pcli.Frame, frame string, bits []pcli.Bit, stats *FrameStats) {
+	if !i.replay(frame, "", stats, i.replayBits(fram), false) {
+		i.spoolBits(frame, bits, stats, errors.New("earlier batches are still spooled"))
+		return
+	}
+	err := i.retry.do(func() error {
+		return i.throttle.do(len(bits), func() error {
+			return i.clearBits(fram, bits)
+		})
+	}, func(attempt int, wait time.Duration, err error) {
+		stats.addRetry()
+		log.Printf("clearing %d bits in frame %v failed (attempt %d), retrying in %v: %v", len(bits), frame, attempt, wait, err)
+	})
+	if err == nil {
+		return
+	}
+	stats.addError()
+	i.spoolBits(frame, bits, stats, errors.Wrap(err, "clearing bits"))
+}
+
+// replayBits returns a function which sends a spooled batch of bits, clearing
+// them if it was spooled by sendClears.
+func (i *Index) replayBits(fram *pcli.Frame) func(path string) (int, error) {
+	return func(path string) (int, error) {
+		bits, err := readSpooledBits(path)
+		if err != nil {
+			return 0, err
+		}
+		if len(bits) > 0 && bits[0].Timestamp == clearTimestamp {
+			return len(bits), i.throttle.do(len(bits), func() error {
+				return i.clearBits(fram, bits)
+			})
+		}
+		return len(bits), i.throttle.do(len(bits), func() error {
+			return i.importBits(fram, &sliceBitIterator{bits: bits})
+		})
+	}
+}
+
+// runValueImport is runBitImport for the values of a BSI field.
+func (i *Index) runValueImport(fram *pcli.Frame, frame, field string, c ChanValIterator, stats *FrameStats) {
+	i.countSpooled(frame, field, spoolValSize, stats)
+	buf := newValBuffer(i.batchSize, i.maxBuffered)
+	for val := range c {
+		if batch := buf.add(val); batch != nil {
+			i.sendValues(fram, frame, field, batch, stats)
+		}
+	}
+	for _, batch := range buf.drain() {
+		i.sendValues(fram, frame, field, batch, stats)
+	}
+	i.replay(frame, field, stats, i.replayValues(fram, field), true)
+}
+
+func (i *Index) sendValues(fram *pcli.Frame, frame, field string, vals []pcli.FieldValue, stats *FrameStats) {
+	if !i.replay(frame, field, stats, i.replayValues(fram, field), false) {
+		i.spoolValues(frame, field, vals, stats, errors.New("earlier batches are still spooled"))
+		return
+	}
+	start := time.Now()
+	err := i.retry.do(func() error {
+		return i.throttle.do(len(vals), func() error {
+			return i.importValues(fram, field, &sliceValIterator{vals: vals})
+		})
+	}, func(attempt int, wait time.Duration, err error) {
+		stats.addRetry()
+		log.Printf("importing %d values into field %v/%v failed (attempt %d), retrying in %v: %v", len(vals), frame, field, attempt, wait, err)
+	})
+	if err == nil {
+		stats.addBatch(len(vals), time.Since(start))
+		return
+	}
+	stats.addError()
+	i.spoolValues(frame, field, vals, stats, err)
+}
+
+// spoolValues is spoolBits for the values of a BSI field.
+func (i *Index) spoolValues(frame, field string, vals []pcli.FieldValue, stats *FrameStats, err error) {
+	if i.spool == nil {
+		i.addErr(errors.Wrapf(err, "importing field %v/%v", frame, field))
+		return
+	}
+	path, serr := i.spool.writeValues(frame, field, vals)
+	if serr != nil {
+		i.addErr(errors.Wrapf(err, "importing field %v/%v (and spooling failed: %v)", frame, field, serr))
+		return
+	}
+	stats.addSpooled(len(vals))
+	log.Printf("importing field %v/%v: %v; spooled %d values to %v", frame, field, err, len(vals), path)
+}
+
+func (i *Index) replayValues(fram *pcli.Frame, field string) func(path string) (int, error) {
+	return func(path string) (int, error) {
+		vals, err := readSpooledValues(path)
+		if err != nil {
+			return 0, err
+		}
+		return len(vals), i.throttle.do(len(vals), func() error {
+			return i.importValues(fram, field, &sliceValIterator{vals: vals})
+		})
+	}
+}
+
+// replay sends the spooled batches for frame and field, oldest first, with
+// send, removing each one which is imported. It stops at the first failure,
+// leaving the rest for a later replay, and reports whether the spool is empty.
+// If final is set, an error is recorded for any batches which remain.
+func (i *Index) replay(frame, field string, stats *FrameStats, send func(path string) (int, error), final bool) bool {
+	if i.spool == nil {
+		return true
+	}
+	paths, err := i.spool.files(frame, field)
+	if err != nil {
+		i.addErr(err)
+		return false
+	}
+	for n, path := range paths {
+		start := time.Now()
+		count, err := send(path)
+		if err != nil {
+			if final {
+				i.addErr(errors.Wrapf(err, "replaying spool, %d batches left in %v", len(paths)-n, i.spool.path(frame, field)))
+			}
+			return false
+		}
+		if err := os.Remove(path); err != nil {
+			i.addErr(errors.Wrap(err, "removing replayed spool file"))
+			return false
+		}
+		stats.addBatch(count, time.Since(start))
+		stats.addUnspooled(count)
+		log.Printf("replayed %d spooled records from %v", count, path)
+	}
+	return true
+}
+
+// countSpooled sets the spooled gauge in stats from the batches on disk, which
+// may have been left by a previous run or a previous Flush.
+func (i *Index) countSpooled(frame, field string, recordSize int64, stats *FrameStats) {
+	if i.spool == nil {
+		return
+	}
+	paths, err := i.spool.files(frame, field)
+	if err != nil {
+		i.addErr(err)
+		return
+	}
+	n := 0
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			n += int(info.Size() / recordSize)
+		}
+	}
+	stats.setSpooled(n)
+}
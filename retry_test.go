@@ -0,0 +1,273 @@
+package pdk
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pkg/errors"
+)
+
+func TestSpoolRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newSpool(dir)
+	bits := []pcli.Bit{{RowID: 1, ColumnID: 2}, {RowID: 3, ColumnID: 4, Timestamp: -5}}
+	vals := []pcli.FieldValue{{ColumnID: 7, Value: 1 << 40}}
+	if _, err := s.writeBits("f", bits); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writeBits("f", bits[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writeValues("f", "v", vals); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := s.files("f", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 spooled bit batches, got %v", paths)
+	}
+	for i, exp := range [][]pcli.Bit{bits, bits[:1]} {
+		got, err := readSpooledBits(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("batch %d: expected %v, got %v", i, exp, got)
+		}
+	}
+
+	paths, err = s.files("f", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 spooled value batch, got %v", paths)
+	}
+	got, err := readSpooledValues(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, vals) {
+		t.Errorf("expected %v, got %v", vals, got)
+	}
+
+	if paths, err := s.files("g", ""); err != nil || len(paths) != 0 {
+		t.Errorf("expected no batches for unknown frame, got %v, %v", paths, err)
+	}
+}
+
+// flakyImporter imports bits into a slice, or fails while down is set.
+type flakyImporter struct {
+	mu    sync.Mutex
+	down  bool
+	calls int
+	bits  []pcli.Bit
+}
+
+func (f *flakyImporter) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyImporter) importBits(fram *pcli.Frame, it pcli.BitIterator) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.down {
+		return errors.New("connection refused")
+	}
+	for bit, err := it.NextBit(); err != io.EOF; bit, err = it.NextBit() {
+		f.bits = append(f.bits, bit)
+	}
+	return nil
+}
+
+func TestIndexRetryAndSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imp := &flakyImporter{down: true}
+	idx := NewIndex()
+	idx.name = "retrytest"
+	idx.batchSize = 2
+	idx.specs = []FrameSpec{{Name: "f"}}
+	idx.importBits = imp.importBits
+	idx.retry = RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	idx.spool = newSpool(dir)
+	idx.startImports()
+
+	for col := uint64(0); col < 2; col++ {
+		if err := idx.AddBit("f", col, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.Flush(); err == nil {
+		t.Fatal("expected error from Flush with a batch left in the spool")
+	}
+	// three attempts for the batch, and one to replay it on Flush
+	if imp.calls != 4 {
+		t.Errorf("expected 4 import calls, got %v", imp.calls)
+	}
+	if paths, err := idx.spool.files("f", ""); err != nil || len(paths) != 1 {
+		t.Fatalf("expected one spooled batch, got %v, %v", paths, err)
+	}
+	snap := Stats.Frame("retrytest", "f", "").Snapshot()
+	if snap.Retries != 2 || snap.Errors != 1 || snap.Spooled != 2 || snap.Sent != 0 {
+		t.Errorf("unexpected stats after failure: %+v", snap)
+	}
+
+	imp.setDown(false)
+	if err := idx.AddBit("f", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the spooled batch is replayed before the new one is sent
+	exp := []pcli.Bit{{RowID: 1, ColumnID: 0}, {RowID: 1, ColumnID: 1}, {RowID: 1, ColumnID: 2}}
+	if !reflect.DeepEqual(imp.bits, exp) {
+		t.Errorf("expected %v, got %v", exp, imp.bits)
+	}
+	if paths, err := idx.spool.files("f", ""); err != nil || len(paths) != 0 {
+		t.Errorf("expected empty spool, got %v, %v", paths, err)
+	}
+	snap = Stats.Frame("retrytest", "f", "").Snapshot()
+	if snap.Spooled != 0 || snap.Sent != 3 {
+		t.Errorf("unexpected stats after replay: %+v", snap)
+	}
+}
+
+// flakyValImporter imports values into a slice, or fails while down is set.
+type flakyValImporter struct {
+	mu   sync.Mutex
+	down bool
+	vals []pcli.FieldValue
+}
+
+func (f *flakyValImporter) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyValImporter) importValues(fram *pcli.Frame, field string, it pcli.ValueIterator) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("connection refused")
+	}
+	for val, err := it.NextValue(); err != io.EOF; val, err = it.NextValue() {
+		f.vals = append(f.vals, val)
+	}
+	return nil
+}
+
+// TestIndexSpoolOrder checks that a newer value for a column is never
+// imported before an older one which was spooled: while the spool can't be
+// replayed, new batches are spooled behind it.
+func TestIndexSpoolOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imp := &flakyValImporter{down: true}
+	idx := NewIndex()
+	idx.name = "ordertest"
+	idx.batchSize = 1
+	idx.specs = []FrameSpec{{Name: "f", Fields: []FieldSpec{{Name: "v", Max: 10}}}}
+	idx.importBits = func(*pcli.Frame, pcli.BitIterator) error { return nil }
+	idx.importValues = imp.importValues
+	idx.retry = RetryPolicy{Attempts: 1}
+	idx.spool = newSpool(dir)
+	idx.startImports()
+
+	if err := idx.AddValue("f", "v", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Flush(); err == nil {
+		t.Fatal("expected error from Flush with a batch left in the spool")
+	}
+
+	// Pilosa comes back, but the spool still can't be replayed when the
+	// next value is sent, e.g. because the replay races with another
+	// failure. Simulate that by failing the first replay only.
+	imp.setDown(false)
+	calls := 0
+	idx.importValues = func(fram *pcli.Frame, field string, it pcli.ValueIterator) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection reset")
+		}
+		return imp.importValues(fram, field, it)
+	}
+	if err := idx.AddValue("f", "v", 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.AddValue("f", "v", 0, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []pcli.FieldValue{{ColumnID: 0, Value: 1}, {ColumnID: 0, Value: 2}, {ColumnID: 0, Value: 3}}
+	if !reflect.DeepEqual(imp.vals, exp) {
+		t.Errorf("expected values in order %v, got %v", exp, imp.vals)
+	}
+}
+
+// TestIndexImportErrorDrains checks that an import which fails keeps reading
+// its channel, so that AddBit and AddValue don't block once the channel is
+// full, and Close returns the error rather than deadlocking.
+func TestIndexImportErrorDrains(t *testing.T) {
+	idx := NewIndex()
+	idx.name = "draintest"
+	idx.batchSize = 1000
+	idx.specs = []FrameSpec{{Name: "f", Fields: []FieldSpec{{Name: "v", Max: 10}}}}
+	idx.importBits = func(*pcli.Frame, pcli.BitIterator) error { return errors.New("import failed") }
+	idx.importValues = func(*pcli.Frame, string, pcli.ValueIterator) error { return errors.New("import failed") }
+	idx.startImports()
+
+	n := 2 * cap(NewChanBitIterator())
+	done := make(chan error)
+	go func() {
+		for col := uint64(0); col < uint64(n); col++ {
+			if err := idx.AddBit("f", col, 1); err != nil {
+				done <- err
+				return
+			}
+			if err := idx.AddValue("f", "v", col, 1); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- idx.Close()
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected import errors from Close")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("adding bits after a failed import blocked")
+	}
+}
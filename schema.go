@@ -0,0 +1,210 @@
+package pdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pkg/errors"
+)
+
+// Schema declares an index and its frames. It is typically loaded from a
+// JSON file with LoadSchema, e.g.
+//
+//	{
+//	  "index": "taxi",
+//	  "frames": [
+//	    {"name": "cab_type", "cache-type": "ranked", "cache-size": 10},
+//	    {"name": "pickup", "time-quantum": "YMD"},
+//	    {"name": "fares", "fields": [{"name": "total", "min": 0, "max": 10000}]}
+//	  ]
+//	}
+type Schema struct {
+	Index  string      `json:"index"`
+	Frames []FrameSpec `json:"frames"`
+}
+
+// LoadSchema reads a Schema from a JSON file.
+func LoadSchema(filename string) (Schema, error) {
+	var schema Schema
+	f, err := os.Open(filename)
+	if err != nil {
+		return schema, errors.Wrap(err, "opening schema file")
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&schema)
+	if err != nil {
+		return schema, errors.Wrapf(err, "decoding schema file %v", filename)
+	}
+	return schema, schema.Validate()
+}
+
+// Validate checks that the schema names an index, and that frame and field
+// names are present and unique.
+func (s Schema) Validate() error {
+	if s.Index == "" {
+		return errors.New("schema has no index name")
+	}
+	frames := make(map[string]struct{}, len(s.Frames))
+	for _, frame := range s.Frames {
+		if frame.Name == "" {
+			return errors.New("schema has a frame with no name")
+		}
+		if _, ok := frames[frame.Name]; ok {
+			return errors.Errorf("duplicate frame in schema: %v", frame.Name)
+		}
+		frames[frame.Name] = struct{}{}
+		fields := make(map[string]struct{}, len(frame.Fields))
+		for _, field := range frame.Fields {
+			if _, ok := fields[field.Name]; ok || field.Name == "" {
+				return errors.Errorf("missing or duplicate field name in frame %v: '%v'", frame.Name, field.Name)
+			}
+			if field.Min > field.Max {
+				return errors.Errorf("field %v in frame %v has min > max", field.Name, frame.Name)
+			}
+			fields[field.Name] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// FrameNames returns the names of the frames in s.
+func (s Schema) FrameNames() []string {
+	names := make([]string, len(s.Frames))
+	for i, frame := range s.Frames {
+		names[i] = frame.Name
+	}
+	return names
+}
+
+// SchemaDiff describes what is missing from Pilosa compared to a Schema.
+type SchemaDiff struct {
+	Index        string
+	IndexMissing bool
+	Frames       []FrameSpec
+}
+
+// Empty reports whether nothing is missing.
+func (d SchemaDiff) Empty() bool {
+	return !d.IndexMissing && len(d.Frames) == 0
+}
+
+func (d SchemaDiff) String() string {
+	if d.Empty() {
+		return fmt.Sprintf("index %v is up to date\n", d.Index)
+	}
+	buf := &bytes.Buffer{}
+	if d.IndexMissing {
+		fmt.Fprintf(buf, "+ index %v\n", d.Index)
+	}
+	for _, frame := range d.Frames {
+		fmt.Fprintf(buf, "+ frame %v/%v", d.Index, frame.Name)
+		if frame.CacheType != "" {
+			fmt.Fprintf(buf, " cache-type=%v", frame.CacheType)
+		}
+		if frame.CacheSize != 0 {
+			fmt.Fprintf(buf, " cache-size=%v", frame.CacheSize)
+		}
+		if frame.InverseEnabled {
+			fmt.Fprint(buf, " inverse-enabled")
+		}
+		if frame.TimeQuantum != "" {
+			fmt.Fprintf(buf, " time-quantum=%v", frame.TimeQuantum)
+		}
+		if frame.Mutex {
+			fmt.Fprint(buf, " mutex")
+		}
+		for _, field := range frame.Fields {
+			fmt.Fprintf(buf, " field=%v[%v,%v]", field.Name, field.Min, field.Max)
+		}
+		fmt.Fprintln(buf)
+	}
+	return buf.String()
+}
+
+// Diff compares s against the schema of the Pilosa cluster which client is
+// connected to. Only the existence of the index and frames is compared - the
+// options of existing frames can't be changed, so they are not checked.
+func (s Schema) Diff(client *pcli.Client) (SchemaDiff, error) {
+	diff := SchemaDiff{Index: s.Index}
+	current, err := client.Schema()
+	if err != nil {
+		return diff, errors.Wrap(err, "getting schema from pilosa")
+	}
+	idx, ok := current.Indexes()[s.Index]
+	if !ok {
+		diff.IndexMissing = true
+		diff.Frames = append(diff.Frames, s.Frames...)
+		return diff, nil
+	}
+	existing := idx.Frames()
+	for _, frame := range s.Frames {
+		if _, ok := existing[frame.Name]; !ok {
+			diff.Frames = append(diff.Frames, frame)
+		}
+	}
+	return diff, nil
+}
+
+// Apply creates whatever is missing from Pilosa compared to s, and returns
+// what was created.
+func (s Schema) Apply(client *pcli.Client) (SchemaDiff, error) {
+	diff, err := s.Diff(client)
+	if err != nil {
+		return diff, err
+	}
+	if diff.Empty() {
+		return diff, nil
+	}
+	_, _, err = Schema{Index: diff.Index, Frames: diff.Frames}.Ensure(client)
+	return diff, err
+}
+
+// Ensure creates the index and frames of s if they don't already exist, and
+// returns them.
+func (s Schema) Ensure(client *pcli.Client) (*pcli.Index, map[string]*pcli.Frame, error) {
+	idx, err := pcli.NewIndex(s.Index, &pcli.IndexOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "making index")
+	}
+	err = client.EnsureIndex(idx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "ensuring index existence")
+	}
+	frames := make(map[string]*pcli.Frame, len(s.Frames))
+	for _, frame := range s.Frames {
+		frameOptions, err := frame.options()
+		if err != nil {
+			return nil, nil, err
+		}
+		fram, err := idx.Frame(frame.Name, frameOptions)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "making frame '%v'", frame.Name)
+		}
+		err = client.EnsureFrame(fram)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "creating frame '%v'", frame.Name)
+		}
+		frames[frame.Name] = fram
+	}
+	return idx, frames, nil
+}
+
+// options converts fs to go-pilosa frame options.
+func (fs FrameSpec) options() (*pcli.FrameOptions, error) {
+	frameOptions := &pcli.FrameOptions{
+		CacheType:      fs.CacheType,
+		CacheSize:      fs.CacheSize,
+		InverseEnabled: fs.InverseEnabled,
+		TimeQuantum:    fs.TimeQuantum,
+	}
+	for _, field := range fs.Fields {
+		err := frameOptions.AddIntField(field.Name, field.Min, field.Max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "adding int field %v", field)
+		}
+	}
+	return frameOptions, nil
+}
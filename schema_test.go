@@ -0,0 +1,69 @@
+package pdk
+
+import (
+	"reflect"
+	"testing"
+
+	pcli "github.com/pilosa/go-pilosa"
+)
+
+func TestLoadSchema(t *testing.T) {
+	f := mustWriteAndOpenFile(t, []byte(`{
+  "index": "taxi",
+  "frames": [
+    {"name": "cab_type", "cache-type": "ranked", "cache-size": 10, "mutex": true},
+    {"name": "pickup", "time-quantum": "YMD", "inverse-enabled": true},
+    {"name": "fares", "fields": [{"name": "total", "min": 0, "max": 10000}]}
+  ]
+}`))
+	schema, err := LoadSchema(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := Schema{
+		Index: "taxi",
+		Frames: []FrameSpec{
+			{Name: "cab_type", CacheType: pcli.CacheTypeRanked, CacheSize: 10, Mutex: true},
+			{Name: "pickup", TimeQuantum: pcli.TimeQuantumYearMonthDay, InverseEnabled: true},
+			NewFieldsFrameSpec("fares", FieldSpec{Name: "total", Min: 0, Max: 10000}),
+		},
+	}
+	if !reflect.DeepEqual(schema, exp) {
+		t.Fatalf("expected %v, got %v", exp, schema)
+	}
+	if names := schema.FrameNames(); !reflect.DeepEqual(names, []string{"cab_type", "pickup", "fares"}) {
+		t.Fatalf("unexpected frame names: %v", names)
+	}
+
+	diff := SchemaDiff{Index: "taxi", IndexMissing: true, Frames: schema.Frames}
+	expDiff := `+ index taxi
++ frame taxi/cab_type cache-type=ranked cache-size=10 mutex
++ frame taxi/pickup inverse-enabled time-quantum=YMD
++ frame taxi/fares field=total[0,10000]
+`
+	if diff.String() != expDiff {
+		t.Fatalf("expected diff:\n%v\ngot:\n%v", expDiff, diff)
+	}
+	if (SchemaDiff{Index: "taxi"}).String() != "index taxi is up to date\n" {
+		t.Fatalf("unexpected empty diff: %v", SchemaDiff{Index: "taxi"})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []Schema{
+		{Frames: []FrameSpec{{Name: "a"}}},
+		{Index: "i", Frames: []FrameSpec{{Name: ""}}},
+		{Index: "i", Frames: []FrameSpec{{Name: "a"}, {Name: "a"}}},
+		{Index: "i", Frames: []FrameSpec{NewFieldsFrameSpec("a", FieldSpec{Name: "f"}, FieldSpec{Name: "f"})}},
+		{Index: "i", Frames: []FrameSpec{NewFieldFrameSpec("a", 10, 0)}},
+	}
+	for i, schema := range tests {
+		if err := schema.Validate(); err == nil {
+			t.Errorf("test %d: expected error validating %v", i, schema)
+		}
+	}
+	ok := Schema{Index: "i", Frames: []FrameSpec{NewFieldFrameSpec("a", 0, 10), NewRankedFrameSpec("b", 10)}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
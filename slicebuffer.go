@@ -0,0 +1,166 @@
+package pdk
+
+import (
+	"sort"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pilosa/pilosa"
+)
+
+// bitBuffer groups the bits for a frame by slice, so that each batch sent to
+// Pilosa only touches the nodes which own one slice, rather than fanning out
+// to every node which owns a slice of whatever columns arrived together. A
+// slice's bits are sent once there are size of them, or, if more than limit
+// bits are buffered in total, the biggest slice is sent to make room.
+type bitBuffer struct {
+	size, limit int
+	slices      map[uint64][]pcli.Bit
+	n           int
+}
+
+func newBitBuffer(size, limit uint) *bitBuffer {
+	if limit < size {
+		limit = size
+	}
+	return &bitBuffer{size: int(size), limit: int(limit), slices: make(map[uint64][]pcli.Bit)}
+}
+
+// add buffers bit, and returns a batch which should be sent, if any.
+func (b *bitBuffer) add(bit pcli.Bit) []pcli.Bit {
+	slice := bit.ColumnID / pilosa.SliceWidth
+	b.slices[slice] = append(b.slices[slice], bit)
+	b.n++
+	if len(b.slices[slice]) >= b.size {
+		return b.take(slice)
+	}
+	if b.n > b.limit {
+		var biggest uint64
+		for s, bits := range b.slices {
+			if len(bits) > len(b.slices[biggest]) {
+				biggest = s
+			}
+		}
+		return b.take(biggest)
+	}
+	return nil
+}
+
+func (b *bitBuffer) take(slice uint64) []pcli.Bit {
+	bits := b.slices[slice]
+	delete(b.slices, slice)
+	b.n -= len(bits)
+	return bits
+}
+
+// drain empties the buffer, returning a batch per slice in slice order.
+func (b *bitBuffer) drain() [][]pcli.Bit {
+	slices := make([]uint64, 0, len(b.slices))
+	for slice := range b.slices {
+		slices = append(slices, slice)
+	}
+	sort.Slice(slices, func(i, j int) bool { return slices[i] < slices[j] })
+	batches := make([][]pcli.Bit, len(slices))
+	for i, slice := range slices {
+		batches[i] = b.take(slice)
+	}
+	return batches
+}
+
+// clearBuffer holds the bits waiting to be cleared in a frame, each once.
+type clearBuffer struct {
+	bits  []pcli.Bit
+	index map[bitKey]int
+}
+
+type bitKey struct {
+	row, col uint64
+}
+
+func newClearBuffer() *clearBuffer {
+	return &clearBuffer{index: make(map[bitKey]int)}
+}
+
+// add buffers bit if it isn't already, and returns the number buffered.
+func (b *clearBuffer) add(bit pcli.Bit) int {
+	key := bitKey{bit.RowID, bit.ColumnID}
+	if _, ok := b.index[key]; !ok {
+		b.index[key] = len(b.bits)
+		b.bits = append(b.bits, bit)
+	}
+	return len(b.bits)
+}
+
+// remove drops bit from the buffer, if it is there.
+func (b *clearBuffer) remove(bit pcli.Bit) {
+	key := bitKey{bit.RowID, bit.ColumnID}
+	n, ok := b.index[key]
+	if !ok {
+		return
+	}
+	last := b.bits[len(b.bits)-1]
+	b.bits[n] = last
+	b.index[bitKey{last.RowID, last.ColumnID}] = n
+	b.bits = b.bits[:len(b.bits)-1]
+	delete(b.index, key)
+}
+
+// take empties the buffer, returning the bits it held.
+func (b *clearBuffer) take() []pcli.Bit {
+	bits := b.bits
+	b.bits = nil
+	b.index = make(map[bitKey]int)
+	return bits
+}
+
+// valBuffer is bitBuffer for the values of a BSI field.
+type valBuffer struct {
+	size, limit int
+	slices      map[uint64][]pcli.FieldValue
+	n           int
+}
+
+func newValBuffer(size, limit uint) *valBuffer {
+	if limit < size {
+		limit = size
+	}
+	return &valBuffer{size: int(size), limit: int(limit), slices: make(map[uint64][]pcli.FieldValue)}
+}
+
+func (b *valBuffer) add(val pcli.FieldValue) []pcli.FieldValue {
+	slice := val.ColumnID / pilosa.SliceWidth
+	b.slices[slice] = append(b.slices[slice], val)
+	b.n++
+	if len(b.slices[slice]) >= b.size {
+		return b.take(slice)
+	}
+	if b.n > b.limit {
+		var biggest uint64
+		for s, vals := range b.slices {
+			if len(vals) > len(b.slices[biggest]) {
+				biggest = s
+			}
+		}
+		return b.take(biggest)
+	}
+	return nil
+}
+
+func (b *valBuffer) take(slice uint64) []pcli.FieldValue {
+	vals := b.slices[slice]
+	delete(b.slices, slice)
+	b.n -= len(vals)
+	return vals
+}
+
+func (b *valBuffer) drain() [][]pcli.FieldValue {
+	slices := make([]uint64, 0, len(b.slices))
+	for slice := range b.slices {
+		slices = append(slices, slice)
+	}
+	sort.Slice(slices, func(i, j int) bool { return slices[i] < slices[j] })
+	batches := make([][]pcli.FieldValue, len(slices))
+	for i, slice := range slices {
+		batches[i] = b.take(slice)
+	}
+	return batches
+}
@@ -0,0 +1,74 @@
+package pdk
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	pcli "github.com/pilosa/go-pilosa"
+)
+
+func TestBitBuffer(t *testing.T) {
+	const w = 1048576
+	b := newBitBuffer(3, 4)
+	tests := []struct {
+		col uint64
+		exp []uint64 // columns of the batch sent, if any
+	}{
+		{0, nil},
+		{1, nil},
+		{w, nil},
+		{2, []uint64{0, 1, 2}}, // slice 0 is full
+		{2 * w, nil},
+		{2*w + 1, nil},
+		{3 * w, nil},
+		{4 * w, []uint64{2 * w, 2*w + 1}}, // over the limit, so the biggest slice is sent
+	}
+	for i, test := range tests {
+		var got []uint64
+		for _, bit := range b.add(pcli.Bit{RowID: 1, ColumnID: test.col}) {
+			got = append(got, bit.ColumnID)
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("test %d: expected batch %v, got %v", i, test.exp, got)
+		}
+	}
+	var got [][]uint64
+	for _, batch := range b.drain() {
+		var cols []uint64
+		for _, bit := range batch {
+			cols = append(cols, bit.ColumnID)
+		}
+		got = append(got, cols)
+	}
+	if exp := [][]uint64{{w}, {3 * w}, {4 * w}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected drained batches %v, got %v", exp, got)
+	}
+	if b.n != 0 || len(b.drain()) != 0 {
+		t.Errorf("expected empty buffer after drain, got %v bits", b.n)
+	}
+}
+
+func TestClearBuffer(t *testing.T) {
+	b := newClearBuffer()
+	for _, col := range []uint64{1, 2, 3, 2, 4} {
+		b.add(pcli.Bit{RowID: 1, ColumnID: col})
+	}
+	b.remove(pcli.Bit{RowID: 1, ColumnID: 1})
+	b.remove(pcli.Bit{RowID: 2, ColumnID: 2}) // not buffered
+	if n := b.add(pcli.Bit{RowID: 1, ColumnID: 5}); n != 4 {
+		t.Errorf("expected 4 bits buffered, got %v", n)
+	}
+	b.remove(pcli.Bit{RowID: 1, ColumnID: 3})
+	var got []uint64
+	for _, bit := range b.take() {
+		got = append(got, bit.ColumnID)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if exp := []uint64{2, 4, 5}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if bits := b.take(); len(bits) != 0 {
+		t.Errorf("expected empty buffer after take, got %v", bits)
+	}
+}
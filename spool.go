@@ -0,0 +1,141 @@
+package pdk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pcli "github.com/pilosa/go-pilosa"
+	"github.com/pkg/errors"
+)
+
+const (
+	spoolExt      = ".spool"
+	spoolBitSize  = 24
+	spoolValSize  = 16
+	spoolBitsDir  = "bits"
+	spoolFieldDir = "field-"
+)
+
+// spool stores batches which could not be imported in a local directory so
+// that they can be replayed later. Each batch is one file of fixed size
+// little-endian records, under <dir>/<frame>/bits or
+// <dir>/<frame>/field-<field>. File names sort in the order they were
+// written.
+type spool struct {
+	dir string
+	seq uint64
+}
+
+func newSpool(dir string) *spool {
+	return &spool{dir: dir}
+}
+
+func (s *spool) path(frame, field string) string {
+	if field == "" {
+		return filepath.Join(s.dir, frame, spoolBitsDir)
+	}
+	return filepath.Join(s.dir, frame, spoolFieldDir+field)
+}
+
+// write stores data as a new batch file in the directory for frame and field.
+// The file is written under a temporary name and renamed, so a partially
+// written batch is never replayed.
+func (s *spool) write(frame, field string, data []byte) (string, error) {
+	dir := s.path(frame, field)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating spool directory")
+	}
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), atomic.AddUint64(&s.seq, 1), spoolExt)
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrap(err, "writing spool file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrap(err, "renaming spool file")
+	}
+	return path, nil
+}
+
+// files returns the spooled batches for frame and field, oldest first.
+func (s *spool) files(frame, field string) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.path(frame, field))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading spool directory")
+	}
+	var paths []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), spoolExt) {
+			paths = append(paths, filepath.Join(s.path(frame, field), info.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func (s *spool) writeBits(frame string, bits []pcli.Bit) (string, error) {
+	data := make([]byte, len(bits)*spoolBitSize)
+	for i, bit := range bits {
+		b := data[i*spoolBitSize:]
+		binary.LittleEndian.PutUint64(b, bit.RowID)
+		binary.LittleEndian.PutUint64(b[8:], bit.ColumnID)
+		binary.LittleEndian.PutUint64(b[16:], uint64(bit.Timestamp))
+	}
+	return s.write(frame, "", data)
+}
+
+func readSpooledBits(path string) ([]pcli.Bit, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading spool file")
+	}
+	if len(data)%spoolBitSize != 0 {
+		return nil, errors.Errorf("spool file %v is truncated", path)
+	}
+	bits := make([]pcli.Bit, len(data)/spoolBitSize)
+	for i := range bits {
+		b := data[i*spoolBitSize:]
+		bits[i] = pcli.Bit{
+			RowID:     binary.LittleEndian.Uint64(b),
+			ColumnID:  binary.LittleEndian.Uint64(b[8:]),
+			Timestamp: int64(binary.LittleEndian.Uint64(b[16:])),
+		}
+	}
+	return bits, nil
+}
+
+func (s *spool) writeValues(frame, field string, vals []pcli.FieldValue) (string, error) {
+	data := make([]byte, len(vals)*spoolValSize)
+	for i, val := range vals {
+		b := data[i*spoolValSize:]
+		binary.LittleEndian.PutUint64(b, val.ColumnID)
+		binary.LittleEndian.PutUint64(b[8:], uint64(val.Value))
+	}
+	return s.write(frame, field, data)
+}
+
+func readSpooledValues(path string) ([]pcli.FieldValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading spool file")
+	}
+	if len(data)%spoolValSize != 0 {
+		return nil, errors.Errorf("spool file %v is truncated", path)
+	}
+	vals := make([]pcli.FieldValue, len(data)/spoolValSize)
+	for i := range vals {
+		b := data[i*spoolValSize:]
+		vals[i].ColumnID = binary.LittleEndian.Uint64(b)
+		vals[i].Value = binary.LittleEndian.Uint64(b[8:])
+	}
+	return vals, nil
+}
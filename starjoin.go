@@ -0,0 +1,253 @@
+package pdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StarSchema describes a fact table and the dimension tables which are joined
+// to it by foreign key. It is typically loaded from a JSON file with
+// LoadStarSchema. All column positions are zero based.
+type StarSchema struct {
+	// Delimiter separates the fields of the fact table. Defaults to "|".
+	Delimiter string `json:"delimiter"`
+	// Columns names fact table columns so that they may be addressed in the
+	// joined row.
+	Columns    []ColumnSpec    `json:"columns"`
+	Dimensions []DimensionSpec `json:"dimensions"`
+}
+
+// DimensionSpec describes a single dimension table.
+type DimensionSpec struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// Delimiter separates the fields of the dimension table. Defaults to the
+	// fact table's delimiter.
+	Delimiter string `json:"delimiter"`
+	// Key is the column of the dimension table holding its primary key.
+	Key int `json:"key"`
+	// FactKey is the column of the fact table holding the foreign key.
+	FactKey int `json:"fact-key"`
+	// Attributes are the dimension columns which are denormalized into each
+	// fact row.
+	Attributes []ColumnSpec `json:"attributes"`
+	// SizeHint is the expected number of rows in the table.
+	SizeHint int `json:"size-hint"`
+}
+
+// ColumnSpec names a column by position.
+type ColumnSpec struct {
+	Name   string `json:"name"`
+	Column int    `json:"column"`
+}
+
+// LoadStarSchema reads a StarSchema from a JSON file. Relative dimension file
+// paths are resolved against dir if it is not empty.
+func LoadStarSchema(filename, dir string) (StarSchema, error) {
+	var schema StarSchema
+	f, err := os.Open(filename)
+	if err != nil {
+		return schema, errors.Wrap(err, "opening star schema file")
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&schema)
+	if err != nil {
+		return schema, errors.Wrapf(err, "decoding star schema file %v", filename)
+	}
+	if dir != "" {
+		for i, dim := range schema.Dimensions {
+			if !strings.HasPrefix(dim.File, "/") {
+				schema.Dimensions[i].File = dir + "/" + dim.File
+			}
+		}
+	}
+	return schema, schema.Validate()
+}
+
+// Validate checks that every dimension has a name and a file, and that no
+// column position is negative.
+func (s StarSchema) Validate() error {
+	for _, col := range s.Columns {
+		if col.Column < 0 {
+			return errors.Errorf("column %v has negative position %v", col.Name, col.Column)
+		}
+	}
+	for _, dim := range s.Dimensions {
+		if dim.Name == "" || dim.File == "" {
+			return errors.Errorf("dimension with no name or file: '%v' '%v'", dim.Name, dim.File)
+		}
+		if dim.Key < 0 {
+			return errors.Errorf("dimension %v has negative key column %v", dim.Name, dim.Key)
+		}
+		if dim.FactKey < 0 {
+			return errors.Errorf("dimension %v has negative fact-key column %v", dim.Name, dim.FactKey)
+		}
+		for _, attr := range dim.Attributes {
+			if attr.Column < 0 {
+				return errors.Errorf("attribute %v of dimension %v has negative column %v", attr.Name, dim.Name, attr.Column)
+			}
+		}
+	}
+	return nil
+}
+
+// StarJoin enriches fact rows with attributes from dimension tables which are
+// held in memory. A joined row consists of the attributes of each dimension
+// in the order they were declared, followed by the fact row's fields. Fields
+// returns the position of each named column within a joined row so that
+// BitMappers can address them.
+type StarJoin struct {
+	delim  string
+	dims   []dimension
+	width  int
+	fields map[string]int
+}
+
+type dimension struct {
+	DimensionSpec
+	rows map[string][]string
+}
+
+// NewStarJoin loads all of the dimension tables in schema concurrently.
+func NewStarJoin(schema StarSchema) (*StarJoin, error) {
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	sj := &StarJoin{
+		delim:  schema.Delimiter,
+		dims:   make([]dimension, len(schema.Dimensions)),
+		fields: make(map[string]int),
+	}
+	if sj.delim == "" {
+		sj.delim = "|"
+	}
+	for _, dim := range schema.Dimensions {
+		for _, attr := range dim.Attributes {
+			sj.fields[dim.Name+"."+attr.Name] = sj.width
+			sj.width++
+		}
+	}
+	for _, col := range schema.Columns {
+		sj.fields[col.Name] = sj.width + col.Column
+	}
+
+	errs := make(Errors, len(schema.Dimensions))
+	wg := sync.WaitGroup{}
+	for i, spec := range schema.Dimensions {
+		if spec.Delimiter == "" {
+			spec.Delimiter = sj.delim
+		}
+		sj.dims[i] = dimension{DimensionSpec: spec}
+		wg.Add(1)
+		go func(dim *dimension, i int) {
+			defer wg.Done()
+			errs[i] = dim.load()
+		}(&sj.dims[i], i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sj, nil
+}
+
+func (d *dimension) load() error {
+	f, err := os.Open(d.File)
+	if err != nil {
+		return errors.Wrapf(err, "opening dimension table %v", d.Name)
+	}
+	defer f.Close()
+	d.rows = make(map[string][]string, d.SizeHint)
+	scanner := bufio.NewScanner(NewDecompressReader(f, d.File))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), d.Delimiter)
+		attrs, err := d.project(fields)
+		if err != nil {
+			log.Println(&SourceError{Pos: Position{File: d.File, Line: line}, Err: err})
+			continue
+		}
+		d.rows[fields[d.Key]] = attrs
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "reading dimension table %v", d.Name)
+	}
+	return nil
+}
+
+// project returns the attribute columns of a dimension row.
+func (d *dimension) project(fields []string) ([]string, error) {
+	if d.Key >= len(fields) {
+		return nil, errors.Errorf("key column %v out of range for %v fields", d.Key, len(fields))
+	}
+	attrs := make([]string, len(d.Attributes))
+	for i, attr := range d.Attributes {
+		if attr.Column >= len(fields) {
+			return nil, errors.Errorf("attribute %v column %v out of range for %v fields", attr.Name, attr.Column, len(fields))
+		}
+		attrs[i] = fields[attr.Column]
+	}
+	return attrs, nil
+}
+
+// Fields returns a map of column name to position within a joined row.
+// Dimension attributes are named "<dimension>.<attribute>".
+func (sj *StarJoin) Fields() map[string]int {
+	fields := make(map[string]int, len(sj.fields))
+	for name, i := range sj.fields {
+		fields[name] = i
+	}
+	return fields
+}
+
+// Join prepends the attributes of each dimension to the fact row. It returns
+// an error if any foreign key can't be resolved.
+func (sj *StarJoin) Join(fact []string) ([]string, error) {
+	joined := make([]string, 0, sj.width+len(fact))
+	for _, dim := range sj.dims {
+		if dim.FactKey >= len(fact) {
+			return nil, errors.Errorf("%v foreign key column %v out of range for %v fields", dim.Name, dim.FactKey, len(fact))
+		}
+		attrs, ok := dim.rows[fact[dim.FactKey]]
+		if !ok {
+			return nil, errors.Errorf("FK lookup fail: no %v row with key '%v'", dim.Name, fact[dim.FactKey])
+		}
+		joined = append(joined, attrs...)
+	}
+	return append(joined, fact...), nil
+}
+
+// JoinLine splits a delimited fact row and joins it.
+func (sj *StarJoin) JoinLine(line string) ([]string, error) {
+	return sj.Join(strings.Split(line, sj.delim))
+}
+
+// JoinRows reads delimited (and possibly compressed) fact rows from r, joins each one, and sends the
+// result on rows. Rows which fail to join are logged with their position and
+// skipped. name is used to report positions.
+func (sj *StarJoin) JoinRows(r io.Reader, name string, rows chan<- []string) error {
+	scanner := bufio.NewScanner(NewDecompressReader(r, name))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		joined, err := sj.JoinLine(scanner.Text())
+		if err != nil {
+			log.Println(&SourceError{Pos: Position{File: name, Line: line}, Err: err})
+			continue
+		}
+		rows <- joined
+	}
+	return errors.Wrapf(scanner.Err(), "reading fact table %v", name)
+}
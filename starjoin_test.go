@@ -0,0 +1,101 @@
+package pdk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStarJoin(t *testing.T) {
+	cust := mustWriteAndOpenFile(t, []byte(`
+1|Customer#000000001|j5JsirBM9P|MOROCCO  2|MOROCCO|AFRICA|25-918-335-1736|BUILDING|
+2|Customer#000000002|487LW1dovn6Q4dMVym|JORDAN   0|JORDAN|MIDDLE EAST|23-679-861-2259|AUTOMOBILE|
+`[1:]))
+	date := mustWriteAndOpenFile(t, []byte(`
+19920101,January,1992
+19920102,January,1992
+bad
+`[1:]))
+	schema := StarSchema{
+		Columns: []ColumnSpec{{Name: "quantity", Column: 2}},
+		Dimensions: []DimensionSpec{
+			{
+				Name:       "c",
+				File:       cust.Name(),
+				Key:        0,
+				FactKey:    0,
+				Attributes: []ColumnSpec{{Name: "city", Column: 3}, {Name: "region", Column: 5}},
+			},
+			{
+				Name:       "d",
+				File:       date.Name(),
+				Delimiter:  ",",
+				Key:        0,
+				FactKey:    1,
+				Attributes: []ColumnSpec{{Name: "year", Column: 2}},
+			},
+		},
+	}
+	sj, err := NewStarJoin(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expFields := map[string]int{"c.city": 0, "c.region": 1, "d.year": 2, "quantity": 5}
+	if !reflect.DeepEqual(sj.Fields(), expFields) {
+		t.Fatalf("expected fields %v, got %v", expFields, sj.Fields())
+	}
+
+	rows := make(chan []string, 10)
+	facts := "2|19920102|17|\n3|19920101|5|\n1|19920101|4|\n"
+	err = sj.JoinRows(strings.NewReader(facts), "lineorder", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(rows)
+	var joined [][]string
+	for row := range rows {
+		joined = append(joined, row)
+	}
+	exp := [][]string{
+		{"JORDAN   0", "MIDDLE EAST", "1992", "2", "19920102", "17", ""},
+		{"MOROCCO  2", "AFRICA", "1992", "1", "19920101", "4", ""},
+	}
+	if !reflect.DeepEqual(joined, exp) {
+		t.Fatalf("expected joined rows %v, got %v", exp, joined)
+	}
+}
+
+func TestLoadStarSchema(t *testing.T) {
+	f := mustWriteAndOpenFile(t, []byte(`{
+	"columns": [{"name": "quantity", "column": 2}],
+	"dimensions": [
+		{"name": "c", "file": "customer.tbl", "key": 0, "fact-key": 0, "attributes": [{"name": "city", "column": 3}]},
+		{"name": "d", "file": "/data/date.tbl", "delimiter": ",", "key": 0, "fact-key": 1}
+	]
+}`))
+	schema, err := LoadStarSchema(f.Name(), "ssb1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Dimensions[0].File != "ssb1/customer.tbl" || schema.Dimensions[1].File != "/data/date.tbl" {
+		t.Fatalf("unexpected dimension files: %v, %v", schema.Dimensions[0].File, schema.Dimensions[1].File)
+	}
+	if schema.Dimensions[0].Attributes[0] != (ColumnSpec{Name: "city", Column: 3}) || schema.Dimensions[1].Delimiter != "," {
+		t.Fatalf("unexpected dimensions: %+v", schema.Dimensions)
+	}
+
+	invalid := []string{
+		`{"columns": [{"name": "quantity", "column": -1}]}`,
+		`{"dimensions": [{"name": "c", "file": "c.tbl", "key": -1}]}`,
+		`{"dimensions": [{"name": "c", "file": "c.tbl", "fact-key": -2}]}`,
+		`{"dimensions": [{"name": "c", "file": "c.tbl", "attributes": [{"name": "city", "column": -3}]}]}`,
+		`{"dimensions": [{"file": "c.tbl"}]}`,
+	}
+	for _, data := range invalid {
+		f := mustWriteAndOpenFile(t, []byte(data))
+		if _, err := LoadStarSchema(f.Name(), ""); err == nil {
+			t.Errorf("expected error loading %v", data)
+		}
+	}
+}
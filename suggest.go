@@ -0,0 +1,84 @@
+package pdk
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestScan is the most keys suggestKeys looks at, so that a typo in a
+// query against a huge frame doesn't scan the whole frame.
+const maxSuggestScan = 100000
+
+// maxSuggestions is the most keys suggestKeys returns.
+const maxSuggestions = 3
+
+// suggestKeys returns the keys in frame which are closest to key, ignoring
+// case, for use in an error message.
+func suggestKeys(kr KeyRanger, frame, key string) []string {
+	type match struct {
+		key  string
+		dist int
+	}
+	target := []rune(strings.ToLower(key))
+	limit := len(target) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	var matches []match
+	scanned := 0
+	err := kr.RangeKeys(frame, func(k string) bool {
+		if d := editDistance(target, []rune(strings.ToLower(k))); d <= limit {
+			matches = append(matches, match{k, d})
+		}
+		scanned++
+		return scanned < maxSuggestScan
+	})
+	if err != nil {
+		return nil
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].key < matches[j].key
+	})
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+	keys := make([]string, len(matches))
+	for i, m := range matches {
+		keys[i] = m.key
+	}
+	return keys
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,143 @@
+package pdk
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ThrottleConfig limits the rate at which an Indexer sends to Pilosa, so that
+// an import doesn't starve queries on a shared cluster. Zero values mean no
+// limit.
+type ThrottleConfig struct {
+	// BitsPerSecond limits the number of bits and values sent per second.
+	BitsPerSecond float64
+	// RequestsPerSecond limits the number of import requests per second.
+	RequestsPerSecond float64
+	// TargetLatency enables adaptive throttling: while imports take longer
+	// than this, a delay before each request is doubled (up to
+	// MaxAdaptiveDelay), and while they are faster it is halved again.
+	TargetLatency time.Duration
+}
+
+// MaxAdaptiveDelay is the longest delay which adaptive throttling waits
+// before each request.
+const MaxAdaptiveDelay = 30 * time.Second
+
+// minAdaptiveDelay is the delay adaptive throttling starts from, and below
+// which the delay is dropped.
+const minAdaptiveDelay = 10 * time.Millisecond
+
+// tokenBucket is a token bucket which refills at rate tokens per second, up to
+// a second's worth. Requests for more tokens than are available are granted,
+// leaving the bucket in debt, and the caller waits until it would have been
+// refilled.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// take removes n tokens from the bucket, and returns how long the caller must
+// wait before using them.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	} else {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle applies a ThrottleConfig to the requests of an Indexer. A nil
+// *throttle doesn't limit anything.
+type throttle struct {
+	mu       sync.Mutex
+	bits     *tokenBucket
+	requests *tokenBucket
+	target   time.Duration
+	delay    time.Duration
+
+	sleep func(time.Duration)
+	now   func() time.Time
+}
+
+func newThrottle(conf ThrottleConfig) *throttle {
+	if conf == (ThrottleConfig{}) {
+		return nil
+	}
+	t := &throttle{target: conf.TargetLatency, sleep: time.Sleep, now: time.Now}
+	if conf.BitsPerSecond > 0 {
+		t.bits = &tokenBucket{rate: conf.BitsPerSecond}
+	}
+	if conf.RequestsPerSecond > 0 {
+		t.requests = &tokenBucket{rate: conf.RequestsPerSecond}
+	}
+	return t
+}
+
+// wait blocks until a request sending n bits or values may be made.
+func (t *throttle) wait(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	now := t.now()
+	wait := t.delay
+	if t.bits != nil {
+		if w := t.bits.take(float64(n), now); w > wait {
+			wait = w
+		}
+	}
+	if t.requests != nil {
+		if w := t.requests.take(1, now); w > wait {
+			wait = w
+		}
+	}
+	t.mu.Unlock()
+	if wait > 0 {
+		t.sleep(wait)
+	}
+}
+
+// observe adjusts the adaptive delay for the latency of a request.
+func (t *throttle) observe(latency time.Duration) {
+	if t == nil || t.target == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if latency > t.target {
+		if t.delay == 0 {
+			log.Printf("import latency %v is above target %v, slowing down", latency, t.target)
+			t.delay = minAdaptiveDelay
+		} else if t.delay *= 2; t.delay > MaxAdaptiveDelay {
+			t.delay = MaxAdaptiveDelay
+		}
+		return
+	}
+	if t.delay /= 2; t.delay < minAdaptiveDelay {
+		t.delay = 0
+	}
+}
+
+// do waits for the throttle, calls fn to send n bits or values, and observes
+// how long it took.
+func (t *throttle) do(n int, fn func() error) error {
+	if t == nil {
+		return fn()
+	}
+	t.wait(n)
+	start := t.now()
+	err := fn()
+	t.observe(t.now().Sub(start))
+	return err
+}
@@ -0,0 +1,88 @@
+package pdk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := &tokenBucket{rate: 10}
+	tests := []struct {
+		n    float64
+		at   time.Duration
+		wait time.Duration
+	}{
+		{5, 0, 0},
+		{10, 0, 500 * time.Millisecond},
+		{5, time.Second, 0},
+		{30, 10 * time.Second, 2 * time.Second}, // refills to at most a second's worth
+	}
+	for i, test := range tests {
+		if wait := b.take(test.n, start.Add(test.at)); wait != test.wait {
+			t.Errorf("test %d: expected wait %v, got %v", i, test.wait, wait)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	if th := newThrottle(ThrottleConfig{}); th != nil {
+		t.Fatalf("expected nil throttle without limits, got %+v", th)
+	}
+	// a nil throttle doesn't limit
+	var nilThrottle *throttle
+	if err := nilThrottle.do(10, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	var sleeps []time.Duration
+	now := time.Now()
+	th := newThrottle(ThrottleConfig{BitsPerSecond: 100, RequestsPerSecond: 1, TargetLatency: time.Second})
+	th.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	th.now = func() time.Time { return now }
+
+	th.wait(50)  // within both limits
+	th.wait(50)  // over the request limit
+	th.wait(250) // over both, the bit limit is longer
+	if exp := []time.Duration{time.Second, 2500 * time.Millisecond}; !reflect.DeepEqual(sleeps, exp) {
+		t.Errorf("expected sleeps %v, got %v", exp, sleeps)
+	}
+
+	latencies := []struct {
+		latency time.Duration
+		delay   time.Duration
+	}{
+		{time.Millisecond, 0},
+		{2 * time.Second, minAdaptiveDelay},
+		{2 * time.Second, 2 * minAdaptiveDelay},
+		{2 * time.Second, 4 * minAdaptiveDelay},
+		{time.Millisecond, 2 * minAdaptiveDelay},
+		{time.Millisecond, minAdaptiveDelay},
+		{time.Millisecond, 0},
+	}
+	for i, test := range latencies {
+		th.observe(test.latency)
+		if th.delay != test.delay {
+			t.Errorf("test %d: expected delay %v, got %v", i, test.delay, th.delay)
+		}
+	}
+	th.delay = MaxAdaptiveDelay
+	th.observe(time.Minute)
+	if th.delay != MaxAdaptiveDelay {
+		t.Errorf("expected delay capped at %v, got %v", MaxAdaptiveDelay, th.delay)
+	}
+
+	// do times fn with the throttle's clock
+	th.delay = 0
+	err := th.do(1, func() error {
+		now = now.Add(2 * time.Second)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.delay != minAdaptiveDelay {
+		t.Errorf("expected delay %v after a slow request, got %v", minAdaptiveDelay, th.delay)
+	}
+}
@@ -0,0 +1,253 @@
+package jsonlines
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pilosa/pdk"
+	"github.com/pkg/errors"
+)
+
+// Field maps the values found at Path in each JSON object to rows of Frame.
+type Field struct {
+	Path  string
+	Frame string
+	// Int means that the values are row IDs. Otherwise each distinct value
+	// is given a row ID by the translator, and the proxy started after the
+	// import translates it back.
+	Int bool
+}
+
+// ParseField parses a field given as "path=frame", or "path=frame:int" for
+// values which are row IDs. "path=frame:string" is the same as "path=frame".
+func ParseField(s string) (Field, error) {
+	eq := strings.LastIndex(s, "=")
+	if eq <= 0 || eq == len(s)-1 {
+		return Field{}, errors.Errorf("field '%v' is not of the form path=frame[:type]", s)
+	}
+	f := Field{Path: s[:eq], Frame: s[eq+1:]}
+	if colon := strings.Index(f.Frame, ":"); colon >= 0 {
+		switch typ := f.Frame[colon+1:]; typ {
+		case "int":
+			f.Int = true
+		case "string":
+		default:
+			return Field{}, errors.Errorf("unknown type '%v' in field '%v', expected int or string", typ, s)
+		}
+		f.Frame = f.Frame[:colon]
+	}
+	if f.Frame == "" {
+		return Field{}, errors.Errorf("no frame in field '%v'", s)
+	}
+	return f, nil
+}
+
+// Main imports files of newline delimited JSON objects, one column per
+// object, setting a bit for each value found at the path of each Field.
+type Main struct {
+	// Files are read in order, with column IDs continuing from one file to
+	// the next. Standard input is read if there are none.
+	Files       []string
+	Fields      []Field
+	Index       string
+	Hosts       []string
+	Backend     string
+	BufferSize  int
+	OutputDir   string
+	SpoolDir    string
+	Throttle    pdk.ThrottleConfig
+	MaxBuffered int
+	// MappingDir holds the translator's mapping of string values to row
+	// IDs, so that later imports into the same index reuse them.
+	MappingDir string
+	// ProxyBind is where the proxy translating queries is started after
+	// importing into Pilosa with any of the Pilosa backends. Empty to exit
+	// instead. There is no proxy for the file and memory backends, which
+	// don't write to Pilosa.
+	ProxyBind  string
+	ProxyCache pdk.QueryCacheConfig
+
+	trans *pdk.LevelTranslator
+	index pdk.Indexer
+	col   uint64
+
+	recordsRead    uint64
+	recordsSkipped uint64
+}
+
+func NewMain() *Main {
+	return &Main{
+		Index:      "jsonlines",
+		MappingDir: "jsonlines-mapping",
+		ProxyBind:  "localhost:3456",
+	}
+}
+
+func (m *Main) Run() (err error) {
+	if len(m.Fields) == 0 {
+		return errors.New("no fields given")
+	}
+	bms, frames, err := m.setupMappers()
+	if err != nil {
+		return err
+	}
+
+	m.index, err = pdk.NewIndexer(pdk.IndexerConfig{
+		Backend:     m.Backend,
+		Hosts:       m.Hosts,
+		BatchSize:   m.BufferSize,
+		MaxBuffered: m.MaxBuffered,
+		OutputDir:   m.OutputDir,
+		SpoolDir:    m.SpoolDir,
+		Throttle:    m.Throttle,
+	}, pdk.Schema{Index: m.Index, Frames: frames})
+	if err != nil {
+		return errors.Wrap(err, "setting up indexer")
+	}
+	pdk.Stats.RegisterCounter("jsonlines_records_read_total", "JSON objects read and mapped.", func() int64 { return int64(atomic.LoadUint64(&m.recordsRead)) })
+	pdk.Stats.RegisterCounter("jsonlines_records_skipped_total", "Lines which weren't valid JSON objects.", func() int64 { return int64(atomic.LoadUint64(&m.recordsSkipped)) })
+
+	paths := make([]string, len(m.Fields))
+	for i, f := range m.Fields {
+		paths[i] = f.Path
+	}
+	if len(m.Files) == 0 {
+		err = m.importFrom(os.Stdin, "stdin", paths, bms)
+	}
+	for _, name := range m.Files {
+		if err != nil {
+			break
+		}
+		var f *os.File
+		f, err = os.Open(name)
+		if err != nil {
+			err = errors.Wrap(err, "opening file")
+			break
+		}
+		err = m.importFrom(f, name, paths, bms)
+		f.Close()
+	}
+	if cerr := m.index.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr, "closing indexer")
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %v records, skipped %v", atomic.LoadUint64(&m.recordsRead), atomic.LoadUint64(&m.recordsSkipped))
+
+	// frames of int fields need no translation, so can be queried in
+	// Pilosa directly. The go-pilosa, ctl and roaring backends all create an
+	// *pdk.Index.
+	if _, ok := m.index.(*pdk.Index); !ok || m.ProxyBind == "" || m.trans == nil {
+		if m.trans != nil {
+			return errors.Wrap(m.trans.Close(), "closing translator")
+		}
+		return nil
+	}
+	log.Println("starting proxy")
+	return pdk.StartProxy(pdk.ProxyConfig{
+		Bind:       m.ProxyBind,
+		Hosts:      m.Hosts,
+		Translator: m.trans,
+		Cache:      m.ProxyCache,
+	})
+}
+
+// setupMappers creates a BitMapper and a frame for each field, and the
+// translator for the frames of string fields.
+func (m *Main) setupMappers() ([]pdk.BitMapper, []pdk.FrameSpec, error) {
+	types := make(map[string]bool)
+	var frames []pdk.FrameSpec
+	var keyFrames []string
+	for _, f := range m.Fields {
+		isInt, seen := types[f.Frame]
+		if seen && isInt != f.Int {
+			return nil, nil, errors.Errorf("frame %v is given both int and string fields", f.Frame)
+		}
+		if seen {
+			continue
+		}
+		types[f.Frame] = f.Int
+		frames = append(frames, pdk.NewRankedFrameSpec(f.Frame, 50000))
+		if !f.Int {
+			keyFrames = append(keyFrames, f.Frame)
+		}
+	}
+	if len(keyFrames) > 0 {
+		trans, err := pdk.NewLevelTranslator(m.MappingDir, keyFrames...)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "opening translator")
+		}
+		m.trans = trans
+	}
+
+	bms := make([]pdk.BitMapper, len(m.Fields))
+	for i, f := range m.Fields {
+		bm := pdk.BitMapper{Frame: f.Frame, Fields: []int{i}}
+		if f.Int {
+			bm.Mapper = pdk.IntMapper{Min: 0, Max: math.MaxInt64}
+			bm.Parsers = []pdk.Parser{pdk.IntParser{}}
+		} else {
+			bm.Mapper = keyMapper{trans: m.trans, frame: f.Frame}
+			bm.Parsers = []pdk.Parser{pdk.StringParser{}}
+		}
+		bms[i] = bm
+	}
+	return bms, frames, nil
+}
+
+// importFrom reads JSON objects from r, giving each the next column ID.
+// Lines which aren't JSON objects, and values which can't be mapped, are
+// logged and skipped.
+func (m *Main) importFrom(r io.Reader, name string, paths []string, bms []pdk.BitMapper) error {
+	src, err := pdk.NewJSONSource(r, name, paths...)
+	if err != nil {
+		return errors.Wrap(err, "creating json source")
+	}
+	for {
+		rec, err := src.Record()
+		if err == io.EOF {
+			return nil
+		}
+		if serr, ok := err.(*pdk.SourceError); ok {
+			log.Println(serr)
+			atomic.AddUint64(&m.recordsSkipped, 1)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, bm := range bms {
+			rows, err := bm.MultiRowIDs(rec.Values)
+			if err != nil {
+				log.Printf("%v: mapping frame %v: %v", rec.Pos, bm.Frame, err)
+				continue
+			}
+			for _, row := range rows {
+				if err := m.index.AddBit(bm.Frame, m.col, uint64(row)); err != nil {
+					return errors.Wrapf(err, "adding bit at %v", rec.Pos)
+				}
+			}
+		}
+		m.col++
+		atomic.AddUint64(&m.recordsRead, 1)
+	}
+}
+
+// keyMapper maps a string to its row ID in a frame of the translator.
+type keyMapper struct {
+	trans *pdk.LevelTranslator
+	frame string
+}
+
+func (km keyMapper) ID(vals ...interface{}) ([]int64, error) {
+	id, err := km.trans.GetID(km.frame, vals[0])
+	if err != nil {
+		return nil, err
+	}
+	return []int64{int64(id)}, nil
+}
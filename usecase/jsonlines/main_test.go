@@ -0,0 +1,99 @@
+package jsonlines
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pilosa/pdk"
+)
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Field
+		err  bool
+	}{
+		{in: "user.name=name", want: Field{Path: "user.name", Frame: "name"}},
+		{in: "items[0].sku=sku:string", want: Field{Path: "items[0].sku", Frame: "sku"}},
+		{in: "age=age:int", want: Field{Path: "age", Frame: "age", Int: true}},
+		{in: "age", err: true},
+		{in: "=age", err: true},
+		{in: "age=", err: true},
+		{in: "age=:int", err: true},
+		{in: "age=age:float", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseField(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected error, got %+v", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: got %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := `{"user": {"name": "ann"}, "age": 31, "tags": ["a", "b"]}
+{"user": {"name": "bob"}, "age": 40, "tags": []}
+not json
+{"user": {"name": "ann"}, "age": "old", "tags": ["b"]}
+`
+	file := filepath.Join(dir, "data.json")
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	m.Backend = pdk.BackendMemory
+	m.MappingDir = filepath.Join(dir, "mapping")
+	m.Files = []string{file}
+	for _, s := range []string{"user.name=name", "age=age:int", "tags=tag"} {
+		f, err := ParseField(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Fields = append(m.Fields, f)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := m.index.(*pdk.MemIndex)
+	if got := idx.Row("name", 0); !reflect.DeepEqual(got, []uint64{0, 2}) {
+		t.Errorf("name ann: got columns %v", got)
+	}
+	if got := idx.Row("name", 1); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Errorf("name bob: got columns %v", got)
+	}
+	if got := idx.Row("age", 40); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Errorf("age 40: got columns %v", got)
+	}
+	if got := idx.Row("tag", 1); !reflect.DeepEqual(got, []uint64{0, 2}) {
+		t.Errorf("tag b: got columns %v", got)
+	}
+	if m.recordsRead != 3 || m.recordsSkipped != 1 {
+		t.Errorf("read %v records and skipped %v, expected 3 and 1", m.recordsRead, m.recordsSkipped)
+	}
+
+	m.Fields = append(m.Fields, Field{Path: "x", Frame: "age"})
+	if err := m.Run(); err == nil {
+		t.Error("expected error for a frame with int and string fields")
+	}
+}
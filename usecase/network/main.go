@@ -18,7 +18,6 @@ import (
 	"github.com/google/gopacket/pcap"
 	pcli "github.com/pilosa/go-pilosa"
 	"github.com/pilosa/pdk"
-	"github.com/pilosa/pilosa"
 )
 
 type Main struct {
@@ -33,6 +32,11 @@ type Main struct {
 	Index         string
 	BindAddr      string
 	BufSize       int
+	OutputDir     string
+	Backend       string
+	SpoolDir      string
+	Throttle      pdk.ThrottleConfig
+	ProxyCache    pdk.QueryCacheConfig
 
 	netEndpointIDs   *StringIDs
 	transEndpointIDs *StringIDs
@@ -43,7 +47,7 @@ type Main struct {
 	userAgentIDs     *StringIDs
 	hostnameIDs      *StringIDs
 
-	client pdk.PilosaImporter
+	indexer pdk.Indexer
 
 	nexter Nexter
 
@@ -56,15 +60,30 @@ type Main struct {
 }
 
 func (m *Main) Run() error {
-	if err := m.Setup(); err != nil {
-		return fmt.Errorf("setting up index and frames: %v", err)
+	var err error
+	m.indexer, err = pdk.NewIndexer(pdk.IndexerConfig{
+		Backend:   m.Backend,
+		Hosts:     []string{m.PilosaHost},
+		BatchSize: m.BufSize,
+		OutputDir: m.OutputDir,
+		SpoolDir:  m.SpoolDir,
+		Throttle:  m.Throttle,
+	}, m.schema())
+	if err != nil {
+		return fmt.Errorf("creating indexer: %v", err)
 	}
-	m.client = pdk.NewImportClient(m.PilosaHost, m.Index, Frames, m.BufSize)
-	defer m.client.Close()
+	defer m.closeIndexer()
 
-	go func() {
-		log.Fatal(pdk.StartMappingProxy(m.BindAddr, m.PilosaHost, m))
-	}()
+	if _, ok := m.indexer.(*pdk.Index); ok {
+		go func() {
+			log.Fatal(pdk.StartProxy(pdk.ProxyConfig{
+				Bind:       m.BindAddr,
+				Hosts:      []string{m.PilosaHost},
+				Translator: m,
+				Cache:      m.ProxyCache,
+			}))
+		}()
+	}
 
 	// print total captured traffic when killed via Ctrl-c
 	c := make(chan os.Signal, 1)
@@ -74,6 +93,7 @@ func (m *Main) Run() error {
 			m.lenLock.Lock()
 			log.Printf("Total captured traffic: %v, num packets: %v", pdk.Bytes(m.totalLen), m.nexter.Last())
 			m.lenLock.Unlock()
+			m.closeIndexer()
 			os.Exit(0)
 		}
 	}()
@@ -90,7 +110,6 @@ func (m *Main) Run() error {
 	defer nt.Stop()
 
 	var h *pcap.Handle
-	var err error
 	if m.Filename != "" {
 		h, err = pcap.OpenOffline(m.Filename)
 	} else {
@@ -119,31 +138,26 @@ func (m *Main) Run() error {
 	return nil
 }
 
-func (m *Main) Setup() error {
-	pilosaURI, err := pcli.NewURIFromAddress(m.PilosaHost)
-	if err != nil {
-		return fmt.Errorf("interpreting pilosaHost '%v': %v", m.PilosaHost, err)
+// closeIndexer flushes everything to the indexer and stops it.
+func (m *Main) closeIndexer() {
+	if err := m.indexer.Close(); err != nil {
+		log.Printf("closing indexer: %v", err)
 	}
-	setupClient := pcli.NewClientWithURI(pilosaURI)
-	index, err := pcli.NewIndex(m.Index, &pcli.IndexOptions{})
-	if err != nil {
-		return fmt.Errorf("making index: %v", err)
-	}
-	err = setupClient.EnsureIndex(index)
-	if err != nil {
-		return fmt.Errorf("ensuring index existence: %v", err)
+}
+
+func (m *Main) addBit(frame string, col, row uint64) {
+	if err := m.indexer.AddBit(frame, col, row); err != nil {
+		log.Printf("adding bit: %v", err)
 	}
+}
+
+// schema returns the index and frames which packets are mapped into.
+func (m *Main) schema() pdk.Schema {
+	schema := pdk.Schema{Index: m.Index}
 	for _, frame := range Frames {
-		fram, err := index.Frame(frame, &pcli.FrameOptions{CacheType: pilosa.CacheTypeRanked})
-		if err != nil {
-			return fmt.Errorf("making frame: %v", err)
-		}
-		err = setupClient.EnsureFrame(fram)
-		if err != nil {
-			return fmt.Errorf("creating frame '%v': %v", frame, err)
-		}
+		schema.Frames = append(schema.Frames, pdk.FrameSpec{Name: frame, CacheType: pcli.CacheTypeRanked})
 	}
-	return nil
+	return schema
 }
 
 func (m *Main) extractAndPost(packets chan gopacket.Packet) {
@@ -159,7 +173,7 @@ func (m *Main) extractAndPost(packets chan gopacket.Packet) {
 
 		length := packet.Metadata().Length
 		m.AddLength(length)
-		m.client.SetBit(uint64(length), columnID, packetSizeFrame)
+		m.addBit(packetSizeFrame, columnID, uint64(length))
 		// ts := packet.Metadata().Timestamp
 
 		netLayer := packet.NetworkLayer()
@@ -167,65 +181,65 @@ func (m *Main) extractAndPost(packets chan gopacket.Packet) {
 			continue
 		}
 		netProto := netLayer.LayerType()
-		m.client.SetBit(m.netProtoIDs.GetID(netProto.String()), columnID, netProtoFrame)
+		m.addBit(netProtoFrame, columnID, m.netProtoIDs.GetID(netProto.String()))
 		netFlow := netLayer.NetworkFlow()
 		netSrc, netDst := netFlow.Endpoints()
-		m.client.SetBit(m.netEndpointIDs.GetID(netSrc.String()), columnID, netSrcFrame)
-		m.client.SetBit(m.netEndpointIDs.GetID(netDst.String()), columnID, netDstFrame)
+		m.addBit(netSrcFrame, columnID, m.netEndpointIDs.GetID(netSrc.String()))
+		m.addBit(netDstFrame, columnID, m.netEndpointIDs.GetID(netDst.String()))
 
 		transLayer := packet.TransportLayer()
 		if transLayer == nil {
 			continue
 		}
 		transProto := transLayer.LayerType()
-		m.client.SetBit(m.transProtoIDs.GetID(transProto.String()), columnID, transProtoFrame)
+		m.addBit(transProtoFrame, columnID, m.transProtoIDs.GetID(transProto.String()))
 		transFlow := transLayer.TransportFlow()
 		transSrc, transDst := transFlow.Endpoints()
-		m.client.SetBit(m.transEndpointIDs.GetID(transSrc.String()), columnID, transSrcFrame)
-		m.client.SetBit(m.transEndpointIDs.GetID(transDst.String()), columnID, transDstFrame)
+		m.addBit(transSrcFrame, columnID, m.transEndpointIDs.GetID(transSrc.String()))
+		m.addBit(transDstFrame, columnID, m.transEndpointIDs.GetID(transDst.String()))
 		if tcpLayer, ok := transLayer.(*layers.TCP); ok {
 			if tcpLayer.FIN {
-				m.client.SetBit(uint64(FIN), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(FIN))
 			}
 			if tcpLayer.SYN {
-				m.client.SetBit(uint64(SYN), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(SYN))
 			}
 			if tcpLayer.RST {
-				m.client.SetBit(uint64(RST), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(RST))
 			}
 			if tcpLayer.PSH {
-				m.client.SetBit(uint64(PSH), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(PSH))
 			}
 			if tcpLayer.ACK {
-				m.client.SetBit(uint64(ACK), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(ACK))
 			}
 			if tcpLayer.URG {
-				m.client.SetBit(uint64(URG), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(URG))
 			}
 			if tcpLayer.ECE {
-				m.client.SetBit(uint64(ECE), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(ECE))
 			}
 			if tcpLayer.CWR {
-				m.client.SetBit(uint64(CWR), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(CWR))
 			}
 			if tcpLayer.NS {
-				m.client.SetBit(uint64(NS), columnID, TCPFlagsFrame)
+				m.addBit(TCPFlagsFrame, columnID, uint64(NS))
 			}
 		}
 		appLayer := packet.ApplicationLayer()
 		if appLayer != nil {
 			appProto := appLayer.LayerType()
-			m.client.SetBit(m.appProtoIDs.GetID(appProto.String()), columnID, appProtoFrame)
+			m.addBit(appProtoFrame, columnID, m.appProtoIDs.GetID(appProto.String()))
 			appBytes := appLayer.Payload()
 			buf := bytes.NewBuffer(appBytes)
 			req, err := http.ReadRequest(bufio.NewReader(buf))
 			if err == nil {
 				userAgent := req.UserAgent()
-				m.client.SetBit(m.userAgentIDs.GetID(userAgent), columnID, userAgentFrame)
+				m.addBit(userAgentFrame, columnID, m.userAgentIDs.GetID(userAgent))
 				method := req.Method
-				m.client.SetBit(m.methodIDs.GetID(method), columnID, methodFrame)
+				m.addBit(methodFrame, columnID, m.methodIDs.GetID(method))
 				hostname := req.Host
-				m.client.SetBit(m.hostnameIDs.GetID(hostname), columnID, hostnameFrame)
+				m.addBit(hostnameFrame, columnID, m.hostnameIDs.GetID(hostname))
 			} else {
 				// try HTTP response?
 				// resp, err := http.ReadResponse(bufio.NewReader(buf))
@@ -306,6 +320,41 @@ func (m *Main) GetID(frame string, ival interface{}) (uint64, error) {
 
 }
 
+// LookupID is GetID without allocating ids for unknown values.
+func (m *Main) LookupID(frame string, ival interface{}) (uint64, bool, error) {
+	var ids *StringIDs
+	switch frame {
+	case netSrcFrame, netDstFrame:
+		ids = m.netEndpointIDs
+	case transSrcFrame, transDstFrame:
+		ids = m.transEndpointIDs
+	case netProtoFrame:
+		ids = m.netProtoIDs
+	case transProtoFrame:
+		ids = m.transProtoIDs
+	case appProtoFrame:
+		ids = m.appProtoIDs
+	case hostnameFrame:
+		ids = m.hostnameIDs
+	case methodFrame:
+		ids = m.methodIDs
+	case userAgentFrame:
+		ids = m.userAgentIDs
+	default:
+		id, err := m.GetID(frame, ival)
+		if err != nil {
+			return 0, false, err
+		}
+		return id, true, nil
+	}
+	val, ok := ival.(string)
+	if !ok {
+		return 0, false, fmt.Errorf("%v is not a string, but should be for frame %s", ival, frame)
+	}
+	id, ok := ids.LookupID(val)
+	return id, ok, nil
+}
+
 func (m *Main) AddLength(num int) {
 	m.lenLock.Lock()
 	m.totalLen += int64(num)
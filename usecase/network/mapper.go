@@ -35,6 +35,14 @@ func (s *StringIDs) GetID(input string) uint64 {
 	return s.cur - 1
 }
 
+// LookupID returns the id of input without allocating one.
+func (s *StringIDs) LookupID(input string) (uint64, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	id, ok := s.idMap[input]
+	return id, ok
+}
+
 func (s *StringIDs) Get(id uint64) string {
 	// TODO I think we can get away without locking here - confirm
 	return s.strings[id]
@@ -3,12 +3,12 @@ package ssb
 import (
 	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pilosa/pdk"
@@ -16,17 +16,50 @@ import (
 )
 
 type Main struct {
-	Dir             string
+	Dir string
+	// StarSchema is the file declaring how lineorder.tbl is joined with the
+	// dimension tables in Dir (see pdk.StarSchema).
+	StarSchema      string
 	Hosts           []string
 	Index           string
-	SFHint          int
 	ReadConcurrency int
 	MapConcurrency  int
 	RecordBuf       int
-
-	trans  pdk.Translator
-	index  pdk.Indexer
-	nexter pdk.Nexter
+	MetricsAddr     string
+	OutputDir       string
+	Backend         string
+	SpoolDir        string
+	Throttle        pdk.ThrottleConfig
+	// MutexDir is where the rows set in the mutex frames are kept (see
+	// KeyColumns). If empty they are kept in memory.
+	MutexDir string
+	// MaxBuffered limits the bits or values buffered per frame and field
+	// while they are grouped into batches by slice.
+	MaxBuffered int
+	// Checkpoint is the file in which progress is saved every
+	// CheckpointInterval. If Resume is set, chunks of the lineorder table
+	// which were imported completely by a previous run are skipped.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             bool
+	// KeyColumns numbers lineorder columns by their order key and line
+	// number (through the translator) rather than by position in the file,
+	// so that importing the same rows again updates them in place. The
+	// frames which aren't BSI fields are then mutex frames, so that an
+	// updated row's old bits are cleared.
+	KeyColumns bool
+	// ProxyCache configures the query cache of the proxy started after the
+	// import.
+	ProxyCache pdk.QueryCacheConfig
+
+	trans pdk.Translator
+	index pdk.Indexer
+	ckpt  *pdk.Checkpoint
+	join  *pdk.StarJoin
+	cols  joinColumns
+
+	recordsRead   uint64
+	recordsMapped uint64
 }
 
 func NewMain() (*Main, error) {
@@ -35,45 +68,92 @@ func NewMain() (*Main, error) {
 		return nil, err
 	}
 	return &Main{
+		StarSchema:      "usecase/ssb/starschema.json",
 		Index:           "ssb",
 		ReadConcurrency: 1,
 		MapConcurrency:  4,
 		RecordBuf:       1000000,
+		MetricsAddr:     "localhost:6060",
 
-		nexter: pdk.NewNexter(),
-		trans:  trans,
+		trans: trans,
 	}, nil
 }
 
 func (m *Main) Run() (err error) {
-	log.Println("setting up pilosa")
-	m.index, err = pdk.SetupPilosa(m.Hosts, m.Index, frames)
+	m.ckpt, err = pdk.NewCheckpoint(m.Checkpoint, m.Resume)
+	if err != nil {
+		return errors.Wrap(err, "loading checkpoint")
+	}
+	frags, err := m.splitLineOrder()
 	if err != nil {
-		return errors.Wrap(err, "setting up Pilosa")
+		return err
 	}
 
-	log.Println("reading in edge tables.")
-	custs, parts, supps, dates, err := m.setupEdgeTables()
+	log.Println("setting up indexer")
+	m.index, err = pdk.NewIndexer(pdk.IndexerConfig{
+		Backend:     m.Backend,
+		Hosts:       m.Hosts,
+		MaxBuffered: m.MaxBuffered,
+		OutputDir:   m.OutputDir,
+		SpoolDir:    m.SpoolDir,
+		Throttle:    m.Throttle,
+		Resume:      m.Resume,
+		MutexDir:    m.MutexDir,
+	}, pdk.Schema{Index: m.Index, Frames: m.frames()})
 	if err != nil {
-		return errors.Wrap(err, "setting up edge tables")
+		return errors.Wrap(err, "setting up indexer")
+	}
+
+	log.Println("reading in dimension tables.")
+	if err := m.setupJoin(); err != nil {
+		return errors.Wrap(err, "setting up star join")
 	}
 
 	log.Println("reading lineorder table.")
 	rc := make(chan *record, m.RecordBuf) // TODO tweak for perf
 
+	pdk.Stats.RegisterCounter("ssb_records_read_total", "Lineorder records read and joined.", func() int64 { return int64(atomic.LoadUint64(&m.recordsRead)) })
+	pdk.Stats.RegisterCounter("ssb_records_mapped_total", "Lineorder records handed to the mappers.", func() int64 { return int64(atomic.LoadUint64(&m.recordsMapped)) })
+	pdk.Stats.RegisterGauge("ssb_record_buffer", "Records waiting to be mapped.", func() int64 { return int64(len(rc)) })
+	go func() {
+		log.Println(pdk.StartMetricsServer(m.MetricsAddr))
+	}()
+
 	go func() {
-		m.runReaders(rc, custs, parts, supps, dates)
+		m.runReaders(frags, rc)
 		close(rc)
 	}()
 
 	log.Println("running mappers")
-	m.runMappers(rc)
+	stopCheckpoints := m.ckpt.SaveEvery(m.index, m.CheckpointInterval)
+	err = m.runMappers(rc)
+	stopCheckpoints()
+	if err != nil {
+		return errors.Wrap(err, "importing")
+	}
+	if err := m.ckpt.Save(nil); err != nil {
+		return errors.Wrap(err, "saving checkpoint")
+	}
 
+	if _, ok := m.index.(*pdk.Index); !ok {
+		log.Println("mappers finished")
+		return nil
+	}
 	log.Println("mappers finished - starting proxy")
-	return pdk.StartMappingProxy("localhost:3456", "localhost:10101", m.trans)
+	conf := pdk.ProxyConfig{
+		Bind:       "localhost:3456",
+		Hosts:      m.Hosts,
+		Translator: m.trans,
+		Cache:      m.ProxyCache,
+	}
+	if m.KeyColumns {
+		key := m.columnKey()
+		conf.ColumnKey = &key
+	}
+	return pdk.StartProxy(conf)
 }
 
-func (m *Main) runMappers(rc <-chan *record) {
+func (m *Main) runMappers(rc <-chan *record) error {
 	wg := sync.WaitGroup{}
 	for i := 0; i < m.MapConcurrency; i++ {
 		wg.Add(1)
@@ -83,109 +163,79 @@ func (m *Main) runMappers(rc <-chan *record) {
 		}()
 	}
 	wg.Wait()
-	m.index.Close() // close import channels
+	return m.index.Close() // close import channels and wait for imports to finish
 }
 
 func (m *Main) mapRecords(rc <-chan *record) {
 	for rec := range rc {
-		col := m.nexter.Next()
-
-		id, err := m.trans.GetID("lo_year", rec.order_year)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("lo_year", col, id)
-		id, err = m.trans.GetID("lo_month", rec.order_month)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("lo_month", col, id)
+		col := rec.col
 
-		id, err = m.trans.GetID("lo_weeknum", rec.order_weeknum)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("lo_weeknum", col, id)
+		m.addBit("lo_year", col, rec.order_year, rec)
+		m.addBit("lo_month", col, rec.order_month, rec)
+		m.addBit("lo_weeknum", col, rec.order_weeknum, rec)
+		m.addBit("lo_discount_b", col, rec.lo_discount, rec)
+		m.addBit("lo_quantity_b", col, rec.lo_quantity, rec)
 
-		id, err = m.trans.GetID("lo_discount_b", rec.lo_discount)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("lo_discount_b", col, id)
-
-		id, err = m.trans.GetID("lo_quantity_b", rec.lo_quantity)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("lo_quantity_b", col, id)
-
-		m.index.AddValue("lo_quantity", col, uint64(rec.lo_quantity))
-		m.index.AddValue("lo_extendedprice", col, uint64(rec.lo_extendedprice))
-		m.index.AddValue("lo_discount", col, uint64(rec.lo_discount))
-		m.index.AddValue("lo_revenue", col, uint64(rec.lo_revenue))
-		m.index.AddValue("lo_supplycost", col, uint64(rec.lo_supplycost))
+		m.addValue("lo_quantity", col, uint64(rec.lo_quantity), rec)
+		m.addValue("lo_extendedprice", col, uint64(rec.lo_extendedprice), rec)
+		m.addValue("lo_discount", col, uint64(rec.lo_discount), rec)
+		m.addValue("lo_revenue", col, uint64(rec.lo_revenue), rec)
+		m.addValue("lo_supplycost", col, uint64(rec.lo_supplycost), rec)
 
 		revenueComputed := uint64(float64(rec.lo_extendedprice) * float64(rec.lo_discount) * 0.01)
-		m.index.AddValue("lo_revenue_computed", col, revenueComputed)
+		m.addValue("lo_revenue_computed", col, revenueComputed, rec)
 		profitComputed := uint32(rec.lo_revenue) - rec.lo_supplycost
-		m.index.AddValue("lo_profit", col, uint64(profitComputed))
+		m.addValue("lo_profit", col, uint64(profitComputed), rec)
 
-		id, err = m.trans.GetID("c_city", rec.c_city)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("c_city", col, id)
-		id, err = m.trans.GetID("c_nation", rec.c_nation)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("c_nation", col, id)
+		m.addBit("c_city", col, rec.c_city, rec)
+		m.addBit("c_nation", col, rec.c_nation, rec)
+		m.addBit("c_region", col, rec.c_region, rec)
 
-		id, err = m.trans.GetID("c_region", rec.c_region)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("c_region", col, id)
+		m.addBit("s_city", col, rec.s_city, rec)
+		m.addBit("s_nation", col, rec.s_nation, rec)
+		m.addBit("s_region", col, rec.s_region, rec)
 
-		id, err = m.trans.GetID("s_city", rec.s_city)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("s_city", col, id)
-		id, err = m.trans.GetID("s_nation", rec.s_nation)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("s_nation", col, id)
-
-		id, err = m.trans.GetID("s_region", rec.s_region)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("s_region", col, id)
+		m.addBit("p_mfgr", col, rec.p_mfgr, rec)
+		m.addBit("p_category", col, rec.p_category, rec)
+		m.addBit("p_brand1", col, rec.p_brand1, rec)
 
-		id, err = m.trans.GetID("p_mfgr", rec.p_mfgr)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
+		atomic.AddUint64(&m.recordsMapped, 1)
+		if rec.progress != nil {
+			rec.progress.Done()
 		}
-		m.index.AddBit("p_mfgr", col, id)
+	}
+}
 
-		id, err = m.trans.GetID("p_category", rec.p_category)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("p_category", col, id)
+// addBit translates val to a row id in frame, and sets the bit for col.
+func (m *Main) addBit(frame string, col uint64, val interface{}, rec *record) {
+	id, err := m.trans.GetID(frame, val)
+	if err != nil {
+		log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
+		return
+	}
+	err = m.index.AddBit(frame, col, id)
+	if err != nil {
+		log.Printf("Couldn't add bit col: %v, rec: %v, err: %v", col, rec, err)
+	}
+}
 
-		id, err = m.trans.GetID("p_brand1", rec.p_brand1)
-		if err != nil {
-			log.Printf("Couldn't map record col: %v, rec: %v, err: %v", col, rec, err)
-		}
-		m.index.AddBit("p_brand1", col, id)
+// addValue sets the value of col in the BSI field which shares its frame's
+// name.
+func (m *Main) addValue(frame string, col uint64, val uint64, rec *record) {
+	err := m.index.AddValue(frame, frame, col, val)
+	if err != nil {
+		log.Printf("Couldn't add value col: %v, rec: %v, err: %v", col, rec, err)
 	}
 }
 
 type record struct {
-	// TODO add lo orderkey and linenumber so we can store column/key mapping?
+	col      uint64
+	progress *pdk.InputProgress
+
+	// lo_orderkey and lo_linenumber identify the row, and are used as its
+	// column key if Main.KeyColumns is set.
+	lo_orderkey      string
+	lo_linenumber    string
 	lo_quantity      uint8
 	lo_extendedprice uint16
 	lo_discount      uint8
@@ -213,163 +263,236 @@ func (r *record) String() string {
 	return fmt.Sprintf("{year: %d, month: %s, week: %d, quant: %d, extp: %d, disc: %d, rev: %d, suppcost: %d, c_city: %s, c_nation: %s, c_region: %s, s_city: %s, s_nation: %s, s_region: %s, p_mfgr: %s, p_category: %s, p_brand1: %s}", r.order_year, r.order_month, r.order_weeknum, r.lo_quantity, r.lo_extendedprice, r.lo_discount, r.lo_revenue, r.lo_supplycost, r.c_city, r.c_nation, r.c_region, r.s_city, r.s_nation, r.s_region, r.p_mfgr, r.p_category, r.p_brand1)
 }
 
-func (m *Main) runReaders(rc chan<- *record, custs map[int]customer, parts map[int]part, supps map[int]supplier, dates map[int]date) error {
+// splitLineOrder splits lineorder.tbl into a fragment per reader. Chunks of
+// the table are checkpointed by offset, so a resumed import must split it the
+// same way, which is checked against the checkpoint.
+func (m *Main) splitLineOrder() ([]*pdk.FileFragment, error) {
 	fil, err := os.Open(m.Dir + "/lineorder.tbl")
 	if err != nil {
-		return errors.Wrap(err, "opening lineorder.tbl")
+		return nil, errors.Wrap(err, "opening lineorder.tbl")
 	}
 	frags, err := pdk.SplitFileLines(fil, int64(m.ReadConcurrency))
 	if err != nil {
-		return errors.Wrap(err, "splitting file")
+		return nil, errors.Wrap(err, "splitting file")
+	}
+	starts := make([]string, len(frags))
+	for i, frag := range frags {
+		starts[i] = strconv.FormatInt(frag.Start(), 10)
+	}
+	if err := m.ckpt.Require("lineorder.tbl split", strings.Join(starts, ",")); err != nil {
+		return nil, err
 	}
+	return frags, nil
+}
+
+func (m *Main) runReaders(frags []*pdk.FileFragment, rc chan<- *record) {
 	wg := sync.WaitGroup{}
 	for _, frag := range frags {
 		wg.Add(1)
-		go func() {
+		go func(frag *pdk.FileFragment) {
 			defer wg.Done()
-			parseLineOrder(frag, rc, custs, parts, supps, dates)
-		}()
+			m.readLineOrder(frag, rc)
+		}(frag)
 	}
 	wg.Wait()
-	return nil
 }
 
-func parseLineOrder(r io.Reader, rc chan<- *record, custs map[int]customer, parts map[int]part, supps map[int]supplier, dates map[int]date) {
-	i := -1
-	start := time.Now()
-	last := start
-	scanner := bufio.NewScanner(r)
+// keyFrame is the translator frame which maps lineorder keys to columns.
+const keyFrame = "lo_key"
+
+// columnKey derives a lineorder column from the record's order key and line
+// number, given in that order.
+func (m *Main) columnKey() pdk.ColumnKey {
+	return pdk.ColumnKey{Frame: keyFrame, Fields: []int{0, 1}, Translator: m.trans}
+}
+
+// lineOrderChunk is the number of lines of the lineorder table which are
+// reserved columns and checkpointed together.
+const lineOrderChunk = 100000
+
+// readLineOrder reads frag in chunks of lineOrderChunk lines. Each chunk is
+// an input of the checkpoint, named after the offset at which it starts, so
+// that a resumed import skips the chunks which were done and gives the rest
+// the same columns as before.
+func (m *Main) readLineOrder(frag *pdk.FileFragment, rc chan<- *record) {
+	scanner := bufio.NewScanner(frag)
+	offset := frag.Start()
+	chunkStart := offset
+	lines := make([]string, 0, lineOrderChunk)
 	for scanner.Scan() {
-		i++
-		line := strings.Split(scanner.Text(), "|")
-		custkey, err := strconv.Atoi(line[2])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting cust key to int: %v", line, err)
-			continue
-		}
-		partkey, err := strconv.Atoi(line[3])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting supplier key to int: %v", line, err)
-			continue
-		}
-		suppkey, err := strconv.Atoi(line[4])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting part key to int: %v", line, err)
-			continue
-		}
-		datekey, err := strconv.Atoi(line[5])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting date key to int: %v", line, err)
-			continue
-		}
-		cust, okcust := custs[custkey]
-		par, okpar := parts[partkey]
-		supp, oksupp := supps[suppkey]
-		dat, okdat := dates[datekey]
-		if !(okcust && okpar && oksupp && okdat) {
-			log.Printf("FK lookup fail: %v:%v, %v:%v, %v:%v, %v:%v", custkey, okcust, partkey, okpar, suppkey, oksupp, datekey, okdat)
-			continue
-		}
-		quantity, err := strconv.Atoi(line[8])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting quantity to int: %v", line, err)
-			continue
+		lines = append(lines, scanner.Text())
+		offset += int64(len(scanner.Bytes())) + 1
+		if len(lines) == lineOrderChunk {
+			m.sendLineOrderChunk(fmt.Sprintf("lineorder.tbl:%d", chunkStart), lines, rc)
+			lines = lines[:0]
+			chunkStart = offset
 		}
-		extendedprice, err := strconv.Atoi(line[9])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting extendedprice to int: %v", line, err)
-			continue
-		}
-		discount, err := strconv.Atoi(line[11])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting discount to int: %v", line, err)
-			continue
-		}
-		revenue, err := strconv.Atoi(line[12])
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(errors.Wrap(err, "reading lineorder table"))
+	}
+	if len(lines) > 0 {
+		m.sendLineOrderChunk(fmt.Sprintf("lineorder.tbl:%d", chunkStart), lines, rc)
+	}
+}
+
+func (m *Main) sendLineOrderChunk(name string, lines []string, rc chan<- *record) {
+	if m.ckpt.Done(name) {
+		return
+	}
+	var first uint64
+	if !m.KeyColumns {
+		first = m.ckpt.Reserve(name, uint64(len(lines)))
+	}
+	key := m.columnKey()
+	recs := make([]*record, 0, len(lines))
+	for i, line := range lines {
+		rec, err := m.parseLineOrder(line)
 		if err != nil {
-			log.Printf("Lineorder line %v. Converting revenue to int: %v", line, err)
+			log.Printf("Lineorder line %v: %v", line, err)
 			continue
 		}
-		supplycost, err := strconv.Atoi(line[13])
-		if err != nil {
-			log.Printf("Lineorder line %v. Converting supplycost to int: %v", line, err)
-			continue
+		rec.col = first + uint64(i)
+		if m.KeyColumns {
+			col, err := key.ColumnID([]string{rec.lo_orderkey, rec.lo_linenumber})
+			if err != nil {
+				log.Printf("Lineorder line %v: %v", line, err)
+				continue
+			}
+			rec.col = col
 		}
+		recs = append(recs, rec)
+	}
+	progress := m.ckpt.Start(name, len(recs))
+	for _, rec := range recs {
+		rec.progress = progress
+		rc <- rec
+		atomic.AddUint64(&m.recordsRead, 1)
+	}
+}
 
-		rc <- &record{
-			lo_quantity:      uint8(quantity),
-			lo_extendedprice: uint16(extendedprice),
-			lo_discount:      uint8(discount),
-			lo_revenue:       uint16(revenue),
-			lo_supplycost:    uint32(supplycost),
-
-			c_city:   cust.city,
-			c_nation: cust.nation,
-			c_region: cust.region,
-
-			s_city:   supp.city,
-			s_nation: supp.nation,
-			s_region: supp.region,
-
-			p_mfgr:     par.mfgr,
-			p_category: par.category,
-			p_brand1:   par.brand1,
+// joinColumns are the positions of the mapped columns in a lineorder row
+// joined by the star schema.
+type joinColumns struct {
+	orderkey, linenumber                                   int
+	quantity, extendedprice, discount, revenue, supplycost int
+	cCity, cNation, cRegion                                int
+	sCity, sNation, sRegion                                int
+	pMfgr, pCategory, pBrand1                              int
+	year, month, weeknum                                   int
+	width                                                  int
+}
 
-			order_year:    uint16(dat.year),
-			order_month:   dat.month,
-			order_weeknum: uint8(dat.weeknum),
+// newJoinColumns finds the mapped columns in the fields of a StarJoin. It
+// returns an error if the star schema doesn't name one of them.
+func newJoinColumns(fields map[string]int) (joinColumns, error) {
+	var jc joinColumns
+	cols := []struct {
+		name string
+		pos  *int
+	}{
+		{"lo_orderkey", &jc.orderkey}, {"lo_linenumber", &jc.linenumber},
+		{"lo_quantity", &jc.quantity}, {"lo_extendedprice", &jc.extendedprice}, {"lo_discount", &jc.discount}, {"lo_revenue", &jc.revenue}, {"lo_supplycost", &jc.supplycost},
+		{"c.city", &jc.cCity}, {"c.nation", &jc.cNation}, {"c.region", &jc.cRegion},
+		{"s.city", &jc.sCity}, {"s.nation", &jc.sNation}, {"s.region", &jc.sRegion},
+		{"p.mfgr", &jc.pMfgr}, {"p.category", &jc.pCategory}, {"p.brand1", &jc.pBrand1},
+		{"d.year", &jc.year}, {"d.month", &jc.month}, {"d.weeknum", &jc.weeknum},
+	}
+	for _, col := range cols {
+		pos, ok := fields[col.name]
+		if !ok {
+			return jc, errors.Errorf("star schema has no column %v", col.name)
 		}
-		if i%1000000 == 0 && i > 1 {
-			now := time.Now()
-			elapsed := now.Sub(start)
-			overallThroughput := int(float64(i) / (float64(elapsed) / float64(time.Second)))
-			lastM := int(1000000.0 / (float64(now.Sub(last)) / float64(time.Second)))
-			log.Printf("Elapsed: %v, recs/s: %v, lastMil: %v, recordBuffer: %v", elapsed, overallThroughput, lastM, len(rc))
-			last = now
+		*col.pos = pos
+		if pos >= jc.width {
+			jc.width = pos + 1
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		log.Println(errors.Wrap(err, "reading lineorder table"))
-	}
+	return jc, nil
 }
 
-func (m *Main) setupEdgeTables() (cust map[int]customer, par map[int]part, supp map[int]supplier, dat map[int]date, err error) {
-	wg := sync.WaitGroup{}
-	custF, err := os.Open(m.Dir + "/customer.tbl")
+// setupJoin loads the star schema and the dimension tables it names.
+func (m *Main) setupJoin() error {
+	schema, err := pdk.LoadStarSchema(m.StarSchema, m.Dir)
 	if err != nil {
-		return nil, nil, nil, nil, errors.Wrap(err, "opening customer.tbl")
+		return err
 	}
-	partF, err := os.Open(m.Dir + "/part.tbl")
+	m.join, err = pdk.NewStarJoin(schema)
 	if err != nil {
-		return nil, nil, nil, nil, errors.Wrap(err, "opening part.tbl")
+		return err
 	}
-	supplierF, err := os.Open(m.Dir + "/supplier.tbl")
+	m.cols, err = newJoinColumns(m.join.Fields())
+	return err
+}
+
+// parseLineOrder joins a line of the lineorder table with the dimension
+// tables, and parses the result into a record.
+func (m *Main) parseLineOrder(text string) (*record, error) {
+	line, err := m.join.JoinLine(text)
 	if err != nil {
-		return nil, nil, nil, nil, errors.Wrap(err, "opening supplier.tbl")
+		return nil, err
 	}
-	dateF, err := os.Open(m.Dir + "/date.tbl")
-	if err != nil {
-		return nil, nil, nil, nil, errors.Wrap(err, "opening date.tbl")
+	if len(line) < m.cols.width {
+		return nil, errors.Errorf("joined row has %v fields, expected at least %v", len(line), m.cols.width)
 	}
-	wg.Add(4)
-	go func() {
-		defer wg.Done()
-		cust = mapCustomer(custF, m.SFHint)
-	}()
-	go func() {
-		defer wg.Done()
-		par = mapPart(partF, m.SFHint)
-	}()
-	go func() {
-		defer wg.Done()
-		supp = mapSupplier(supplierF, m.SFHint)
-	}()
-	go func() {
-		defer wg.Done()
-		dat = mapDate(dateF, m.SFHint)
-	}()
-	wg.Wait()
-	return cust, par, supp, dat, nil
+	ints := []struct {
+		name string
+		pos  int
+		val  int
+	}{
+		{name: "quantity", pos: m.cols.quantity},
+		{name: "extendedprice", pos: m.cols.extendedprice},
+		{name: "discount", pos: m.cols.discount},
+		{name: "revenue", pos: m.cols.revenue},
+		{name: "supplycost", pos: m.cols.supplycost},
+		{name: "year", pos: m.cols.year},
+		{name: "weeknum", pos: m.cols.weeknum},
+	}
+	for i := range ints {
+		ints[i].val, err = strconv.Atoi(line[ints[i].pos])
+		if err != nil {
+			return nil, errors.Wrapf(err, "converting %v to int", ints[i].name)
+		}
+	}
+
+	return &record{
+		lo_orderkey:   line[m.cols.orderkey],
+		lo_linenumber: line[m.cols.linenumber],
+
+		lo_quantity:      uint8(ints[0].val),
+		lo_extendedprice: uint16(ints[1].val),
+		lo_discount:      uint8(ints[2].val),
+		lo_revenue:       uint16(ints[3].val),
+		lo_supplycost:    uint32(ints[4].val),
+
+		c_city:   line[m.cols.cCity],
+		c_nation: line[m.cols.cNation],
+		c_region: line[m.cols.cRegion],
+
+		s_city:   line[m.cols.sCity],
+		s_nation: line[m.cols.sNation],
+		s_region: line[m.cols.sRegion],
+
+		p_mfgr:     line[m.cols.pMfgr],
+		p_category: line[m.cols.pCategory],
+		p_brand1:   line[m.cols.pBrand1],
+
+		order_year:    uint16(ints[5].val),
+		order_month:   line[m.cols.month],
+		order_weeknum: uint8(ints[6].val),
+	}, nil
+}
+
+// frames returns the frames to import into, with the bit frames marked as
+// mutex frames if m.KeyColumns is set.
+func (m *Main) frames() []pdk.FrameSpec {
+	specs := append([]pdk.FrameSpec(nil), frames...)
+	if m.KeyColumns {
+		for i := range specs {
+			specs[i].Mutex = len(specs[i].Fields) == 0
+		}
+	}
+	return specs
 }
 
 var frames = []pdk.FrameSpec{
@@ -423,128 +546,3 @@ var frames = []pdk.FrameSpec{
 	// pdk.NewFrameSpec("size"),
 	// pdk.NewFrameSpec("container"),
 }
-
-type customer struct {
-	city       string
-	nation     string
-	region     string
-	mktsegment string
-}
-
-func mapCustomer(f io.Reader, sf int) map[int]customer {
-	cmap := make(map[int]customer, 30000*sf)
-	scanner := bufio.NewScanner(f)
-	i := -1
-	for scanner.Scan() {
-		i++
-		line := strings.Split(scanner.Text(), "|")
-		key, err := strconv.Atoi(string(line[0]))
-		if err != nil {
-			log.Printf("Line %v of customer table: %v. Converting key to int: %v", i, line, err)
-			continue
-		}
-		cmap[key] = customer{
-			city:       line[3],
-			nation:     line[4],
-			region:     line[5],
-			mktsegment: line[7],
-		}
-	}
-	return cmap
-}
-
-type supplier struct {
-	city   string
-	nation string
-	region string
-}
-
-func mapSupplier(f io.Reader, sf int) map[int]supplier {
-	cmap := make(map[int]supplier, 2000*sf)
-	scanner := bufio.NewScanner(f)
-	i := -1
-	for scanner.Scan() {
-		i++
-		line := strings.Split(scanner.Text(), "|")
-		key, err := strconv.Atoi(string(line[0]))
-		if err != nil {
-			log.Printf("Line %v of supplier table: %v. Converting key to int: %v", i, line, err)
-			continue
-		}
-		cmap[key] = supplier{
-			city:   line[3],
-			nation: line[4],
-			region: line[5],
-		}
-	}
-	return cmap
-}
-
-type part struct {
-	mfgr     string
-	category string
-	brand1   string
-}
-
-func mapPart(f io.Reader, sf int) map[int]part {
-	cmap := make(map[int]part, 2000*sf)
-	scanner := bufio.NewScanner(f)
-	i := -1
-	for scanner.Scan() {
-		i++
-		line := strings.Split(scanner.Text(), "|")
-		key, err := strconv.Atoi(line[0])
-		if err != nil {
-			log.Printf("Line %v of part table: %v. Converting key to int: %v", i, line, err)
-			continue
-		}
-		cmap[key] = part{
-			mfgr:     line[2],
-			category: line[3],
-			brand1:   line[4],
-		}
-	}
-	return cmap
-}
-
-type date struct {
-	year    int
-	month   string
-	weeknum int
-}
-
-func (d date) String() string {
-	return fmt.Sprintf("{year: %d, month: %s, weeknum: %d}", d.year, d.month, d.weeknum)
-}
-
-func mapDate(f io.Reader, sf int) map[int]date {
-	cmap := make(map[int]date, 2000*sf)
-	scanner := bufio.NewScanner(f)
-	i := -1
-	for scanner.Scan() {
-		i++
-		line := strings.Split(scanner.Text(), "|")
-		key, err := strconv.Atoi(line[0])
-		if err != nil {
-			log.Printf("Line %v of date table: %v. Converting key to int: %v", i, line, err)
-			continue
-		}
-		year, err := strconv.Atoi(line[4])
-		if err != nil {
-			log.Printf("Line %v of date table: %v. Converting year to int: %v", i, line, err)
-			continue
-		}
-		weeknum, err := strconv.Atoi(line[11])
-		if err != nil {
-			log.Printf("Line %v of date table: %v. Converting weeknum to int: %v", i, line, err)
-			continue
-		}
-		d := date{
-			year:    year,
-			month:   line[3],
-			weeknum: weeknum,
-		}
-		cmap[key] = d
-	}
-	return cmap
-}
@@ -1,12 +1,37 @@
 package ssb
 
 import (
-	"strings"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
+
+	"github.com/pilosa/pdk"
+	"github.com/pilosa/pilosa/pql"
 )
 
-func TestMapCustomer(t *testing.T) {
-	data := `
+// testJoin writes the dimension tables to a temporary directory, and sets up
+// m's star join over them with starschema.json.
+func testJoin(t *testing.T, m *Main, customers, parts, suppliers, dates string) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for file, data := range map[string]string{"customer.tbl": customers, "part.tbl": parts, "supplier.tbl": suppliers, "date.tbl": dates} {
+		if err := ioutil.WriteFile(dir+"/"+file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Dir = dir
+	m.StarSchema = "starschema.json"
+	if err := m.setupJoin(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseLineOrder(t *testing.T) {
+	customers := `
 1|Customer#000000001|j5JsirBM9P|MOROCCO  2|MOROCCO|AFRICA|25-918-335-1736|BUILDING|
 2|Customer#000000002|487LW1dovn6Q4dMVym|JORDAN   0|JORDAN|MIDDLE EAST|23-679-861-2259|AUTOMOBILE|
 3|Customer#000000003|fkRGN8n|ARGENTINA0|ARGENTINA|AMERICA|11-383-516-1199|AUTOMOBILE|
@@ -18,23 +43,7 @@ func TestMapCustomer(t *testing.T) {
 9|Customer#000000009|vgIql8H6zoyuLMFN|INDIA    4|INDIA|ASIA|18-403-398-8662|FURNITURE|
 10|Customer#000000010|Vf mQ6Ug9Ucf5OKGYq fs|ETHIOPIA 6|ETHIOPIA|AFRICA|15-852-489-8585|HOUSEHOLD|
 `[1:]
-
-	r := strings.NewReader(data)
-	res := mapCustomer(r, 10)
-
-	if len(res) != 10 {
-		t.Fatalf("unexpected number of customers in result map: %v\n%v", len(res), res)
-	}
-	if string(res[8].mktsegment) != "BUILDING" {
-		t.Fatalf("res8.mktsegment: %s doesn't match BUILDING", res[8].mktsegment)
-	}
-	if string(res[1].nation) != "MOROCCO" {
-		t.Fatalf("res1.nation: %s doesn't match MOROCCO", res[1].nation)
-	}
-}
-
-func TestMapSupplier(t *testing.T) {
-	data := `
+	suppliers := `
 1|Supplier#000000001|sdrGnXCDRcfriBvY0KL,i|PERU     9|PERU|AMERICA|27-989-741-2988|
 2|Supplier#000000002|TRMhVHz3XiFu|ETHIOPIA 7|ETHIOPIA|AFRICA|15-768-687-3665|
 3|Supplier#000000003|BZ0kXcHUcHjx62L7CjZS|ARGENTINA2|ARGENTINA|AMERICA|11-719-748-3364|
@@ -46,23 +55,7 @@ func TestMapSupplier(t *testing.T) {
 9|Supplier#000000009|,gJ6K2MKveYxQT|IRAN     2|IRAN|MIDDLE EAST|20-338-906-3675|
 10|Supplier#000000010|9QtKQKXK24f|UNITED ST0|UNITED STATES|AMERICA|34-741-346-9870|
 `[1:]
-
-	r := strings.NewReader(data)
-	res := mapSupplier(r, 10)
-
-	if len(res) != 10 {
-		t.Fatalf("unexpected number of suppliers in result map: %v\n%v", len(res), res)
-	}
-	if string(res[8].city) != "PERU     7" {
-		t.Fatalf("res8.city: %s doesn't match PERU     7", res[8].city)
-	}
-	if string(res[1].nation) != "PERU" {
-		t.Fatalf("res1.nation: %s doesn't match PERU", res[1].nation)
-	}
-}
-
-func TestMapPart(t *testing.T) {
-	data := `
+	parts := `
 1|lace spring|MFGR#1|MFGR#11|MFGR#1121|goldenrod|PROMO BURNISHED COPPER|7|JUMBO PKG|
 2|rosy metallic|MFGR#4|MFGR#43|MFGR#4318|blush|LARGE BRUSHED BRASS|1|LG CASE|
 3|green antique|MFGR#3|MFGR#32|MFGR#3210|dark|STANDARD POLISHED BRASS|21|WRAP CASE|
@@ -74,26 +67,7 @@ func TestMapPart(t *testing.T) {
 9|rose moccasin|MFGR#4|MFGR#41|MFGR#4117|thistle|SMALL BURNISHED STEEL|12|WRAP CASE|
 10|moccasin royal|MFGR#2|MFGR#21|MFGR#2128|floral|LARGE BURNISHED STEEL|44|LG CAN|
 `[1:]
-
-	r := strings.NewReader(data)
-	res := mapPart(r, 10)
-
-	if len(res) != 10 {
-		t.Fatalf("unexpected number of parts in result map: %v\n%v", len(res), res)
-	}
-	if string(res[8].mfgr) != "MFGR#1" {
-		t.Fatalf("res8.mfgr: %s doesn't match MFGR#1", res[8].mfgr)
-	}
-	if string(res[5].category) != "MFGR#45" {
-		t.Fatalf("res5.category: %s doesn't match MFGR#45", res[5].category)
-	}
-	if string(res[1].brand1) != "MFGR#1121" {
-		t.Fatalf("res1.brand1: %s doesn't match MFGR#1121", res[1].brand1)
-	}
-}
-
-func TestMapDate(t *testing.T) {
-	data := `
+	dates := `
 19920101|January 1, 1992|Thursday|January|1992|199201|Jan1992|5|1|1|1|1|Winter|0|1|1|1|
 19920102|January 2, 1992|Friday|January|1992|199201|Jan1992|6|2|2|1|1|Winter|0|1|0|1|
 19920103|January 3, 1992|Saturday|January|1992|199201|Jan1992|7|3|3|1|1|Winter|1|1|0|0|
@@ -105,20 +79,272 @@ func TestMapDate(t *testing.T) {
 19920109|January 9, 1992|Friday|January|1992|199201|Jan1992|6|9|9|1|2|Winter|0|1|0|1|
 19920110|January 10, 1992|Saturday|January|1992|199201|Jan1992|7|10|10|1|2|Winter|1|1|0|0|
 `[1:]
+	m := &Main{}
+	testJoin(t, m, customers, parts, suppliers, dates)
+
+	rec, err := m.parseLineOrder("3|2|8|5|8|19920105|x|x|17|21168|x|4|20321|74711|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &record{
+		lo_orderkey:      "3",
+		lo_linenumber:    "2",
+		lo_quantity:      17,
+		lo_extendedprice: 21168,
+		lo_discount:      4,
+		lo_revenue:       20321,
+		lo_supplycost:    74711,
+		c_city:           "PERU     3",
+		c_nation:         "PERU",
+		c_region:         "AMERICA",
+		s_city:           "PERU     7",
+		s_nation:         "PERU",
+		s_region:         "AMERICA",
+		p_mfgr:           "MFGR#4",
+		p_category:       "MFGR#45",
+		p_brand1:         "MFGR#4510",
+		order_year:       1992,
+		order_month:      "January",
+		order_weeknum:    1,
+	}
+	if !reflect.DeepEqual(rec, exp) {
+		t.Fatalf("unexpected record:\n%v\nexpected:\n%v", rec, exp)
+	}
+
+	for _, line := range []string{
+		"3|2|11|5|8|19920105|x|x|17|2116823|x|4|2032150|74711|", // unknown customer
+		"3|2|8|5|8|19920105|x|x|many|2116823|x|4|2032150|74711|",
+		"3|2|8|5|8|19920105|x|x|17|",
+	} {
+		if rec, err := m.parseLineOrder(line); err == nil {
+			t.Errorf("expected error parsing %v, got %v", line, rec)
+		}
+	}
+}
+
+func TestMapRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trans, err := NewTranslator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := pdk.NewMemIndex(frames)
+	m := &Main{
+		trans: trans,
+		index: index,
+	}
+
+	rc := make(chan *record, 3)
+	rc <- &record{col: 0, lo_quantity: 10, lo_revenue: 500, lo_supplycost: 100, c_region: "ASIA", p_mfgr: "MFGR#1", order_year: 1992, order_month: "January"}
+	rc <- &record{col: 1, lo_quantity: 20, lo_revenue: 700, lo_supplycost: 200, c_region: "ASIA", p_mfgr: "MFGR#2", order_year: 1993, order_month: "March"}
+	rc <- &record{col: 2, lo_quantity: 30, lo_revenue: 900, lo_supplycost: 300, c_region: "EUROPE", p_mfgr: "MFGR#1", order_year: 1992, order_month: "March"}
+	close(rc)
+	m.mapRecords(rc)
+
+	asia, err := trans.GetID("c_region", "ASIA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols := index.Row("c_region", asia); !reflect.DeepEqual(cols, []uint64{0, 1}) {
+		t.Fatalf("unexpected ASIA columns: %v", cols)
+	}
+	if cols := index.Row("lo_year", 1992); !reflect.DeepEqual(cols, []uint64{0, 2}) {
+		t.Fatalf("unexpected 1992 columns: %v", cols)
+	}
+	if val, ok := index.Value("lo_profit", "lo_profit", 2); !ok || val != 600 {
+		t.Fatalf("unexpected profit for column 2: %v, %v", val, ok)
+	}
 
-	r := strings.NewReader(data)
-	res := mapDate(r, 10)
+	count, err := index.Execute(&pql.Call{
+		Name: "Count",
+		Children: []*pql.Call{{
+			Name: "Intersect",
+			Children: []*pql.Call{
+				{Name: "Bitmap", Args: map[string]interface{}{"frame": "lo_month", "rowID": int64(months["March"])}},
+				{Name: "Bitmap", Args: map[string]interface{}{"frame": "c_region", "rowID": int64(asia)}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != uint64(1) {
+		t.Fatalf("expected 1 ASIA record in March, got %v", count)
+	}
+}
+
+// dimension tables with a row for each key of the lineorder rows in the
+// tests of readLineOrder.
+const (
+	testCustomers = "7|Customer#000000007|addr|CHINA    1|CHINA|ASIA|phone|AUTOMOBILE|\n"
+	testParts     = "8|khaki cream|MFGR#1|MFGR#13|MFGR#1328|ivory|PROMO BURNISHED TIN|41|LG DRUM|\n"
+	testSuppliers = "9|Supplier#000000009|addr|IRAN     2|IRAN|EUROPE|phone|\n"
+	testDates     = "19920101|January 1, 1992|Thursday|January|1992|199201|Jan1992|5|1|1|1|1|Winter|0|1|1|1|\n"
+)
 
-	if len(res) != 10 {
-		t.Fatalf("unexpected number of dates in result map: %v\n%v", len(res), res)
+func TestReadLineOrderResume(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := "1|1|7|8|9|19920101|x|x|10|1000|x|5|900|50|\n1|2|7|8|9|19920101|x|x|20|2000|x|5|1800|60|\n1|3|99|8|9|19920101|x|x|20|2000|x|5|1800|60|\n"
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	frag, err := pdk.NewFileFragment(f, 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
 	}
-	if res[19920108].year != 1992 {
-		t.Fatalf("res8.year: %d doesn't match 1992: %#v", res[19920108].year, res)
+	ckpt, err := pdk.NewCheckpoint("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Main{ckpt: ckpt}
+	testJoin(t, m, testCustomers, testParts, testSuppliers, testDates)
+	ckpt.Reserve("other", 5)
+
+	rc := make(chan *record, 3)
+	m.readLineOrder(frag, rc)
+	close(rc)
+	var cols []uint64
+	for rec := range rc {
+		cols = append(cols, rec.col)
+		rec.progress.Done()
+	}
+	// the third line's customer is unknown, but it still uses a column
+	if !reflect.DeepEqual(cols, []uint64{5, 6}) {
+		t.Fatalf("unexpected columns: %v", cols)
+	}
+	if !ckpt.Done("lineorder.tbl:0") {
+		t.Fatal("expected chunk to be done")
+	}
+
+	// a done chunk is skipped
+	frag, err = pdk.NewFileFragment(f, 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc = make(chan *record, 3)
+	m.readLineOrder(frag, rc)
+	close(rc)
+	if len(rc) != 0 {
+		t.Fatalf("expected done chunk to be skipped, got %d records", len(rc))
+	}
+}
+
+func TestReadLineOrderKeyColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if string(res[19920105].month) != "January" {
-		t.Fatalf("res5.month: %s doesn't match January", res[19920105].month)
+	defer os.RemoveAll(dir)
+	trans, err := NewTranslator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := "1|1|7|8|9|19920101|x|x|10|1000|x|5|900|50|\n1|2|7|8|9|19920101|x|x|20|2000|x|5|1800|60|\n"
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// reading the file twice, without a checkpoint, gives the same columns
+	var runs [][]uint64
+	for i := 0; i < 2; i++ {
+		ckpt, err := pdk.NewCheckpoint("", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ckpt.Reserve("other", uint64(10*i))
+		m := &Main{ckpt: ckpt, trans: trans, KeyColumns: true}
+		testJoin(t, m, testCustomers, testParts, testSuppliers, testDates)
+		frag, err := pdk.NewFileFragment(f, 0, int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := make(chan *record, 2)
+		m.readLineOrder(frag, rc)
+		close(rc)
+		var cols []uint64
+		for rec := range rc {
+			cols = append(cols, rec.col)
+		}
+		runs = append(runs, cols)
+	}
+	if len(runs[0]) != 2 || runs[0][0] == runs[0][1] || !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Fatalf("expected the same two columns from each run, got %v", runs)
+	}
+	key, err := trans.GetID(keyFrame, []byte(`1,2`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != runs[0][1] {
+		t.Fatalf("expected key 1,2 to map to column %v, got %v", runs[0][1], key)
+	}
+}
+
+func TestSplitLineOrderResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := "1|1|7|8|9|19920101|x|x|10|1000|x|5|900|50|\n1|2|7|8|9|19920101|x|x|20|2000|x|5|1800|60|\n"
+	if err := ioutil.WriteFile(dir+"/lineorder.tbl", []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := dir + "/checkpoint.json"
+
+	ckpt, err := pdk.NewCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Main{Dir: dir, ReadConcurrency: 2, ckpt: ckpt}
+	if _, err := m.splitLineOrder(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ckpt.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// chunk names are offsets, which only match if the file is split the
+	// same way
+	for _, test := range []struct {
+		concurrency int
+		ok          bool
+	}{{1, false}, {2, true}} {
+		ckpt, err := pdk.NewCheckpoint(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Main{Dir: dir, ReadConcurrency: test.concurrency, ckpt: ckpt}
+		if _, err := m.splitLineOrder(); (err == nil) != test.ok {
+			t.Errorf("resuming with read concurrency %v: unexpected error %v", test.concurrency, err)
+		}
+	}
+}
+
+func TestMainFrames(t *testing.T) {
+	for _, keyColumns := range []bool{false, true} {
+		m := &Main{KeyColumns: keyColumns}
+		for _, spec := range m.frames() {
+			if exp := keyColumns && len(spec.Fields) == 0; spec.Mutex != exp {
+				t.Errorf("KeyColumns=%v: expected frame %v to have Mutex=%v", keyColumns, spec.Name, exp)
+			}
+		}
 	}
-	if res[19920101].weeknum != 1 {
-		t.Fatalf("res1.weeknum: %d doesn't match 1", res[19920101].weeknum)
+	for _, spec := range frames {
+		if spec.Mutex {
+			t.Errorf("frames() changed frame %v in place", spec.Name)
+		}
 	}
 }
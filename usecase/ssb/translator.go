@@ -12,7 +12,7 @@ type Translator struct {
 }
 
 func NewTranslator(storedir string) (*Translator, error) {
-	lt, err := pdk.NewLevelTranslator(storedir, []string{"c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1"}...)
+	lt, err := pdk.NewLevelTranslator(storedir, []string{"c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1", keyFrame}...)
 	if err != nil {
 		return nil, err
 	}
@@ -21,18 +21,34 @@ func NewTranslator(storedir string) (*Translator, error) {
 	}, nil
 }
 
+// Get returns the value of id in frame, or nil if id hasn't been mapped.
 func (t *Translator) Get(frame string, id uint64) interface{} {
+	val, _, err := t.LookupValue(frame, id)
+	if err != nil {
+		log.Printf("ssb.Translator.Get frame: %v, id: %v: %v", frame, id, err)
+	}
+	return val
+}
+
+// LookupValue returns the value of id in frame. ok is false if id hasn't been
+// mapped.
+func (t *Translator) LookupValue(frame string, id uint64) (interface{}, bool, error) {
 	switch frame {
-	case "c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1":
-		val := t.lt.Get(frame, id)
-		return string(val.([]byte))
+	case "c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1", keyFrame:
+		val, ok, err := t.lt.LookupValue(frame, id)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		return string(val.([]byte)), true, nil
 	case "lo_month":
-		return monthsSlice[id]
+		if id >= uint64(len(monthsSlice)) {
+			return nil, false, nil
+		}
+		return monthsSlice[id], true, nil
 	case "lo_weeknum", "lo_year", "lo_quantity_b", "lo_discount_b":
-		return id
+		return id, true, nil
 	default:
-		log.Printf("Unimplemented in ssb.Translator.Get frame: %v, id: %v", frame, id)
-		return nil
+		return nil, false, fmt.Errorf("Unimplemented in ssb.Translator frame: %v", frame)
 	}
 }
 
@@ -40,6 +56,8 @@ func (t *Translator) GetID(frame string, val interface{}) (uint64, error) {
 	switch frame {
 	case "c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1":
 		return t.lt.GetID(frame, []byte(val.(string)))
+	case keyFrame:
+		return t.lt.GetID(frame, val)
 	case "lo_month":
 		valstring := val.(string)
 		m, ok := months[valstring]
@@ -64,6 +82,38 @@ func (t *Translator) GetID(frame string, val interface{}) (uint64, error) {
 	}
 }
 
+// LookupID is GetID without allocating ids for unknown values.
+func (t *Translator) LookupID(frame string, val interface{}) (uint64, bool, error) {
+	switch frame {
+	case "c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1", keyFrame:
+		return t.lt.LookupID(frame, val)
+	case "lo_month":
+		m, ok := months[fmt.Sprint(val)]
+		return m, ok, nil
+	}
+	id, err := t.GetID(frame, val)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// RangeKeys calls fn with each value of frame, until fn returns false.
+func (t *Translator) RangeKeys(frame string, fn func(key string) bool) error {
+	switch frame {
+	case "c_city", "c_nation", "c_region", "s_city", "s_nation", "s_region", "p_mfgr", "p_category", "p_brand1", keyFrame:
+		return t.lt.RangeKeys(frame, fn)
+	case "lo_month":
+		for _, month := range monthsSlice {
+			if !fn(month) {
+				break
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unimplemented in ssb.Translator.RangeKeys frame: %v", frame)
+}
+
 var months = map[string]uint64{
 	"January":   0,
 	"February":  1,
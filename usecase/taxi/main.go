@@ -18,7 +18,6 @@ import (
 
 	pcli "github.com/pilosa/go-pilosa"
 	"github.com/pilosa/pdk"
-	"github.com/pilosa/pilosa"
 )
 
 /***************
@@ -67,6 +66,39 @@ var yellowFields = map[string]int{
 	"improvement_surcharge": 17,
 }
 
+// yellowLegacyFields are the positions of the fields in yellow files from
+// before 2015, which have no improvement_surcharge column.
+var yellowLegacyFields = map[string]int{
+	"vendor_id":          0,
+	"pickup_datetime":    1,
+	"dropoff_datetime":   2,
+	"passenger_count":    3,
+	"trip_distance":      4,
+	"pickup_longitude":   5,
+	"pickup_latitude":    6,
+	"ratecode_id":        7,
+	"store_and_fwd_flag": 8,
+	"dropoff_longitude":  9,
+	"dropoff_latitude":   10,
+	"payment_type":       11,
+	"fare_amount":        12,
+	"extra":              13,
+	"mta_tax":            14,
+	"tip_amount":         15,
+	"tolls_amount":       16,
+	"total_amount":       17,
+}
+
+// variantLegacy is the CSV variant of files laid out as yellowLegacyFields,
+// whose headers start with vendor_id (2010-2014) or vendor_name (2009).
+const variantLegacy = "legacy"
+
+var taxiVariants = []pdk.CSVVariant{
+	{Name: variantLegacy, Required: []string{"vendor_id"}},
+	{Name: variantLegacy, Required: []string{"vendor_name"}},
+	{Name: "current"},
+}
+
 /***********************
 use case implementation
 ***********************/
@@ -83,14 +115,40 @@ type Main struct {
 	Concurrency      int
 	Index            string
 	BufferSize       int
-
-	importer  pdk.PilosaImporter
-	urls      []string
-	greenBms  []pdk.BitMapper
-	yellowBms []pdk.BitMapper
-	ams       []pdk.AttrMapper
-
-	nexter *Nexter
+	// MetricsAddr is the address the import metrics are served on.
+	MetricsAddr string
+	// MaxBuffered limits the bits buffered per frame while they are grouped
+	// into batches by slice. Defaults to BufferSize.
+	MaxBuffered int
+	// Backend is the pdk.NewIndexer backend to import with.
+	Backend string
+	// OutputDir, if set, is a directory to write import files to instead of
+	// importing into Pilosa.
+	OutputDir string
+	// SpoolDir is where batches which Pilosa fails to import are kept until
+	// they can be replayed.
+	SpoolDir string
+	// MutexDir is where the rows set in the mutex frames (cab_type and
+	// passenger_count) are kept, so that a --resume or a later import of the
+	// same columns clears them. If empty they are kept in memory.
+	MutexDir string
+	// Throttle limits how fast bits are sent to Pilosa.
+	Throttle pdk.ThrottleConfig
+	// Checkpoint is the file in which progress is saved every
+	// CheckpointInterval. If Resume is set, URLs which were imported
+	// completely by a previous run are skipped.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             bool
+
+	indexer         pdk.Indexer
+	urls            []string
+	greenBms        []pdk.BitMapper
+	yellowBms       []pdk.BitMapper
+	yellowLegacyBms []pdk.BitMapper
+	ams             []pdk.AttrMapper
+
+	ckpt *pdk.Checkpoint
 
 	totalBytes int64
 	bytesLock  sync.Mutex
@@ -111,7 +169,7 @@ func NewMain() *Main {
 	m := &Main{
 		Concurrency:      1,
 		FetchConcurrency: 1,
-		nexter:           &Nexter{},
+		MetricsAddr:      "localhost:6060",
 		urls:             make([]string, 0),
 
 		totalRecs:     &Counter{},
@@ -130,67 +188,78 @@ func NewMain() *Main {
 }
 
 func (m *Main) Run() error {
+	var err error
+	m.ckpt, err = pdk.NewCheckpoint(m.Checkpoint, m.Resume)
+	if err != nil {
+		return err
+	}
+	m.registerStats()
 	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
+		log.Println(pdk.StartMetricsServer(m.MetricsAddr))
 	}()
 
-	err := m.readURLs()
+	err = m.readURLs()
 	if err != nil {
 		return err
 	}
 
-	frames := []string{"cab_type", "passenger_count", "total_amount_dollars", "pickup_time", "pickup_day", "pickup_mday", "pickup_month", "pickup_year", "drop_time", "drop_day", "drop_mday", "drop_month", "drop_year", "dist_miles", "duration_minutes", "speed_mph", "pickup_grid_id", "drop_grid_id", "pickup_elevation", "drop_elevation"}
-	m.importer = pdk.NewImportClient(m.PilosaHost, m.Index, frames, m.BufferSize)
+	m.greenBms = getBitMappers(greenFields)
+	m.yellowBms = getBitMappers(yellowFields)
+	m.yellowLegacyBms = getBitMappers(yellowLegacyFields)
+	m.ams = getAttrMappers()
 
-	pilosaURI, err := pcli.NewURIFromAddress(m.PilosaHost)
-	if err != nil {
-		return fmt.Errorf("interpreting pilosaHost '%v': %v", m.PilosaHost, err)
-	}
-	setupClient := pcli.NewClientWithURI(pilosaURI)
-	index, err := pcli.NewIndex(m.Index, &pcli.IndexOptions{})
-	if err != nil {
-		return fmt.Errorf("making index: %v", err)
+	frames := []string{"cab_type", "passenger_count", "total_amount_dollars", "pickup_time", "pickup_day", "pickup_mday", "pickup_month", "pickup_year", "drop_time", "drop_day", "drop_mday", "drop_month", "drop_year", "dist_miles", "duration_minutes", "speed_mph", "pickup_grid_id", "drop_grid_id", "pickup_elevation", "drop_elevation"}
+	schema := pdk.Schema{Index: m.Index}
+	for _, frame := range frames {
+		schema.Frames = append(schema.Frames, pdk.FrameSpec{Name: frame, CacheType: pcli.CacheTypeRanked})
 	}
-	err = setupClient.EnsureIndex(index)
+	// cab_type is set by mapAndPost rather than by a BitMapper
+	schema.Frames[0].Mutex = true
+	pdk.SetMutex(schema.Frames, m.greenBms...)
+	pdk.SetMutex(schema.Frames, m.yellowBms...)
+	pdk.SetMutex(schema.Frames, m.yellowLegacyBms...)
+	m.indexer, err = pdk.NewIndexer(pdk.IndexerConfig{
+		Backend:     m.Backend,
+		Hosts:       []string{m.PilosaHost},
+		BatchSize:   m.BufferSize,
+		MaxBuffered: m.MaxBuffered,
+		OutputDir:   m.OutputDir,
+		SpoolDir:    m.SpoolDir,
+		Throttle:    m.Throttle,
+		Resume:      m.Resume,
+		MutexDir:    m.MutexDir,
+	}, schema)
 	if err != nil {
-		return fmt.Errorf("ensuring index existence: %v", err)
+		return fmt.Errorf("creating indexer: %v", err)
 	}
-	for _, frame := range frames {
-		fram, err := index.Frame(frame, &pcli.FrameOptions{CacheType: pilosa.CacheTypeRanked})
-		if err != nil {
-			return fmt.Errorf("making frame: %v", err)
-		}
-		err = setupClient.EnsureFrame(fram)
-		if err != nil {
-			return fmt.Errorf("creating frame '%v': %v", frame, err)
-		}
-	}
-
-	ticker := m.printStats()
 
 	urls := make(chan string, 100)
 	records := make(chan Record, 10000)
 
 	go func() {
 		for _, url := range m.urls {
+			if m.ckpt.Done(url) {
+				log.Printf("skipping %v, which was imported by a previous run", url)
+				continue
+			}
 			urls <- url
 		}
 		close(urls)
 	}()
 
-	m.greenBms = getBitMappers(greenFields)
-	m.yellowBms = getBitMappers(yellowFields)
-	m.ams = getAttrMappers()
-
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		for range c {
-			log.Printf("Rides: %d, Bytes: %s", m.nexter.Last(), pdk.Bytes(m.BytesProcessed()))
+			log.Printf("Rides: %d, Bytes: %s", m.ckpt.NextID(), pdk.Bytes(m.BytesProcessed()))
+			if err := m.ckpt.Save(m.indexer); err != nil {
+				log.Printf("saving checkpoint: %v", err)
+			}
 			os.Exit(0)
 		}
 	}()
 
+	stopCheckpoints := m.ckpt.SaveEvery(m.indexer, m.CheckpointInterval)
 	var wg sync.WaitGroup
 	for i := 0; i < m.FetchConcurrency; i++ {
 		wg.Add(1)
@@ -210,9 +279,12 @@ func (m *Main) Run() error {
 	wg.Wait()
 	close(records)
 	wg2.Wait()
-	m.importer.Close()
-	ticker.Stop()
-	return err
+	stopCheckpoints()
+	err = m.indexer.Close()
+	if err != nil {
+		return fmt.Errorf("importing: %v", err)
+	}
+	return m.ckpt.Save(nil)
 }
 
 func (m *Main) readURLs() error {
@@ -233,18 +305,21 @@ func (m *Main) readURLs() error {
 	return nil
 }
 
-func (m *Main) printStats() *time.Ticker {
-	t := time.NewTicker(time.Second * 10)
-	start := time.Now()
-	go func() {
-		for range t.C {
-			duration := time.Since(start)
-			bytes := m.BytesProcessed()
-			log.Printf("Rides: %d, Bytes: %s, Records: %v, Duration: %v, Rate: %v/s", m.nexter.Last(), pdk.Bytes(bytes), m.totalRecs.Get(), duration, pdk.Bytes(float64(bytes)/duration.Seconds()))
-			log.Printf("Skipped: %v, badLocs: %v, nullLocs: %v, badSpeeds: %v, badTotalAmnts: %v, badDurations: %v, badUnknowns: %v, badPassCounts: %v, badDist: %v", m.skippedRecs.Get(), m.badLocs.Get(), m.nullLocs.Get(), m.badSpeeds.Get(), m.badTotalAmnts.Get(), m.badDurations.Get(), m.badUnknowns.Get(), m.badPassCounts.Get(), m.badDist.Get())
-		}
-	}()
-	return t
+// registerStats exposes the use case's counters alongside the import
+// metrics, at /metrics and /debug/vars.
+func (m *Main) registerStats() {
+	pdk.Stats.RegisterCounter("taxi_rides_total", "Columns allocated.", func() int64 { return int64(m.ckpt.NextID()) })
+	pdk.Stats.RegisterCounter("taxi_bytes_total", "Bytes of records read.", m.BytesProcessed)
+	pdk.Stats.RegisterCounter("taxi_records_total", "Records read.", m.totalRecs.Get)
+	pdk.Stats.RegisterCounter("taxi_skipped_records_total", "Records skipped for any reason.", m.skippedRecs.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_locations_total", "Records skipped with locations out of range.", m.badLocs.Get)
+	pdk.Stats.RegisterCounter("taxi_null_locations_total", "Records skipped with locations of (0, 0).", m.nullLocs.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_speeds_total", "Records skipped with speeds out of range.", m.badSpeeds.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_total_amounts_total", "Records skipped with total amounts out of range.", m.badTotalAmnts.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_durations_total", "Records skipped with durations out of range.", m.badDurations.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_passenger_counts_total", "Records skipped with passenger counts out of range.", m.badPassCounts.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_distances_total", "Records skipped with distances out of range.", m.badDist.Get)
+	pdk.Stats.RegisterCounter("taxi_bad_unknowns_total", "Records skipped for other reasons.", m.badUnknowns.Get)
 }
 
 // getNextURL fetches the next url from the channel, or if it is emtpy, gets a
@@ -310,51 +385,100 @@ func (m *Main) fetch(urls <-chan string, records chan<- Record) {
 			log.Printf("closing %s, err: %v", url, err)
 		}
 
-		buf := bytes.NewBuffer(contentBytes)
-
-		scan := bufio.NewScanner(buf)
-		// discard header line
-		correctLine := false
-		if scan.Scan() {
-			header := scan.Text()
-			if strings.HasPrefix(header, "vendor_") {
-				correctLine = true
-			}
+		// files from the TLC archive may be gzip or bzip2 compressed, which
+		// the CSV source detects. The header selects the field layout.
+		src := pdk.NewCSVSource(bytes.NewReader(contentBytes), url, taxiVariants...)
+		// some files pad their rows with empty trailing fields
+		src.AllowRagged()
+		header, err := src.Header()
+		if err != nil {
+			log.Printf("reading %s, err: %v", url, err)
+			delete(failedURLs, url)
+			continue
+		}
+		// records are reserved columns and checkpointed in chunks, so that
+		// they are mapped as they are read, and a resumed import skips the
+		// chunks which were done. The url itself is done once every chunk
+		// is, and only if it was read to the end.
+		urlProgress := m.ckpt.Start(url, 1)
+		chunk := make([][]string, 0, urlChunk)
+		chunks := 0
+		sendChunk := func() {
+			m.sendChunk(fmt.Sprintf("%s:%d", url, chunks), chunk, typ, header.Variant, urlProgress, records)
+			chunk = make([][]string, 0, urlChunk)
+			chunks++
 		}
-		for scan.Scan() {
+		complete := true
+		for {
+			rec, err := src.Record()
+			if err == io.EOF {
+				break
+			}
 			m.totalRecs.Add(1)
-			record := scan.Text()
-			m.AddBytes(len(record))
-			if correctLine {
-				// last field needs to be shifted over by 1
-				lastcomma := strings.LastIndex(record, ",")
-				if lastcomma == -1 {
-					m.skippedRecs.Add(1)
-					continue
-				}
-				record = record[:lastcomma] + "," + record[lastcomma:]
+			if serr, ok := err.(*pdk.SourceError); ok {
+				log.Printf("skipping malformed record: %v", serr)
+				m.skippedRecs.Add(1)
+				continue
+			} else if err != nil {
+				log.Printf("reading %s, err: %v", url, err)
+				complete = false
+				break
+			}
+			m.AddBytes(recordBytes(rec.Fields))
+			chunk = append(chunk, rec.Fields)
+			if len(chunk) == urlChunk {
+				sendChunk()
 			}
-			records <- Record{Val: record, Type: typ}
-		}
-		err = scan.Err()
-		if err != nil {
-			log.Printf("scan error on %s, err: %v", url, err)
 		}
 		delete(failedURLs, url)
+		if !complete {
+			// the rest of the url is left for a resumed import to try again
+			continue
+		}
+		if len(chunk) > 0 {
+			sendChunk()
+		}
+		urlProgress.Done()
+	}
+}
+
+// urlChunk is the number of records of a url which are reserved columns and
+// checkpointed together.
+const urlChunk = 100000
+
+// sendChunk gives the records of a chunk of a url the block of columns
+// reserved for the chunk, and sends them to be mapped, unless the chunk was
+// done by a previous run.
+func (m *Main) sendChunk(name string, chunk [][]string, typ rune, variant string, urlProgress *pdk.InputProgress, records chan<- Record) {
+	if m.ckpt.Done(name) {
+		return
+	}
+	first := m.ckpt.Reserve(name, uint64(len(chunk)))
+	progress := m.ckpt.Start(name, len(chunk))
+	urlProgress.Add(len(chunk))
+	for i, fields := range chunk {
+		records <- Record{Fields: fields, Type: typ, Variant: variant, Col: first + uint64(i), progress: progress, urlProgress: urlProgress}
 	}
 }
 
 type Record struct {
-	Type rune
-	Val  string
+	Type    rune
+	Fields  []string
+	Variant string
+	Col     uint64
+
+	progress    *pdk.InputProgress
+	urlProgress *pdk.InputProgress
 }
 
-func (r Record) Clean() ([]string, bool) {
-	if len(r.Val) == 0 {
-		return nil, false
+// recordBytes approximates the size of a record in the input from its
+// fields, counting a delimiter after each.
+func recordBytes(fields []string) int {
+	n := len(fields)
+	for _, field := range fields {
+		n += len(field)
 	}
-	fields := strings.Split(r.Val, ",")
-	return fields, true
+	return n
 }
 
 type BitFrame struct {
@@ -363,106 +487,121 @@ type BitFrame struct {
 }
 
 func (m *Main) parseMapAndPost(records <-chan Record) {
-Records:
 	for record := range records {
-		fields, ok := record.Clean()
-		if !ok {
-			m.skippedRecs.Add(1)
-			continue
+		m.mapAndPost(record)
+		if record.progress != nil {
+			record.progress.Done()
 		}
-		var bms []pdk.BitMapper
-		var cabType uint64
-		if record.Type == 'g' {
-			bms = m.greenBms
-			cabType = 0
-		} else if record.Type == 'y' {
-			bms = m.yellowBms
-			cabType = 1
-		} else {
-			log.Println("unknown record type")
-			m.badUnknowns.Add(1)
-			m.skippedRecs.Add(1)
-			continue
+		if record.urlProgress != nil {
+			record.urlProgress.Done()
+		}
+	}
+}
+
+func (m *Main) mapAndPost(record Record) {
+	fields := record.Fields
+	if len(fields) == 0 {
+		m.skippedRecs.Add(1)
+		return
+	}
+	var bms []pdk.BitMapper
+	var cabType uint64
+	if record.Type == 'g' {
+		bms = m.greenBms
+		cabType = 0
+	} else if record.Type == 'y' && record.Variant == variantLegacy {
+		bms = m.yellowLegacyBms
+		cabType = 1
+	} else if record.Type == 'y' {
+		bms = m.yellowBms
+		cabType = 1
+	} else {
+		log.Println("unknown record type")
+		m.badUnknowns.Add(1)
+		m.skippedRecs.Add(1)
+		return
+	}
+	bitsToSet := make([]BitFrame, 0)
+	bitsToSet = append(bitsToSet, BitFrame{Bit: cabType, Frame: "cab_type"})
+	for _, bm := range bms {
+		if len(bm.Fields) != len(bm.Parsers) {
+			// TODO if len(pm.Parsers) == 1, use that for all fields
+			log.Fatalf("parse: BitMapper has different number of fields: %v and parsers: %v", bm.Fields, bm.Parsers)
 		}
-		bitsToSet := make([]BitFrame, 0)
-		bitsToSet = append(bitsToSet, BitFrame{Bit: cabType, Frame: "cab_type"})
-		for _, bm := range bms {
-			if len(bm.Fields) != len(bm.Parsers) {
-				// TODO if len(pm.Parsers) == 1, use that for all fields
-				log.Fatalf("parse: BitMapper has different number of fields: %v and parsers: %v", bm.Fields, bm.Parsers)
-			}
 
-			// parse fields into a slice `parsed`
-			parsed := make([]interface{}, 0, len(bm.Fields))
-			for n, fieldnum := range bm.Fields {
-				parser := bm.Parsers[n]
-				if fieldnum >= len(fields) {
-					log.Printf("parse: field index: %v out of range for: %v", fieldnum, fields)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				parsedField, err := parser.Parse(fields[fieldnum])
-				if err != nil && fields[fieldnum] == "" {
-					m.skippedRecs.Add(1)
-					continue Records
-				} else if err != nil {
-					log.Printf("parsing: field: %v err: %v bm: %v rec: %v", fields[fieldnum], err, bm, record)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				parsed = append(parsed, parsedField)
+		// parse fields into a slice `parsed`
+		parsed := make([]interface{}, 0, len(bm.Fields))
+		for n, fieldnum := range bm.Fields {
+			parser := bm.Parsers[n]
+			if fieldnum >= len(fields) {
+				log.Printf("parse: field index: %v out of range for: %v", fieldnum, fields)
+				m.skippedRecs.Add(1)
+				return
 			}
+			parsedField, err := parser.Parse(fields[fieldnum])
+			if err != nil && fields[fieldnum] == "" {
+				m.skippedRecs.Add(1)
+				return
+			} else if err != nil {
+				log.Printf("parsing: field: %v err: %v bm: %v rec: %v", fields[fieldnum], err, bm, record)
+				m.skippedRecs.Add(1)
+				return
+			}
+			parsed = append(parsed, parsedField)
+		}
 
-			// map those fields to a slice of IDs
-			ids, err := bm.Mapper.ID(parsed...)
-			if err != nil {
-				if err.Error() == "point (0, 0) out of range" {
-					m.nullLocs.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if strings.Contains(bm.Frame, "grid_id") && strings.Contains(err.Error(), "out of range") {
-					m.badLocs.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if bm.Frame == "speed_mph" && strings.Contains(err.Error(), "out of range") {
-					m.badSpeeds.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if bm.Frame == "total_amount_dollars" && strings.Contains(err.Error(), "out of range") {
-					m.badTotalAmnts.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if bm.Frame == "duration_minutes" && strings.Contains(err.Error(), "out of range") {
-					m.badDurations.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if bm.Frame == "passenger_count" && strings.Contains(err.Error(), "out of range") {
-					m.badPassCounts.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				if bm.Frame == "dist_miles" && strings.Contains(err.Error(), "out of range") {
-					m.badDist.Add(1)
-					m.skippedRecs.Add(1)
-					continue Records
-				}
-				log.Printf("mapping: bm: %v, err: %v rec: %v", bm, err, record)
+		// map those fields to a slice of IDs
+		ids, err := bm.Mapper.ID(parsed...)
+		if err != nil {
+			if err.Error() == "point (0, 0) out of range" {
+				m.nullLocs.Add(1)
+				m.skippedRecs.Add(1)
+				return
+			}
+			if strings.Contains(bm.Frame, "grid_id") && strings.Contains(err.Error(), "out of range") {
+				m.badLocs.Add(1)
+				m.skippedRecs.Add(1)
+				return
+			}
+			if bm.Frame == "speed_mph" && strings.Contains(err.Error(), "out of range") {
+				m.badSpeeds.Add(1)
+				m.skippedRecs.Add(1)
+				return
+			}
+			if bm.Frame == "total_amount_dollars" && strings.Contains(err.Error(), "out of range") {
+				m.badTotalAmnts.Add(1)
+				m.skippedRecs.Add(1)
+				return
+			}
+			if bm.Frame == "duration_minutes" && strings.Contains(err.Error(), "out of range") {
+				m.badDurations.Add(1)
 				m.skippedRecs.Add(1)
-				m.badUnknowns.Add(1)
-				continue Records
+				return
 			}
-			for _, id := range ids {
-				bitsToSet = append(bitsToSet, BitFrame{Bit: uint64(id), Frame: bm.Frame})
+			if bm.Frame == "passenger_count" && strings.Contains(err.Error(), "out of range") {
+				m.badPassCounts.Add(1)
+				m.skippedRecs.Add(1)
+				return
 			}
+			if bm.Frame == "dist_miles" && strings.Contains(err.Error(), "out of range") {
+				m.badDist.Add(1)
+				m.skippedRecs.Add(1)
+				return
+			}
+			log.Printf("mapping: bm: %v, err: %v rec: %v", bm, err, record)
+			m.skippedRecs.Add(1)
+			m.badUnknowns.Add(1)
+			return
+		}
+		for _, id := range ids {
+			bitsToSet = append(bitsToSet, BitFrame{Bit: uint64(id), Frame: bm.Frame})
 		}
-		columnID := m.nexter.Next()
-		for _, bit := range bitsToSet {
-			m.importer.SetBit(bit.Bit, columnID, bit.Frame)
+	}
+	columnID := record.Col
+	for _, bit := range bitsToSet {
+		err := m.indexer.AddBit(bit.Frame, columnID, bit.Bit)
+		if err != nil {
+			log.Printf("adding bit: %v", err)
 		}
 	}
 }
@@ -536,6 +675,7 @@ func getBitMappers(fields map[string]int) []pdk.BitMapper {
 			Mapper:  pdk.IntMapper{Min: 0, Max: 9},
 			Parsers: []pdk.Parser{pdk.IntParser{}},
 			Fields:  []int{fields["passenger_count"]},
+			Mutex:   true,
 		},
 		pdk.BitMapper{
 			Frame:   "total_amount_dollars",
@@ -680,25 +820,3 @@ func (c *Counter) Get() (ret int64) {
 	c.lock.Unlock()
 	return
 }
-
-// Nexter generates unique sequential ids in a threadsafe way.
-type Nexter struct {
-	id   uint64
-	lock sync.Mutex
-}
-
-// Next generates a new id
-func (n *Nexter) Next() (nextID uint64) {
-	n.lock.Lock()
-	nextID = n.id
-	n.id++
-	n.lock.Unlock()
-	return
-}
-
-func (n *Nexter) Last() (lastID uint64) {
-	n.lock.Lock()
-	lastID = n.id - 1
-	n.lock.Unlock()
-	return
-}
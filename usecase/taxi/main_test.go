@@ -1,6 +1,15 @@
 package taxi
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilosa/pdk"
+)
 
 func TestFetchAndParse(t *testing.T) {
 	/*
@@ -24,6 +33,79 @@ func TestFetchAndParse(t *testing.T) {
 	*/
 }
 
+func TestFetchCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := "VendorID,tpep_pickup_datetime\n1,2016-01-01 00:00:00\n2,2016-01-01 00:01:00\n1,2016-01-01 00:02:00\n"
+	complete := filepath.Join(dir, "yellow_complete.csv")
+	if err := ioutil.WriteFile(complete, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "yellow_truncated.csv.gz")
+	if err := ioutil.WriteFile(truncated, gz.Bytes()[:gz.Len()-10], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	m.ckpt, err = pdk.NewCheckpoint("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(url string) []Record {
+		urls := make(chan string, 1)
+		urls <- url
+		close(urls)
+		records := make(chan Record, 10)
+		m.fetch(urls, records)
+		close(records)
+		var recs []Record
+		for rec := range records {
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+
+	recs := fetch(complete)
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records, got %v", len(recs))
+	}
+	for i, rec := range recs {
+		if rec.Col != uint64(i) {
+			t.Errorf("record %v: expected column %v, got %v", i, i, rec.Col)
+		}
+		if m.ckpt.Done(complete) {
+			t.Fatal("url done before its records were mapped")
+		}
+		rec.progress.Done()
+		rec.urlProgress.Done()
+	}
+	if !m.ckpt.Done(complete) || !m.ckpt.Done(complete+":0") {
+		t.Fatal("expected url and its chunk to be done")
+	}
+	if recs := fetch(complete); len(recs) != 0 {
+		t.Fatalf("expected done chunk to be skipped, got %v records", len(recs))
+	}
+
+	// a url which can't be read to the end is left to be imported again
+	if recs := fetch(truncated); len(recs) != 0 {
+		t.Fatalf("expected no records from a truncated url, got %v", len(recs))
+	}
+	if m.ckpt.Done(truncated) {
+		t.Fatal("truncated url marked done")
+	}
+}
+
 func TestTimeMapper(t *testing.T) {
 	// TODO implement
 }
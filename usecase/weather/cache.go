@@ -10,6 +10,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/pilosa/pdk"
 )
 
 type WeatherCache struct {
@@ -87,7 +89,7 @@ func (c *WeatherCache) ReadAll() error {
 			}
 			content = f
 		}
-		body, err := ioutil.ReadAll(content)
+		body, err := ioutil.ReadAll(pdk.NewDecompressReader(content, url))
 		if err != nil {
 			fmt.Printf("error reading content: %v\n", url)
 			continue
@@ -98,7 +100,8 @@ func (c *WeatherCache) ReadAll() error {
 			// fmt.Printf("error unmarshalling json %v: %v\n", url, err)
 			continue
 		}
-		datestr := url[len(url)-13 : len(url)-5]
+		name := pdk.TrimCompressionExt(url)
+		datestr := name[len(name)-13 : len(name)-5]
 		c.data[datestr] = record.History
 	}
 	return nil
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	pcli "github.com/pilosa/go-pilosa"
 	"github.com/pilosa/pdk"
+	"log"
 	"net/http"
 	"time"
 )
@@ -27,11 +28,15 @@ type Main struct {
 	Index       string
 	BufferSize  int
 	URLFile     string
+	Backend     string
+	OutputDir   string
+	SpoolDir    string
+	Throttle    pdk.ThrottleConfig
 
-	importer pdk.PilosaImporter
-	client   *pcli.Client
-	frames   map[string]*pcli.Frame
-	index    *pcli.Index
+	indexer pdk.Indexer
+	client  *pcli.Client
+	frames  map[string]*pcli.Frame
+	index   *pcli.Index
 
 	WeatherCache *WeatherCache
 }
@@ -95,19 +100,19 @@ func (m *Main) appendWeatherData() {
 
 		for _, ID := range response.Result().Bitmap.Bits {
 			// SetBit(weather.precip_code, ID, "precipitation_type")  // not implemented in weatherCache
-			m.importer.SetBit(condID, ID, "weather_condition")
+			m.addBit("weather_condition", ID, condID)
 
 			if err1 == nil && weather.Precipi > -100 {
-				m.importer.SetBit(precipID, ID, "precipitation_inches")
+				m.addBit("precipitation_inches", ID, precipID)
 			}
 			if err2 == nil {
-				m.importer.SetBit(tempID, ID, "temp_f")
+				m.addBit("temp_f", ID, tempID)
 			}
 			if err3 == nil {
-				m.importer.SetBit(pressureID, ID, "pressure_i")
+				m.addBit("pressure_i", ID, pressureID)
 			}
 			if err4 == nil && weather.Humidity > 10 {
-				m.importer.SetBit(humidID, ID, "humidity")
+				m.addBit("humidity", ID, humidID)
 			}
 		}
 	}
@@ -121,43 +126,33 @@ func (m *Main) Run() error {
 
 	readFrames := []string{"cab_type", "passenger_count", "total_amount_dollars", "pickup_time", "pickup_day", "pickup_mday", "pickup_month", "pickup_year", "drop_time", "drop_day", "drop_mday", "drop_month", "drop_year", "dist_miles", "duration_minutes", "speed_mph", "pickup_grid_id", "drop_grid_id"}
 	writeFrames := []string{"weather_condition", "precipitation_type", "precipitation_inches", "temp_f", "pressure_i", "humidity"}
-	m.importer = pdk.NewImportClient(m.PilosaHost, m.Index, writeFrames, m.BufferSize)
-
 	pilosaURI, err := pcli.NewURIFromAddress(m.PilosaHost)
 	if err != nil {
 		return fmt.Errorf("interpreting pilosaHost '%v': %v", m.PilosaHost, err)
 	}
-	setupClient := pcli.NewClientWithURI(pilosaURI)
-	m.client = setupClient
-	index, err := pcli.NewIndex(m.Index, &pcli.IndexOptions{})
-	m.index = index
-	if err != nil {
-		return fmt.Errorf("making index: %v", err)
+	m.client = pcli.NewClientWithURI(pilosaURI)
+	schema := pdk.Schema{Index: m.Index}
+	for _, frame := range readFrames {
+		schema.Frames = append(schema.Frames, pdk.FrameSpec{Name: frame})
 	}
-	err = setupClient.EnsureIndex(index)
+	m.index, m.frames, err = schema.Ensure(m.client)
 	if err != nil {
-		return fmt.Errorf("ensuring index existence: %v", err)
-	}
-	for _, frame := range readFrames {
-		fram, err := index.Frame(frame, &pcli.FrameOptions{})
-		m.frames[frame] = fram
-		if err != nil {
-			return fmt.Errorf("making frame: %v", err)
-		}
-		err = setupClient.EnsureFrame(fram)
-		if err != nil {
-			return fmt.Errorf("creating frame '%v': %v", frame, err)
-		}
+		return err
 	}
+	writeSchema := pdk.Schema{Index: m.Index}
 	for _, frame := range writeFrames {
-		fram, err := index.Frame(frame, &pcli.FrameOptions{})
-		if err != nil {
-			return fmt.Errorf("making frame: %v", err)
-		}
-		err = setupClient.EnsureFrame(fram)
-		if err != nil {
-			return fmt.Errorf("creating frame '%v': %v", frame, err)
-		}
+		writeSchema.Frames = append(writeSchema.Frames, pdk.FrameSpec{Name: frame})
+	}
+	m.indexer, err = pdk.NewIndexer(pdk.IndexerConfig{
+		Backend:   m.Backend,
+		Hosts:     []string{m.PilosaHost},
+		BatchSize: m.BufferSize,
+		OutputDir: m.OutputDir,
+		SpoolDir:  m.SpoolDir,
+		Throttle:  m.Throttle,
+	}, writeSchema)
+	if err != nil {
+		return fmt.Errorf("creating indexer: %v", err)
 	}
 
 	err = m.WeatherCache.ReadAll()
@@ -167,6 +162,16 @@ func (m *Main) Run() error {
 
 	m.appendWeatherData()
 
-	m.importer.Close()
+	err = m.indexer.Close()
+	if err != nil {
+		return fmt.Errorf("importing: %v", err)
+	}
 	return nil
 }
+
+func (m *Main) addBit(frame string, col, row uint64) {
+	err := m.indexer.AddBit(frame, col, row)
+	if err != nil {
+		log.Printf("adding bit: %v", err)
+	}
+}